@@ -3,44 +3,85 @@ package aggregator
 import (
 	"bytes"
 	"fmt"
+	"os"
+	"reflect"
 	"regexp"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"go.uber.org/zap"
 
 	"github.com/graphite-ng/carbon-relay-ng/clock"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
 	"github.com/graphite-ng/carbon-relay-ng/metrics"
+	"github.com/graphite-ng/carbon-relay-ng/storage"
 )
 
+// Options are the settings of an aggregator, see New
+type Options struct {
+	Fun                string `json:"fun"` // function, or comma separated list of functions, or rollup
+	Regex              string `json:"regex,omitempty"`
+	Prefix             string `json:"prefix,omitempty"` // derived from Regex if empty, see regexToPrefix
+	Sub                string `json:"substring,omitempty"`
+	Tag                string `json:"tag,omitempty"` // tag expression, see the matcher package
+	OutFmt             string
+	Cache              bool
+	Interval           uint          // expected interval between values in seconds, we will quantize to make sure alginment to interval-spaced timestamps
+	Wait               uint          // seconds to wait after quantized time value before flushing final outcome and ignoring future values that are sent too late.
+	DropRaw            bool          // drop raw values "consumed" by this aggregator
+	GroupBy            []string      `json:"groupBy,omitempty"`            // tag keys whose values are part of the output key, and carried onto the output
+	OutTags            encoding.Tags `json:"outTags,omitempty"`            // static tags added to the output
+	SketchAccuracy     float64       `json:"sketchAccuracy,omitempty"`     // relative accuracy of the percentiles, computed from a Sketch if set
+	StateDir           string        `json:"stateDir,omitempty"`           // where to save the aggregations in process when shutting down, see checkpoint.go
+	CheckpointInterval uint          `json:"checkpointInterval,omitempty"` // seconds between saves of the aggregations in process, 0 to only save them on shutdown
+	Resolutions        []string      `json:"resolutions,omitempty"`        // additional resolutions, see parseResolution
+	StorageSchemas     string        `json:"storageSchemas,omitempty"`     // storage-schemas.conf of the rollup function
+	StorageAggregation string        `json:"storageAggregation,omitempty"` // storage-aggregation.conf of the rollup function
+	LatePolicy         string        `json:"latePolicy,omitempty"`         // what to do with the points which come too late, see late.go
+	LateRoute          string        `json:"lateRoute,omitempty"`          // key of the route to send the late points to, with the route late policy
+	MaxSeries          uint          `json:"maxSeries,omitempty"`          // maximum number of output series in process, 0 for no limit, see series.go
+	OverflowPolicy     string        `json:"overflowPolicy,omitempty"`     // what to do with the points of new series beyond MaxSeries
+	Watermark          bool          `json:"watermark,omitempty"`          // whether buckets get due per the highest timestamp seen rather than the wall clock, see watermark.go
+	IdleTimeout        uint          `json:"idleTimeout,omitempty"`        // in watermark mode, seconds without points after which all the buckets are flushed
+}
+
 type Aggregator struct {
-	Fun          string `json:"fun"`
-	procConstr   func(val float64, ts uint32) Processor
-	in           chan encoding.Datapoint `json:"-"` // incoming metrics, already split in 3 fields
-	out          chan encoding.Datapoint // outgoing metrics
-	Regex        string                  `json:"regex,omitempty"`
-	Prefix       string                  `json:"prefix,omitempty"`
-	Sub          string                  `json:"substring,omitempty"`
-	regex        *regexp.Regexp          // compiled version of Regex
-	prefix       []byte                  // automatically generated based on Prefix or regex, for fast preMatch
-	substring    []byte                  // based on Sub, for fast preMatch
-	OutFmt       string
-	outFmt       []byte
-	Cache        bool
-	reCache      map[string]CacheEntry
-	reCacheMutex sync.Mutex
-	Interval     uint                 // expected interval between values in seconds, we will quantize to make sure alginment to interval-spaced timestamps
-	Wait         uint                 // seconds to wait after quantized time value before flushing final outcome and ignoring future values that are sent too late.
-	DropRaw      bool                 // drop raw values "consumed" by this aggregator
-	aggregations map[aggkey]Processor // aggregations in process: one for each quantized timestamp and output key, i.e. for each output metric.
-	snapReq      chan bool            // chan to issue snapshot requests on
-	snapResp     chan *Aggregator     // chan on which snapshot response gets sent
-	shutdown     chan struct{}        // chan used internally to shut down
-	wg           sync.WaitGroup       // tracks worker running state
-	now          func() time.Time     // returns current time. wraps time.Now except in some unit tests
-	tick         <-chan time.Time     // controls when to flush
-	am           *metrics.AggregatorMetrics
+	Options
+	// processor constructor of each resolution, see GetProcessorsConstructor
+	procConstrs    []func(val float64, ts uint32) Processor
+	multi          bool                    // whether the outputs are suffixed with their function name, see GetProcessorsConstructor
+	in             chan encoding.Datapoint `json:"-"` // incoming metrics, already split in 3 fields
+	out            chan encoding.Datapoint // outgoing metrics
+	regex          *regexp.Regexp          // compiled version of Regex
+	prefix         []byte                  // automatically generated based on Prefix or regex, for fast preMatch
+	substring      []byte                  // based on Sub, for fast preMatch
+	tag            *matcher.Matcher        // compiled version of Tag
+	outFmt         []byte
+	reCache        map[string]CacheEntry
+	reCacheMutex   sync.Mutex
+	resolutions    []resolution         // Interval followed by the parsed Resolutions
+	Series         int                  `json:"series"`         // number of output series in process, only set in snapshots
+	RejectedSeries uint64               `json:"rejectedSeries"` // number of points of new series rejected because of MaxSeries
+	aggregations   map[aggkey]Processor // aggregations in process: one for each quantized timestamp and output key, i.e. for each output metric.
+	snapReq        chan bool            // chan to issue snapshot requests on
+	snapResp       chan *Aggregator     // chan on which snapshot response gets sent
+	shutdown       chan struct{}        // chan used internally to shut down
+	wg             sync.WaitGroup       // tracks worker running state
+	now            func() time.Time     // returns current time. wraps time.Now except in some unit tests
+	tick           <-chan time.Time     // controls when to flush
+	checkpointTick *time.Ticker         // controls when to save a checkpoint, if CheckpointInterval is set
+	am             *metrics.AggregatorMetrics
+	rollup         *storage.RollupRules   // rules of the rollup function, see rollup.go
+	rollupCache    map[string]rollupEntry // rule of each output key of the rollup function
+	rollupConstrs  map[storage.RollupRule]func(val float64, ts uint32) Processor
+	late           chan LatePoint       // where to send the late points, with the route late policy
+	flushed        map[aggkey]Processor // buckets flushed less than wait ago, with the reemit late policy
+	series         map[series]int       // number of buckets in process of each output series
+	overflowKey    string               // output key of the overflow series, see overflowKey
+	maxTs          uint                 // highest timestamp seen, the clock in watermark mode
+	lastPoint      time.Time            // when the last point came, in watermark mode
 }
 
 // regexToPrefix inspects the regex and returns the longest static prefix part of the regex
@@ -71,70 +112,245 @@ func regexToPrefix(regex string) []byte {
 	return []byte(substr)
 }
 
-// New creates an aggregator
-func New(fun, regex, prefix, sub, outFmt string, cache bool, interval, wait uint, dropRaw bool, out chan encoding.Datapoint) (*Aggregator, error) {
-	return NewMocked(fun, regex, prefix, sub, outFmt, cache, interval, wait, dropRaw, out, 2000, time.Now, clock.AlignedTick(time.Duration(interval)*time.Second))
+// New creates an aggregator with the given options, which sends its output to out, and the late points to late with the route late policy.
+// GroupBy lists tag keys: metrics are aggregated separately per value of these tags,
+// which are set on the output along with OutTags.
+// If SketchAccuracy is set, percentiles are computed from a Sketch with that relative accuracy, rather than from all the values.
+// If StateDir is set, the aggregations in process are saved there on shutdown, and every CheckpointInterval seconds if set,
+// and resumed when the aggregator is created again.
+// Resolutions are additional resolutions to aggregate the same input to, as <interval>[/<step>][:<suffix>], see parseResolution.
+// The rollup function aggregates each output key like carbon would write it to the first stage of its retentions:
+// with the precision of its StorageSchemas, and the aggregation method and xFilesFactor of its StorageAggregation.
+// Interval is then the interval of the input points, see Rollup.
+// LatePolicy is what to do with the points which come too late for their bucket, see late.go:
+// with the route policy, they are sent on late, for the route with key LateRoute.
+// If MaxSeries is set, the points of new output series are dropped or aggregated into an overflow series per OverflowPolicy,
+// once there are that many series in process, see series.go.
+// In Watermark mode, buckets get due per the highest timestamp seen rather than the wall clock, so that replayed data is aggregated
+// like live data, and if IdleTimeout is set, all the buckets are flushed after that many seconds without points, see watermark.go.
+func New(o Options, out chan encoding.Datapoint, late chan LatePoint) (*Aggregator, error) {
+	return NewMocked(o, out, late, 2000, time.Now, clock.AlignedTick(time.Duration(o.Interval)*time.Second))
+}
+
+// NewPaused creates an aggregator like New, which only starts once Start is called, e.g. after the aggregator it replaces saved its checkpoint.
+// Its input is buffered meanwhile.
+func NewPaused(o Options, out chan encoding.Datapoint, late chan LatePoint) (*Aggregator, error) {
+	return newPaused(o, out, late, 2000, time.Now, clock.AlignedTick(time.Duration(o.Interval)*time.Second))
 }
 
-func NewMocked(fun, regex, prefix, sub, outFmt string, cache bool, interval, wait uint, dropRaw bool, out chan encoding.Datapoint, inBuf int, now func() time.Time, tick <-chan time.Time) (*Aggregator, error) {
-	regexObj, err := regexp.Compile(regex)
+func NewMocked(o Options, out chan encoding.Datapoint, late chan LatePoint, inBuf int, now func() time.Time, tick <-chan time.Time) (*Aggregator, error) {
+	a, err := newPaused(o, out, late, inBuf, now, tick)
+	if err != nil {
+		return nil, err
+	}
+	a.Start()
+	return a, nil
+}
+
+func newPaused(o Options, out chan encoding.Datapoint, late chan LatePoint, inBuf int, now func() time.Time, tick <-chan time.Time) (*Aggregator, error) {
+	regexObj, err := regexp.Compile(o.Regex)
+	if err != nil {
+		return nil, err
+	}
+	tagMatcher, err := matcher.NewWithTag("", "", "", o.Tag)
 	if err != nil {
 		return nil, err
 	}
-	procConstr, err := GetProcessorConstructor(fun)
+	if o.Interval == 0 {
+		return nil, fmt.Errorf("interval must be > 0")
+	}
+	if err := validateLatePolicy(o.LatePolicy, o.LateRoute, late); err != nil {
+		return nil, err
+	}
+	if err := validateOverflowPolicy(o.MaxSeries, o.OverflowPolicy); err != nil {
+		return nil, err
+	}
+	if o.IdleTimeout > 0 && !o.Watermark {
+		return nil, fmt.Errorf("idle timeout is only used in watermark mode")
+	}
+	var multi bool
+	var rollup *storage.RollupRules
+	var rollupConstrs map[storage.RollupRule]func(val float64, ts uint32) Processor
+	if o.Fun == "rollup" {
+		if o.StorageSchemas == "" || o.StorageAggregation == "" {
+			return nil, fmt.Errorf("the rollup function needs storage schemas and storage aggregations")
+		}
+		if len(o.Resolutions) > 0 {
+			return nil, fmt.Errorf("the rollup function doesn't support resolutions")
+		}
+		rollup, err = storage.NewRollupRules(o.StorageSchemas, o.StorageAggregation)
+		if err != nil {
+			return nil, err
+		}
+		rollupConstrs, err = newRollupConstructors(rollup, o.Interval)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if o.StorageSchemas != "" || o.StorageAggregation != "" {
+			return nil, fmt.Errorf("storage schemas and storage aggregations are only used by the rollup function")
+		}
+	}
+	res, err := parseResolutions(o.Resolutions, o.Interval)
 	if err != nil {
 		return nil, err
 	}
+	var procConstrs []func(val float64, ts uint32) Processor
+	if rollup == nil {
+		// functions like rate depend on the interval, hence a constructor per resolution
+		procConstrs = make([]func(val float64, ts uint32) Processor, len(res))
+		for i, r := range res {
+			procConstrs[i], multi, err = GetProcessorsConstructor(o.Fun, r.interval, o.SketchAccuracy)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, key := range o.GroupBy {
+		if key == "" || strings.ContainsAny(key, ";!^=") {
+			return nil, fmt.Errorf("invalid groupBy tag key %q", key)
+		}
+	}
+	if o.StateDir != "" {
+		if err := os.MkdirAll(o.StateDir, 0755); err != nil {
+			return nil, err
+		}
+	}
+	am := metrics.NewAggregatorMetrics(o.Prefix, nil)
+	if o.Prefix == "" {
+		o.Prefix = string(regexToPrefix(o.Regex))
+	}
 
 	a := &Aggregator{
-		Fun:          fun,
-		procConstr:   procConstr,
-		in:           make(chan encoding.Datapoint, inBuf),
-		out:          out,
-		Regex:        regex,
-		Sub:          sub,
-		regex:        regexObj,
-		substring:    []byte(sub),
-		OutFmt:       outFmt,
-		outFmt:       []byte(outFmt),
-		Cache:        cache,
-		Interval:     interval,
-		Wait:         wait,
-		DropRaw:      dropRaw,
-		aggregations: make(map[aggkey]Processor),
-		snapReq:      make(chan bool),
-		snapResp:     make(chan *Aggregator),
-		shutdown:     make(chan struct{}),
-		now:          now,
-		tick:         tick,
-		am:           metrics.NewAggregatorMetrics(prefix, nil),
-	}
-	if prefix != "" {
-		a.prefix = []byte(prefix)
-		a.Prefix = prefix
-	} else {
-		a.prefix = regexToPrefix(regex)
-		a.Prefix = string(a.prefix)
+		Options:       o,
+		procConstrs:   procConstrs,
+		multi:         multi,
+		in:            make(chan encoding.Datapoint, inBuf),
+		out:           out,
+		regex:         regexObj,
+		prefix:        []byte(o.Prefix),
+		substring:     []byte(o.Sub),
+		tag:           tagMatcher,
+		outFmt:        []byte(o.OutFmt),
+		resolutions:   res,
+		rollup:        rollup,
+		rollupConstrs: rollupConstrs,
+		late:          late,
+		series:        make(map[series]int),
+		overflowKey:   overflowKey(o.OutFmt),
+		lastPoint:     now(),
+		aggregations:  make(map[aggkey]Processor),
+		snapReq:       make(chan bool),
+		snapResp:      make(chan *Aggregator),
+		shutdown:      make(chan struct{}),
+		now:           now,
+		tick:          tick,
+		am:            am,
 	}
-	if cache {
+	if o.Cache {
 		a.reCache = make(map[string]CacheEntry)
 	}
+	if rollup != nil {
+		a.rollupCache = make(map[string]rollupEntry)
+	}
+	if o.LatePolicy == LateReemit {
+		a.flushed = make(map[aggkey]Processor)
+	}
+	return a, nil
+}
+
+// Start resumes the aggregations of the checkpoint, if any, and starts processing the input of an aggregator created with NewPaused.
+// An aggregator which is shut down before it is started doesn't save a checkpoint.
+func (a *Aggregator) Start() {
+	if a.StateDir != "" {
+		if err := a.loadCheckpoint(); err != nil {
+			zap.L().Error("can't resume the aggregations of the checkpoint, starting afresh", zap.String("aggregator", a.Regex), zap.String("file", a.checkpointFile()), zap.Error(err))
+		}
+		if a.CheckpointInterval > 0 {
+			a.checkpointTick = time.NewTicker(time.Duration(a.CheckpointInterval) * time.Second)
+		}
+	}
 	a.wg.Add(1)
 	go a.run()
-	return a, nil
+}
+
+// Equivalent returns whether the aggregator was created with the given options (see New),
+// in which case it can be kept running as is, e.g. on a config reload
+func (a *Aggregator) Equivalent(o Options) bool {
+	if o.Prefix == "" {
+		o.Prefix = string(regexToPrefix(o.Regex))
+	}
+	// nil and empty lists and tags are the same settings
+	if len(o.GroupBy) == 0 && len(a.GroupBy) == 0 {
+		o.GroupBy = a.GroupBy
+	}
+	if len(o.OutTags) == 0 && len(a.OutTags) == 0 {
+		o.OutTags = a.OutTags
+	}
+	if len(o.Resolutions) == 0 && len(a.Resolutions) == 0 {
+		o.Resolutions = a.Resolutions
+	}
+	return reflect.DeepEqual(a.Options, o)
 }
 
 type aggkey struct {
-	key string
-	ts  uint
+	key  string
+	tags string // values of the groupBy tags, see groupTags
+	ts   uint   // start of the bucket
+	res  int    // index of the resolution of the bucket in resolutions, 0 for the aggregator's own interval
 }
 
-func (a *Aggregator) AddOrCreate(key string, ts uint32, quantized uint, value float64) {
-	k := aggkey{
-		key,
-		quantized,
+// groupTags returns the groupBy tags of tags, as "key=value" pairs separated by ';'.
+// tags that are not set are left out, so metrics without them are aggregated together.
+func (a *Aggregator) groupTags(tags encoding.Tags) string {
+	if len(a.GroupBy) == 0 || len(tags) == 0 {
+		return ""
 	}
+	var buf strings.Builder
+	for _, key := range a.GroupBy {
+		val, ok := tags[key]
+		if !ok {
+			continue
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte(';')
+		}
+		buf.WriteString(key)
+		buf.WriteByte('=')
+		buf.WriteString(val)
+	}
+	return buf.String()
+}
+
+// outputTags returns the tags of the output of the aggregation with the given groupTags:
+// the grouped by tags and the static OutTags, which take precedence.
+func (a *Aggregator) outputTags(groupTags string) encoding.Tags {
+	if groupTags == "" && len(a.OutTags) == 0 {
+		return nil
+	}
+	tags := make(encoding.Tags, len(a.OutTags)+len(a.GroupBy))
+	if groupTags != "" {
+		// tag keys can't contain ';' nor '=', nor can tag values contain ';'
+		for _, pair := range strings.Split(groupTags, ";") {
+			kv := strings.SplitN(pair, "=", 2)
+			tags[kv[0]] = kv[1]
+		}
+	}
+	for k, v := range a.OutTags {
+		tags[k] = v
+	}
+	return tags
+}
+
+func (a *Aggregator) AddOrCreate(key string, ts uint32, quantized uint, value float64) {
 	a.am.ObserveTimestamp(ts)
+	a.observe(uint(ts))
+	a.addOrCreate(aggkey{key, "", quantized, 0}, ts, value)
+}
+
+// addOrCreate adds the point to its bucket, creating it if needed, and returns whether it was added.
+// Points are not added to buckets which were flushed already, except with the reemit late policy, see reemit
+func (a *Aggregator) addOrCreate(k aggkey, ts uint32, value float64) bool {
 	proc, ok := a.aggregations[k]
 	if ok {
 		proc.Add(value, ts)
@@ -145,30 +361,59 @@ func (a *Aggregator) AddOrCreate(key string, ts uint32, quantized uint, value fl
 		// a consequence of this is, that if your data stream runs consistently significantly behind
 		// real time, it may never be included in aggregates, but it's up to you to configure your wait
 		// parameter properly. You can use the rangeTracker and counterTooOldMetrics metrics to help with this
-		if quantized > uint(a.now().Unix())-a.Wait {
-			proc = a.procConstr(value, ts)
-			a.aggregations[k] = proc
-			return
+		// windows of other resolutions and rollup buckets are flushed when their last interval is, see last
+		if a.last(k)+a.Wait > a.clock() {
+			a.setBucket(k, a.newProcessor(k, value, ts))
+			return true
+		}
+		if a.flushed != nil && a.reemit(k, ts, value) {
+			return true
 		}
 		a.am.Dropped.Inc()
+		return false
+	}
+	return true
+}
+
+// newProcessor returns the processor of a new bucket
+func (a *Aggregator) newProcessor(k aggkey, val float64, ts uint32) Processor {
+	if a.rollup != nil {
+		return a.rollupRule(k.key).constr(val, ts)
+	}
+	return a.procConstrs[k.res](val, ts)
+}
+
+// last returns the start of the last interval of the bucket, which is due when that interval is
+func (a *Aggregator) last(k aggkey) uint {
+	if a.rollup != nil {
+		return k.ts + a.rollupRule(k.key).precision - a.Interval
 	}
+	return a.resolutions[k.res].last(k.ts, a.Interval)
 }
 
 // Flush finalizes and removes aggregations that are due
 func (a *Aggregator) Flush(ts uint) {
 	for k, proc := range a.aggregations {
-		if k.ts < ts {
+		if a.last(k) < ts {
+			if size, ok := memoryBytes(proc); ok {
+				a.am.BucketMemory.Observe(float64(size))
+			}
 			results, ok := proc.Flush()
 			if ok {
-				if len(results) == 1 {
-					a.out <- encoding.Datapoint{Name: k.key, Value: results[0].val, Timestamp: uint64(k.ts)}
+				tags := a.outputTags(k.tags)
+				key := k.key + a.resolutions[k.res].suffix
+				if !a.multi {
+					a.out <- encoding.Datapoint{Name: key, Value: results[0].val, Timestamp: uint64(k.ts), Tags: tags}
 				} else {
 					for _, result := range results {
-						a.out <- encoding.Datapoint{Name: fmt.Sprintf("%s.%s", k.key, result.fcnName), Value: results[0].val, Timestamp: uint64(k.ts)}
+						a.out <- encoding.Datapoint{Name: fmt.Sprintf("%s.%s", key, result.fcnName), Value: result.val, Timestamp: uint64(k.ts), Tags: tags}
 					}
 				}
 			}
-			delete(a.aggregations, k)
+			a.deleteBucket(k)
+			if a.flushed != nil {
+				a.flushed[k] = proc
+			}
 		}
 	}
 	//fmt.Println("flush done for ", a.now().Unix(), ". agg size now", len(a.aggregations), a.now())
@@ -180,7 +425,7 @@ func (a *Aggregator) Shutdown() {
 }
 
 func (a *Aggregator) AddMaybe(dp encoding.Datapoint) bool {
-	if !a.PreMatchString(dp.Name) {
+	if !a.PreMatchString(dp.Name) || !a.tag.MatchTags(dp.Tags) {
 		return false
 	}
 
@@ -269,22 +514,75 @@ func (a *Aggregator) matchWithCache(key []byte) (string, bool) {
 	return outKey, ok
 }
 
+func (a *Aggregator) add(msg encoding.Datapoint) {
+	// note, we rely here on the fact that the packet has already been validated
+	outKey, ok := a.matchWithCacheString(msg.Name)
+	if !ok {
+		return
+	}
+	//TODO: m.conraux Remove int casting logic
+	ts := uint(msg.Timestamp)
+	a.am.ObserveTimestamp(uint32(ts))
+	a.observe(ts)
+	orig := series{outKey, a.groupTags(msg.Tags)}
+	s, ok := a.admit(orig)
+	if !ok || s != orig {
+		a.uncache(msg.Name)
+		if !ok {
+			return
+		}
+	}
+	outKey, groupTags := s.key, s.tags
+	// the point is late if it's too late for its bucket of the aggregator's own interval:
+	// when the bucket was flushed already, or would be if it existed (see addOrCreate).
+	// a bucket which is past its wait but not flushed yet, until the next tick, still takes it.
+	// the windows of the other resolutions which are due later may still take it
+	own := aggkey{outKey, groupTags, ts - ts%a.Interval, 0}
+	if a.rollup != nil {
+		own.ts = ts - ts%a.rollupRule(outKey).precision
+	}
+	lateness := int64(a.clock()) - int64(a.last(own))
+	if lateness < 0 {
+		lateness = 0
+	}
+	a.am.Lateness.Observe(float64(lateness))
+	_, live := a.aggregations[own]
+	late := !live && uint(lateness) >= a.Wait
+
+	if a.rollup != nil {
+		a.addOrCreate(own, uint32(ts), msg.Value)
+	} else {
+		for i, r := range a.resolutions {
+			r.windows(ts, func(start uint) {
+				a.addOrCreate(aggkey{outKey, groupTags, start, i}, uint32(ts), msg.Value)
+			})
+		}
+	}
+	if late {
+		a.handleLate(msg)
+	}
+}
+
 func (a *Aggregator) run() {
+	var checkpointTick <-chan time.Time
+	if a.checkpointTick != nil {
+		checkpointTick = a.checkpointTick.C
+		defer a.checkpointTick.Stop()
+	}
 	for {
 		select {
 		case msg := <-a.in:
-			// note, we rely here on the fact that the packet has already been validated
-			outKey, ok := a.matchWithCacheString(msg.Name)
-			if !ok {
-				continue
-			}
-			//TODO: m.conraux Remove int casting logic
-			ts := uint(msg.Timestamp)
-			quantized := ts - (ts % a.Interval)
-			a.AddOrCreate(outKey, uint32(ts), quantized, msg.Value)
+			a.add(msg)
 		case now := <-a.tick:
-			thresh := now.Add(-time.Duration(a.Wait) * time.Second)
-			a.Flush(uint(thresh.Unix()))
+			if a.Watermark {
+				a.flushIdle(now)
+			} else {
+				thresh := now.Add(-time.Duration(a.Wait) * time.Second)
+				a.Flush(uint(thresh.Unix()))
+			}
+			if a.flushed != nil {
+				a.pruneFlushed(a.clock())
+			}
 
 			// if cache is enabled, clean it out of stale entries
 			// it's not ideal to block our channel while flushing AND cleaning up the cache
@@ -304,31 +602,45 @@ func (a *Aggregator) run() {
 				}
 				a.reCacheMutex.Unlock()
 			}
+			// likewise for the rules of the rollup function, though we keep looking while we see old entries
+			for k, v := range a.rollupCache {
+				if v.seen >= cutoff {
+					break
+				}
+				delete(a.rollupCache, k)
+			}
+		case <-checkpointTick:
+			a.checkpoint()
 		case <-a.snapReq:
 			aggs := make(map[aggkey]Processor)
 			for k := range a.aggregations {
 				aggs[k] = nil
 			}
 			s := &Aggregator{
-				Fun:          a.Fun,
-				procConstr:   a.procConstr,
-				Regex:        a.Regex,
-				Prefix:       a.Prefix,
-				Sub:          a.Sub,
-				prefix:       a.prefix,
-				substring:    a.substring,
-				OutFmt:       a.OutFmt,
-				Cache:        a.Cache,
-				Interval:     a.Interval,
-				Wait:         a.Wait,
-				DropRaw:      a.DropRaw,
-				aggregations: aggs,
-				now:          time.Now,
+				Options:        a.Options,
+				procConstrs:    a.procConstrs,
+				multi:          a.multi,
+				prefix:         a.prefix,
+				substring:      a.substring,
+				tag:            a.tag,
+				resolutions:    a.resolutions,
+				Series:         len(a.series),
+				RejectedSeries: a.RejectedSeries,
+				rollup:         a.rollup,
+				rollupConstrs:  a.rollupConstrs,
+				aggregations:   aggs,
+				now:            time.Now,
 			}
 			a.snapResp <- s
 		case <-a.shutdown:
-			thresh := a.now().Add(-time.Duration(a.Wait) * time.Second)
-			a.Flush(uint(thresh.Unix()))
+			// take in what was already sent to us, so that it is part of the checkpoint
+			for len(a.in) > 0 {
+				a.add(<-a.in)
+			}
+			if clock := a.clock(); clock > a.Wait {
+				a.Flush(clock - a.Wait)
+			}
+			a.checkpoint()
 			a.wg.Done()
 			return
 
@@ -1,6 +1,7 @@
 package aggregator
 
 import (
+	"sort"
 	"strconv"
 	"strings"
 	"testing"
@@ -27,6 +28,11 @@ func TestScanner(t *testing.T) {
 		p90   float64
 		p95   float64
 		p99   float64
+		count float64
+		med   float64
+		rng   float64
+		first float64
+		dist  float64
 	}{
 		{
 			[]float64{1, 2, 5, 4, 3},
@@ -45,6 +51,11 @@ func TestScanner(t *testing.T) {
 			5,
 			5,
 			5,
+			5,
+			3,
+			4,
+			1,
+			5,
 		},
 		{
 			[]float64{5, 4, 7, 4, 2, 5, 4, 9},
@@ -63,6 +74,11 @@ func TestScanner(t *testing.T) {
 			9,
 			9,
 			9,
+			8,
+			4.5,
+			7,
+			5,
+			5,
 		},
 		{
 			[]float64{6, 2, 3, 1},
@@ -81,6 +97,11 @@ func TestScanner(t *testing.T) {
 			6,
 			6,
 			6,
+			4,
+			2.5,
+			5,
+			6,
+			4,
 		},
 		// test out of order. this is the same dataset as the first one, but a bit shuffled
 		{
@@ -100,6 +121,11 @@ func TestScanner(t *testing.T) {
 			9,
 			9,
 			9,
+			8,
+			4.5,
+			7,
+			7,
+			5,
 		},
 		// Testing percentiles against NIST example from https://www.itl.nist.gov/div898/handbook/prc/section2/prc262.htm
 		{
@@ -119,10 +145,15 @@ func TestScanner(t *testing.T) {
 			95.19807,
 			95.199,
 			95.199,
+			12,
+			95.1579,
+			0.13799999999999102,
+			95.1772,
+			12,
 		},
 	}
 	testCase := func(i int, name string, in []float64, ts []uint32, exp map[string]float64) {
-		procConstr, err := GetProcessorConstructor(name)
+		procConstr, err := GetProcessorConstructor(name, 10)
 		if err != nil {
 			t.Fatalf("got err %q", err)
 		}
@@ -159,6 +190,12 @@ func TestScanner(t *testing.T) {
 		testCase(i, "stdev", e.in, e.ts, map[string]float64{"stdev": e.stdev})
 		testCase(i, "sum", e.in, e.ts, map[string]float64{"sum": e.sum})
 		testCase(i, "derive", e.in, e.ts, map[string]float64{"derive": e.deriv})
+		testCase(i, "count", e.in, e.ts, map[string]float64{"count": e.count})
+		testCase(i, "rate", e.in, e.ts, map[string]float64{"rate": e.sum / 10})
+		testCase(i, "median", e.in, e.ts, map[string]float64{"median": e.med})
+		testCase(i, "range", e.in, e.ts, map[string]float64{"range": e.rng})
+		testCase(i, "first", e.in, e.ts, map[string]float64{"first": e.first})
+		testCase(i, "countDistinct", e.in, e.ts, map[string]float64{"countDistinct": e.dist})
 		testCase(i, "percentiles", e.in, e.ts, map[string]float64{
 			"p25": e.p25,
 			"p50": e.p50,
@@ -172,8 +209,248 @@ func TestScanner(t *testing.T) {
 
 var r float64
 
+// testOptions returns the options of an aggregator with the given function, of the raw.* metrics to agg.*,
+// with an interval of 10 and a wait of 30, which the tests change as needed
+func testOptions(fun string) Options {
+	return Options{
+		Fun:      fun,
+		Regex:    `^raw\.(.*)$`,
+		OutFmt:   "agg.$1",
+		Interval: 10,
+		Wait:     30,
+	}
+}
+
+func TestAddMaybeTag(t *testing.T) {
+	out := make(chan encoding.Datapoint, 10)
+	tick := make(chan time.Time)
+	o := testOptions("sum")
+	o.Tag = "app:api,!canary"
+	o.DropRaw = true
+	agg, err := NewMocked(o, out, nil, 10, time.Now, tick)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	defer agg.Shutdown()
+	cases := []struct {
+		tags encoding.Tags
+		exp  bool
+	}{
+		{encoding.Tags{"app": "api"}, true},
+		{encoding.Tags{"app": "api", "canary": "1"}, false},
+		{encoding.Tags{"app": "front"}, false},
+		{encoding.Tags{}, false},
+	}
+	for i, c := range cases {
+		dp := encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: uint64(time.Now().Unix()), Tags: c.tags}
+		if got := agg.AddMaybe(dp); got != c.exp {
+			t.Errorf("case %d: expected AddMaybe to return %t for tags %v, got %t", i, c.exp, c.tags, got)
+		}
+	}
+}
+
+func TestGroupByTags(t *testing.T) {
+	out := make(chan encoding.Datapoint, 10)
+	tick := make(chan time.Time)
+	now := func() time.Time { return time.Unix(100, 0) }
+	o := testOptions("sum")
+	o.GroupBy = []string{"dc", "env"}
+	o.OutTags = encoding.Tags{"aggregated_by": "sum", "env": "all"}
+	agg, err := NewMocked(o, out, nil, 10, now, tick)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	defer agg.Shutdown()
+	in := []encoding.Tags{
+		{"dc": "paris", "env": "prod", "host": "a"},
+		{"dc": "paris", "env": "prod", "host": "b"},
+		{"dc": "london", "host": "c"},
+		{"host": "d"},
+		nil,
+	}
+	for _, tags := range in {
+		agg.AddMaybe(encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: 95, Tags: tags})
+	}
+	for i := 0; len(agg.Snapshot().aggregations) != 3; i++ {
+		if i == 100 {
+			t.Fatalf("expected 3 aggregations, got %d", len(agg.Snapshot().aggregations))
+		}
+		time.Sleep(time.Millisecond)
+	}
+	tick <- time.Unix(200, 0)
+
+	exp := map[string]float64{
+		"aggregated_by=sum;dc=paris;env=all":  2,
+		"aggregated_by=sum;dc=london;env=all": 1,
+		"aggregated_by=sum;env=all":           2,
+	}
+	for range exp {
+		dp := <-out
+		if dp.Name != "agg.requests" || dp.Timestamp != 90 {
+			t.Errorf("unexpected output %s", dp)
+		}
+		var pairs []string
+		for k, v := range dp.Tags {
+			pairs = append(pairs, k+"="+v)
+		}
+		sort.Strings(pairs)
+		key := strings.Join(pairs, ";")
+		val, ok := exp[key]
+		if !ok {
+			t.Errorf("unexpected output tags %v", dp.Tags)
+			continue
+		}
+		if dp.Value != val {
+			t.Errorf("expected %f for tags %v, got %f", val, dp.Tags, dp.Value)
+		}
+		delete(exp, key)
+	}
+}
+
+func TestMultiFunctions(t *testing.T) {
+	cases := []struct {
+		fun string
+		exp map[string]float64
+	}{
+		{"sum", map[string]float64{"agg.requests": 15}},
+		{"p50", map[string]float64{"agg.requests": 3}},
+		{"min,max,avg,p99", map[string]float64{
+			"agg.requests.min": 1,
+			"agg.requests.max": 5,
+			"agg.requests.avg": 3,
+			"agg.requests.p99": 5,
+		}},
+		{"count,rate,median,range,first,countDistinct", map[string]float64{
+			"agg.requests.count":         5,
+			"agg.requests.rate":          1.5,
+			"agg.requests.median":        3,
+			"agg.requests.range":         4,
+			"agg.requests.first":         1,
+			"agg.requests.countDistinct": 5,
+		}},
+		{"percentiles", map[string]float64{
+			"agg.requests.p25": 1.5,
+			"agg.requests.p50": 3,
+			"agg.requests.p75": 4.5,
+			"agg.requests.p90": 5,
+			"agg.requests.p95": 5,
+			"agg.requests.p99": 5,
+		}},
+	}
+	now := func() time.Time { return time.Unix(100, 0) }
+	for _, c := range cases {
+		out := make(chan encoding.Datapoint, 10)
+		o := testOptions(c.fun)
+		agg, err := NewMocked(o, out, nil, 10, now, nil)
+		if err != nil {
+			t.Fatalf("%s: couldn't create aggregation: %q", c.fun, err)
+		}
+		agg.Shutdown()
+		for i, v := range []float64{1, 2, 5, 4, 3} {
+			agg.AddOrCreate("agg.requests", uint32(91+i), 90, v)
+		}
+		agg.Flush(100)
+		if len(out) != len(c.exp) {
+			t.Errorf("%s: expected %d outputs, got %d", c.fun, len(c.exp), len(out))
+		}
+		for len(out) > 0 {
+			dp := <-out
+			exp, ok := c.exp[dp.Name]
+			if !ok {
+				t.Errorf("%s: unexpected output %s", c.fun, dp)
+				continue
+			}
+			if dp.Value != exp {
+				t.Errorf("%s: expected %f for %s, got %f", c.fun, exp, dp.Name, dp.Value)
+			}
+		}
+	}
+}
+
+func TestResolutions(t *testing.T) {
+	out := make(chan encoding.Datapoint, 100)
+	now := func() time.Time { return time.Unix(1000, 0) }
+	o := testOptions("sum")
+	o.Wait = 120
+	o.Resolutions = []string{"60:.1m", "30/10:.30s"}
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+	for ts := 900; ts < 1000; ts += 10 {
+		agg.add(encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: uint64(ts)})
+	}
+	agg.Flush(1000)
+
+	type point struct {
+		name string
+		ts   uint64
+	}
+	exp := make(map[point]float64)
+	for ts := uint64(900); ts < 1000; ts += 10 {
+		exp[point{"agg.requests", ts}] = 1
+	}
+	// the 1m window at 960 is not due yet
+	exp[point{"agg.requests.1m", 900}] = 6
+	// sliding windows of 30s, every 10s. the ones at 980 and 990 are not due yet
+	exp[point{"agg.requests.30s", 880}] = 1
+	exp[point{"agg.requests.30s", 890}] = 2
+	for ts := uint64(900); ts < 980; ts += 10 {
+		exp[point{"agg.requests.30s", ts}] = 3
+	}
+	if len(out) != len(exp) {
+		t.Errorf("expected %d outputs, got %d", len(exp), len(out))
+	}
+	for len(out) > 0 {
+		dp := <-out
+		if val, ok := exp[point{dp.Name, dp.Timestamp}]; !ok || val != dp.Value {
+			t.Errorf("unexpected output %s", dp)
+		}
+	}
+	if n := len(agg.aggregations); n != 3 {
+		t.Errorf("expected 3 aggregations not due yet, got %d", n)
+	}
+}
+
+func TestResolutionsRate(t *testing.T) {
+	out := make(chan encoding.Datapoint, 100)
+	now := func() time.Time { return time.Unix(1000, 0) }
+	o := testOptions("rate")
+	o.Wait = 120
+	o.Resolutions = []string{"60:.1m"}
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+	// a constant rate of 1/s
+	for ts := 900; ts < 1000; ts += 10 {
+		agg.add(encoding.Datapoint{Name: "raw.requests", Value: 10, Timestamp: uint64(ts)})
+	}
+	agg.Flush(1000)
+
+	// 10 outputs of the aggregator interval, and the 1m window at 900
+	if len(out) != 11 {
+		t.Errorf("expected 11 outputs, got %d", len(out))
+	}
+	for len(out) > 0 {
+		if dp := <-out; dp.Value != 1 {
+			t.Errorf("expected a rate of 1 over the interval of each resolution, got %s", dp)
+		}
+	}
+}
+
+func TestMultiFunctionsInvalid(t *testing.T) {
+	for _, fun := range []string{"sum,foo", "sum,sum", "p0", "p101", "pfoo", "pNaN", "p99,pnan", ""} {
+		if _, _, err := GetProcessorsConstructor(fun, 10, 0); err == nil {
+			t.Errorf("expected an error for functions %q", fun)
+		}
+	}
+}
+
 func BenchmarkProcessorMax(b *testing.B) {
-	procConstr, _ := GetProcessorConstructor("max")
+	procConstr, _ := GetProcessorConstructor("max", 10)
 	proc := procConstr(3, 0)
 	for i := 0; i < b.N; i++ {
 		for j := 0; j < 10; j++ {
@@ -291,7 +568,11 @@ func benchmarkAggregator(aggregates, pointsPerAggregate int, match string, cache
 	clock.AddTick(tick)
 	bufSize := 2 * aggregates * pointsPerAggregate
 
-	agg, err := NewMocked("sum", regex, "", "", outFmt, cache, 10, 30, false, out, bufSize, clock.Now, tick.C)
+	o := testOptions("sum")
+	o.Regex = regex
+	o.OutFmt = outFmt
+	o.Cache = cache
+	agg, err := NewMocked(o, out, nil, bufSize, clock.Now, tick.C)
 	if err != nil {
 		b.Fatalf("couldn't create aggregation: %q", err)
 	}
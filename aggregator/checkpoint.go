@@ -0,0 +1,125 @@
+package aggregator
+
+import (
+	"encoding/gob"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+// checkpoint is the state of the aggregations in process, which is saved to the state dir
+// so that they can be resumed after a restart
+type checkpoint struct {
+	Aggregations []checkpointEntry
+	MaxTs        uint // highest timestamp seen, the clock in watermark mode
+}
+
+type checkpointEntry struct {
+	Key   string
+	Tags  string
+	Ts    uint
+	Res   int    // see aggkey
+	State []byte // see Processor.GobEncode
+}
+
+// checkpointFile returns the path of the checkpoint of the aggregator.
+// it is named after the settings which determine the buckets and their processors (and hence the format of their state),
+// so that an aggregator never resumes the aggregations of an aggregator with different settings.
+func (a *Aggregator) checkpointFile() string {
+	outTags := make([]string, 0, len(a.OutTags))
+	for k, v := range a.OutTags {
+		outTags = append(outTags, k+"="+v)
+	}
+	sort.Strings(outTags)
+	h := fnv.New64a()
+	for _, s := range []string{a.Fun, a.Regex, a.Prefix, a.Sub, a.Tag, a.OutFmt, fmt.Sprint(a.Interval), fmt.Sprint(a.SketchAccuracy), strings.Join(a.GroupBy, ","), strings.Join(outTags, ";"), strings.Join(a.Resolutions, ","), a.StorageSchemas, a.StorageAggregation} {
+		h.Write([]byte(s))
+		h.Write([]byte{0})
+	}
+	return filepath.Join(a.StateDir, fmt.Sprintf("aggregator-%016x.gob", h.Sum64()))
+}
+
+// saveCheckpoint writes the aggregations in process to the checkpoint file.
+// The file is replaced atomically, so that a crash while saving leaves the previous checkpoint
+func (a *Aggregator) saveCheckpoint() error {
+	cp := checkpoint{Aggregations: make([]checkpointEntry, 0, len(a.aggregations)), MaxTs: a.maxTs}
+	for k, proc := range a.aggregations {
+		state, err := proc.GobEncode()
+		if err != nil {
+			return err
+		}
+		cp.Aggregations = append(cp.Aggregations, checkpointEntry{k.key, k.tags, k.ts, k.res, state})
+	}
+	path := a.checkpointFile()
+	tmp, err := ioutil.TempFile(a.StateDir, filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	err = gob.NewEncoder(tmp).Encode(cp)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
+
+// loadCheckpoint resumes the aggregations of the checkpoint file, if any, and removes it
+// so that they are not resumed again by another aggregator.
+// Aggregations which are due by now are flushed on the next tick, like they would have been if we kept running
+func (a *Aggregator) loadCheckpoint() error {
+	path := a.checkpointFile()
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var cp checkpoint
+	err = gob.NewDecoder(f).Decode(&cp)
+	f.Close()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	aggs := make(map[aggkey]Processor, len(cp.Aggregations))
+	for _, e := range cp.Aggregations {
+		if e.Res < 0 || e.Res >= len(a.resolutions) {
+			return fmt.Errorf("can't restore aggregation %s at %d: unknown resolution %d", e.Key, e.Ts, e.Res)
+		}
+		k := aggkey{e.Key, e.Tags, e.Ts, e.Res}
+		proc := a.newProcessor(k, 0, 0)
+		if err := proc.GobDecode(e.State); err != nil {
+			return fmt.Errorf("can't restore aggregation %s at %d: %s", e.Key, e.Ts, err)
+		}
+		aggs[k] = proc
+	}
+	for k, proc := range aggs {
+		a.setBucket(k, proc)
+	}
+	a.maxTs = cp.MaxTs
+	return nil
+}
+
+// checkpoint saves the aggregations in process, if checkpointing is enabled. failures are only logged
+func (a *Aggregator) checkpoint() {
+	if a.StateDir == "" {
+		return
+	}
+	if err := a.saveCheckpoint(); err != nil {
+		zap.L().Error("can't save aggregator checkpoint", zap.String("aggregator", a.Regex), zap.String("file", a.checkpointFile()), zap.Error(err))
+	}
+}
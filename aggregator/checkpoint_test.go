@@ -0,0 +1,153 @@
+package aggregator
+
+import (
+	"io/ioutil"
+	"math"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+func TestProcessorState(t *testing.T) {
+	in := []float64{1, 2, 5, math.Inf(1), 4, 3}
+	for _, fun := range []string{"avg", "delta", "derive", "last", "max", "min", "stdev", "sum", "percentiles", "p90", "min,max,avg,derive", "count", "countDistinct", "first", "median", "range", "rate", "median,p99,range"} {
+		constr, _, err := GetProcessorsConstructor(fun, 10, 0)
+		if err != nil {
+			t.Fatalf("%s: %s", fun, err)
+		}
+		proc := constr(in[0], 1)
+		for i, v := range in[1:] {
+			proc.Add(v, uint32(i+2))
+		}
+		state, err := proc.GobEncode()
+		if err != nil {
+			t.Fatalf("%s: can't encode state: %s", fun, err)
+		}
+		restored := constr(0, 0)
+		if err := restored.GobDecode(state); err != nil {
+			t.Fatalf("%s: can't decode state: %s", fun, err)
+		}
+		exp, expOk := proc.Flush()
+		got, gotOk := restored.Flush()
+		sortResults(exp)
+		sortResults(got)
+		if expOk != gotOk || !equalResults(exp, got) {
+			t.Errorf("%s: expected %v (%t) after restoring the state, got %v (%t)", fun, exp, expOk, got, gotOk)
+		}
+	}
+}
+
+func TestSketchState(t *testing.T) {
+	constr, _, err := GetProcessorsConstructor("p50,p99.9", 10, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proc := constr(-3, 1)
+	for i, v := range []float64{0, 2, 5, 1e6, 4, 3} {
+		proc.Add(v, uint32(i+2))
+	}
+	state, err := proc.GobEncode()
+	if err != nil {
+		t.Fatalf("can't encode state: %s", err)
+	}
+	restored := constr(0, 0)
+	if err := restored.GobDecode(state); err != nil {
+		t.Fatalf("can't decode state: %s", err)
+	}
+	exp, _ := proc.Flush()
+	got, _ := restored.Flush()
+	sortResults(exp)
+	sortResults(got)
+	if !equalResults(exp, got) {
+		t.Errorf("expected %v after restoring the state, got %v", exp, got)
+	}
+}
+
+func sortResults(results []processorResult) {
+	sort.Slice(results, func(i, j int) bool { return results[i].fcnName < results[j].fcnName })
+}
+
+// equalResults compares results, with NaN values being equal, as e.g. the stdev of infinite values is NaN
+func equalResults(a, b []processorResult) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].fcnName != b[i].fcnName {
+			return false
+		}
+		if a[i].val != b[i].val && !(math.IsNaN(a[i].val) && math.IsNaN(b[i].val)) {
+			return false
+		}
+	}
+	return true
+}
+
+func TestCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-aggregator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := func() time.Time { return time.Unix(100, 0) }
+	newAgg := func(fun string, out chan encoding.Datapoint) *Aggregator {
+		o := testOptions(fun)
+		o.GroupBy = []string{"dc"}
+		o.StateDir = dir
+		agg, err := NewMocked(o, out, nil, 10, now, nil)
+		if err != nil {
+			t.Fatalf("couldn't create aggregation: %q", err)
+		}
+		return agg
+	}
+
+	out := make(chan encoding.Datapoint, 10)
+	agg := newAgg("sum,max", out)
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: 95, Tags: encoding.Tags{"dc": "paris"}})
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.a", Value: 2, Timestamp: 96, Tags: encoding.Tags{"dc": "paris"}})
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.b", Value: 3, Timestamp: 85})
+	// the buckets are not due, so they are saved rather than flushed
+	agg.Shutdown()
+	if len(out) != 0 {
+		t.Fatalf("expected no output on shutdown, got %d points", len(out))
+	}
+
+	// an aggregator with other settings doesn't resume them
+	other := newAgg("sum", out)
+	if n := len(other.Snapshot().aggregations); n != 0 {
+		t.Errorf("expected an aggregator with other settings to start afresh, got %d aggregations", n)
+	}
+	other.Shutdown()
+
+	agg = newAgg("sum,max", out)
+	if n := len(agg.Snapshot().aggregations); n != 2 {
+		t.Fatalf("expected 2 resumed aggregations, got %d", n)
+	}
+	if _, err := os.Stat(agg.checkpointFile()); !os.IsNotExist(err) {
+		t.Errorf("expected the checkpoint to be removed once resumed, got %v", err)
+	}
+	agg.Shutdown()
+	agg.Flush(100)
+	exp := map[string]float64{
+		"agg.a.sum": 3,
+		"agg.a.max": 2,
+		"agg.b.sum": 3,
+		"agg.b.max": 3,
+	}
+	if len(out) != len(exp) {
+		t.Fatalf("expected %d points, got %d", len(exp), len(out))
+	}
+	for len(out) > 0 {
+		dp := <-out
+		if val, ok := exp[dp.Name]; !ok || val != dp.Value {
+			t.Errorf("unexpected output %s", dp)
+		}
+		if dp.Name == "agg.a.sum" && dp.Tags["dc"] != "paris" {
+			t.Errorf("expected the groupBy tags to be resumed, got %v", dp.Tags)
+		}
+	}
+}
@@ -0,0 +1,25 @@
+package aggregator
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+)
+
+func TestCountDistinctAccuracy(t *testing.T) {
+	for _, distinct := range []int{1, 10, 100, 1000, 10000, 100000, 1000000} {
+		proc := NewCountDistinct(0, 0)
+		for i := 1; i < distinct; i++ {
+			proc.Add(float64(i), 0)
+		}
+		// repeated values don't count
+		for i := 0; i < 1000; i++ {
+			proc.Add(float64(rand.Intn(distinct)), 0)
+		}
+		results, _ := proc.Flush()
+		// within 3 standard errors
+		if got := results[0].val; math.Abs(got-float64(distinct)) > 3*0.016*float64(distinct) {
+			t.Errorf("expected about %d distinct values, got %f", distinct, got)
+		}
+	}
+}
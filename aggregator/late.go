@@ -0,0 +1,82 @@
+package aggregator
+
+import (
+	"fmt"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+// late policies: what an aggregator does with the points which come too late for their bucket,
+// i.e. when the bucket of the aggregator's own interval was flushed already
+const (
+	LateDrop    = "drop"    // drop them, the default
+	LateForward = "forward" // forward them to the routes, which the table did already unless dropRaw is set
+	LateReemit  = "reemit"  // add them to their bucket, which is kept for another wait after its flush, and flush it again
+	LateRoute   = "route"   // send them to the route with key LateRoute
+)
+
+// LatePoint is a point which came too late for its bucket, to send to the route with key Route
+type LatePoint struct {
+	Route string
+	encoding.Datapoint
+}
+
+func validateLatePolicy(policy, route string, late chan LatePoint) error {
+	switch policy {
+	case "", LateDrop, LateForward, LateReemit:
+		if route != "" {
+			return fmt.Errorf("late route is only used by the %s late policy", LateRoute)
+		}
+	case LateRoute:
+		if route == "" {
+			return fmt.Errorf("the %s late policy needs a late route", LateRoute)
+		}
+		if late == nil {
+			return fmt.Errorf("the %s late policy needs a channel for the late points", LateRoute)
+		}
+	default:
+		return fmt.Errorf("unknown late policy '%s', need %s/%s/%s/%s", policy, LateDrop, LateForward, LateReemit, LateRoute)
+	}
+	return nil
+}
+
+// handleLate applies the late policy to msg, which came too late for its bucket.
+// with the reemit policy, it was added to its buckets already, see addOrCreate
+func (a *Aggregator) handleLate(msg encoding.Datapoint) {
+	a.am.Late.Inc()
+	switch a.LatePolicy {
+	case LateForward:
+		if a.DropRaw {
+			a.out <- msg
+		}
+	case LateRoute:
+		a.late <- LatePoint{a.LateRoute, msg}
+	}
+}
+
+// reemit adds a point to a bucket which was flushed already, if it was flushed less than wait ago:
+// the bucket is then flushed again, with the point, on the next flush.
+// It returns whether the point was added
+func (a *Aggregator) reemit(k aggkey, ts uint32, value float64) bool {
+	if a.last(k)+2*a.Wait <= a.clock() {
+		return false
+	}
+	proc, ok := a.flushed[k]
+	if ok {
+		proc.Add(value, ts)
+		delete(a.flushed, k)
+	} else {
+		proc = a.newProcessor(k, value, ts)
+	}
+	a.setBucket(k, proc)
+	return true
+}
+
+// pruneFlushed removes the flushed buckets kept for the reemit policy which are too old to be flushed again
+func (a *Aggregator) pruneFlushed(now uint) {
+	for k := range a.flushed {
+		if a.last(k)+2*a.Wait <= now {
+			delete(a.flushed, k)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+func TestLatePolicies(t *testing.T) {
+	cases := []struct {
+		policy  string
+		dropRaw bool
+		now     int64 // when the late point comes
+		expOut  []encoding.Datapoint
+		expLate []LatePoint
+	}{
+		{LateDrop, false, 1030, nil, nil},
+		{LateForward, false, 1030, nil, nil}, // the table routed it already
+		{LateForward, true, 1030, []encoding.Datapoint{{Name: "raw.requests", Value: 2, Timestamp: 995}}, nil},
+		{LateRoute, false, 1030, nil, []LatePoint{{"late", encoding.Datapoint{Name: "raw.requests", Value: 2, Timestamp: 995}}}},
+		{LateReemit, false, 1030, []encoding.Datapoint{{Name: "agg.requests", Value: 3, Timestamp: 990}}, nil},
+		{LateReemit, false, 1050, nil, nil}, // flushed more than wait ago
+	}
+	for _, c := range cases {
+		out := make(chan encoding.Datapoint, 10)
+		late := make(chan LatePoint, 10)
+		nowUnix := int64(1000)
+		now := func() time.Time { return time.Unix(nowUnix, 0) }
+		lateRoute := ""
+		if c.policy == LateRoute {
+			lateRoute = "late"
+		}
+		o := testOptions("sum")
+		o.DropRaw = c.dropRaw
+		o.LatePolicy = c.policy
+		o.LateRoute = lateRoute
+		agg, err := NewMocked(o, out, late, 10, now, nil)
+		if err != nil {
+			t.Fatalf("%s: couldn't create aggregation: %q", c.policy, err)
+		}
+		agg.Shutdown()
+
+		agg.add(encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: 990})
+		nowUnix = 1030
+		agg.Flush(1000)
+		if dp := <-out; dp.Name != "agg.requests" || dp.Value != 1 || dp.Timestamp != 990 {
+			t.Errorf("%s: unexpected output %s", c.policy, dp)
+		}
+
+		nowUnix = c.now
+		agg.add(encoding.Datapoint{Name: "raw.requests", Value: 2, Timestamp: 995})
+		agg.Flush(uint(nowUnix) - 30)
+		if len(out) != len(c.expOut) {
+			t.Errorf("%s: expected %d outputs, got %d", c.policy, len(c.expOut), len(out))
+		}
+		for i := 0; len(out) > 0 && i < len(c.expOut); i++ {
+			if dp := <-out; dp.Name != c.expOut[i].Name || dp.Value != c.expOut[i].Value || dp.Timestamp != c.expOut[i].Timestamp {
+				t.Errorf("%s: expected output %s, got %s", c.policy, c.expOut[i], dp)
+			}
+		}
+		if len(late) != len(c.expLate) {
+			t.Errorf("%s: expected %d late points, got %d", c.policy, len(c.expLate), len(late))
+		}
+		for i := 0; len(late) > 0 && i < len(c.expLate); i++ {
+			if lp := <-late; lp.Route != c.expLate[i].Route || lp.Name != c.expLate[i].Name || lp.Timestamp != c.expLate[i].Timestamp {
+				t.Errorf("%s: expected late point %v, got %v", c.policy, c.expLate[i], lp)
+			}
+		}
+	}
+}
+
+func TestLateBeforeFlush(t *testing.T) {
+	out := make(chan encoding.Datapoint, 10)
+	late := make(chan LatePoint, 10)
+	nowUnix := int64(102)
+	now := func() time.Time { return time.Unix(nowUnix, 0) }
+	o := testOptions("sum")
+	o.Wait = 5
+	o.LatePolicy = LateRoute
+	o.LateRoute = "late"
+	agg, err := NewMocked(o, out, late, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+
+	agg.add(encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: 100})
+	// past the wait of the bucket, but before the tick which flushes it
+	nowUnix = 107
+	agg.add(encoding.Datapoint{Name: "raw.requests", Value: 2, Timestamp: 101})
+	if len(late) != 0 {
+		t.Errorf("expected the point which was aggregated not to be late, got %v", <-late)
+	}
+	agg.Flush(105)
+	if dp := <-out; dp.Name != "agg.requests" || dp.Value != 3 || dp.Timestamp != 100 {
+		t.Errorf("unexpected output %s", dp)
+	}
+
+	// once flushed, the bucket doesn't take points anymore
+	agg.add(encoding.Datapoint{Name: "raw.requests", Value: 4, Timestamp: 102})
+	if len(late) != 1 {
+		t.Errorf("expected 1 late point, got %d", len(late))
+	}
+	if len(out) != 0 {
+		t.Errorf("expected no output, got %s", <-out)
+	}
+}
+
+func TestLatePolicyReemitPrune(t *testing.T) {
+	out := make(chan encoding.Datapoint, 10)
+	now := func() time.Time { return time.Unix(1000, 0) }
+	o := testOptions("sum")
+	o.LatePolicy = LateReemit
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+	agg.add(encoding.Datapoint{Name: "raw.requests", Value: 1, Timestamp: 990})
+	agg.Flush(1000)
+	if len(agg.flushed) != 1 {
+		t.Errorf("expected the flushed bucket to be kept, got %d", len(agg.flushed))
+	}
+	agg.pruneFlushed(1049)
+	if len(agg.flushed) != 1 {
+		t.Errorf("expected the flushed bucket to be kept until 2 waits after it, got %d", len(agg.flushed))
+	}
+	agg.pruneFlushed(1050)
+	if len(agg.flushed) != 0 {
+		t.Errorf("expected the flushed bucket to be pruned, got %d", len(agg.flushed))
+	}
+}
+
+func TestLatePolicyInvalid(t *testing.T) {
+	late := make(chan LatePoint)
+	cases := []struct {
+		policy string
+		route  string
+		late   chan LatePoint
+	}{
+		{"foo", "", late},
+		{LateRoute, "", late},
+		{LateRoute, "late", nil},
+		{LateDrop, "late", late},
+	}
+	for _, c := range cases {
+		o := testOptions("sum")
+		o.LatePolicy = c.policy
+		o.LateRoute = c.route
+		if _, err := NewMocked(o, nil, c.late, 10, time.Now, nil); err == nil {
+			t.Errorf("expected an error for late policy %q with late route %q", c.policy, c.route)
+		}
+	}
+}
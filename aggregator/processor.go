@@ -1,9 +1,12 @@
 package aggregator
 
 import (
+	"encoding/gob"
 	"fmt"
 	"math"
 	"sort"
+	"strconv"
+	"strings"
 )
 
 type processorResult struct {
@@ -35,6 +38,27 @@ func (a *Avg) Flush() ([]processorResult, bool) {
 	}, true
 }
 
+// Count aggregates to the number of values seen
+type Count struct {
+	cnt int
+}
+
+func NewCount(val float64, ts uint32) Processor {
+	return &Count{
+		cnt: 1,
+	}
+}
+
+func (c *Count) Add(val float64, ts uint32) {
+	c.cnt += 1
+}
+
+func (c *Count) Flush() ([]processorResult, bool) {
+	return []processorResult{
+		{fcnName: "count", val: float64(c.cnt)},
+	}, true
+}
+
 // Delta aggregates to the difference between highest and lowest value seen
 type Delta struct {
 	max float64
@@ -100,6 +124,26 @@ func (d *Derive) Flush() ([]processorResult, bool) {
 	}, true
 }
 
+// First aggregates to the first value seen
+type First struct {
+	val float64
+}
+
+func NewFirst(val float64, ts uint32) Processor {
+	return &First{
+		val: val,
+	}
+}
+
+func (f *First) Add(val float64, ts uint32) {
+}
+
+func (f *First) Flush() ([]processorResult, bool) {
+	return []processorResult{
+		{fcnName: "first", val: f.val},
+	}, true
+}
+
 // Last aggregates to the last value seen
 type Last struct {
 	val float64
@@ -201,26 +245,59 @@ func (s *Stdev) Flush() ([]processorResult, bool) {
 	}, true
 }
 
+func (s *Stdev) MemoryBytes() int {
+	return 32 + 8*cap(s.values)
+}
+
 // Percentiles aggregates to different percentiles
 type Percentiles struct {
 	percents map[string]float64
 	values   []float64
 }
 
-func NewPercentiles(val float64, ts uint32) Processor {
-	return &Percentiles{
-		values: []float64{val},
-		percents: map[string]float64{
-			"p25": 25,
-			"p50": 50,
-			"p75": 75,
-			"p90": 90,
-			"p95": 95,
-			"p99": 99,
-		},
+// defaultPercents are the percentiles of the percentiles function
+var defaultPercents = map[string]float64{
+	"p25": 25,
+	"p50": 50,
+	"p75": 75,
+	"p90": 90,
+	"p95": 95,
+	"p99": 99,
+}
+
+// parsePercentile parses fun, which is p followed by the percentile, e.g. p99 or p99.9.
+// name is the name of the output, with dots replaced so that it's a single node of the metric path
+func parsePercentile(fun string) (name string, percent float64, err error) {
+	percent, err = strconv.ParseFloat(strings.TrimPrefix(fun, "p"), 64)
+	if err != nil || !(percent > 0 && percent <= 100) { // NaN is neither
+		return "", 0, fmt.Errorf("invalid percentile '%s'", fun)
+	}
+	return strings.Replace(fun, ".", "_", -1), percent, nil
+}
+
+// NewPercentilesConstructor returns a constructor of Percentiles aggregating to the given percentiles
+func NewPercentilesConstructor(percents map[string]float64) func(val float64, ts uint32) Processor {
+	return func(val float64, ts uint32) Processor {
+		return &Percentiles{
+			values:   []float64{val},
+			percents: percents,
+		}
 	}
 }
 
+// NewPercentile returns a constructor of Percentiles aggregating to the single percentile fun, see parsePercentile
+func NewPercentile(fun string) (func(val float64, ts uint32) Processor, error) {
+	name, percent, err := parsePercentile(fun)
+	if err != nil {
+		return nil, err
+	}
+	return NewPercentilesConstructor(map[string]float64{name: percent}), nil
+}
+
+func NewPercentiles(val float64, ts uint32) Processor {
+	return NewPercentilesConstructor(defaultPercents)(val, ts)
+}
+
 func (p *Percentiles) Add(val float64, ts uint32) {
 	p.values = append(p.values, val)
 }
@@ -258,6 +335,59 @@ func (p *Percentiles) Flush() ([]processorResult, bool) {
 	return results, true
 }
 
+func (p *Percentiles) MemoryBytes() int {
+	return 64 + 8*cap(p.values)
+}
+
+// Range aggregates to the difference between highest and lowest value seen, like Delta
+type Range struct {
+	Delta
+}
+
+func NewRange(val float64, ts uint32) Processor {
+	return &Range{
+		Delta{
+			max: val,
+			min: val,
+		},
+	}
+}
+
+func (r *Range) Flush() ([]processorResult, bool) {
+	return []processorResult{
+		{fcnName: "range", val: r.max - r.min},
+	}, true
+}
+
+// Rate aggregates to the sum per second over the interval of the bucket
+type Rate struct {
+	sum      float64
+	interval uint
+}
+
+// NewRateConstructor returns a constructor of Rates for buckets of the given interval in seconds
+func NewRateConstructor(interval uint) func(val float64, ts uint32) Processor {
+	return func(val float64, ts uint32) Processor {
+		return &Rate{
+			sum:      val,
+			interval: interval,
+		}
+	}
+}
+
+func (r *Rate) Add(val float64, ts uint32) {
+	r.sum += val
+}
+
+func (r *Rate) Flush() ([]processorResult, bool) {
+	if r.interval == 0 {
+		return nil, false
+	}
+	return []processorResult{
+		{fcnName: "rate", val: r.sum / float64(r.interval)},
+	}, true
+}
+
 // Sum aggregates to sum
 type Sum struct {
 	sum float64
@@ -279,6 +409,52 @@ func (s *Sum) Flush() ([]processorResult, bool) {
 	}, true
 }
 
+// Multi aggregates to the outputs of several processors, see GetProcessorsConstructor
+type Multi struct {
+	procs []Processor
+}
+
+func (m *Multi) Add(val float64, ts uint32) {
+	for _, proc := range m.procs {
+		proc.Add(val, ts)
+	}
+}
+
+func (m *Multi) Flush() ([]processorResult, bool) {
+	var results []processorResult
+	for _, proc := range m.procs {
+		if procResults, ok := proc.Flush(); ok {
+			results = append(results, procResults...)
+		}
+	}
+	return results, len(results) > 0
+}
+
+// memoryReporter is implemented by the processors whose memory grows with the points they take in,
+// see AggregatorMetrics.BucketMemory
+type memoryReporter interface {
+	MemoryBytes() int
+}
+
+// memoryBytes returns the memory used by proc, if it (or one of the processors of a Multi) grows with the points it takes in
+func memoryBytes(proc Processor) (int, bool) {
+	switch p := proc.(type) {
+	case memoryReporter:
+		return p.MemoryBytes(), true
+	case *Multi:
+		var size int
+		var ok bool
+		for _, proc := range p.procs {
+			if s, grows := memoryBytes(proc); grows {
+				size += s
+				ok = true
+			}
+		}
+		return size, ok
+	}
+	return 0, false
+}
+
 type Processor interface {
 	// Add adds a point to aggregate
 	Add(val float64, ts uint32)
@@ -286,20 +462,37 @@ type Processor interface {
 	// the only reason why it would be non-valid is for aggregators that need
 	// more than 1 value but they didn't have enough to produce a useful result.
 	Flush() ([]processorResult, bool)
+	// GobEncode and GobDecode save and restore the state of the processor, see checkpoint.go.
+	// GobDecode is called on a processor created by the same constructor.
+	gob.GobEncoder
+	gob.GobDecoder
 }
 
-func GetProcessorConstructor(fun string) (func(val float64, ts uint32) Processor, error) {
+// GetProcessorConstructor returns the constructor for the function fun, for buckets of the given interval in seconds
+func GetProcessorConstructor(fun string, interval uint) (func(val float64, ts uint32) Processor, error) {
 	switch fun {
 	case "avg":
 		return NewAvg, nil
+	case "count":
+		return NewCount, nil
+	case "countDistinct":
+		return NewCountDistinct, nil
 	case "delta":
 		return NewDelta, nil
+	case "first":
+		return NewFirst, nil
 	case "last":
 		return NewLast, nil
 	case "max":
 		return NewMax, nil
+	case "median":
+		return NewPercentilesConstructor(map[string]float64{"median": 50}), nil
 	case "min":
 		return NewMin, nil
+	case "range":
+		return NewRange, nil
+	case "rate":
+		return NewRateConstructor(interval), nil
 	case "stdev":
 		return NewStdev, nil
 	case "sum":
@@ -309,5 +502,86 @@ func GetProcessorConstructor(fun string) (func(val float64, ts uint32) Processor
 	case "percentiles":
 		return NewPercentiles, nil
 	}
+	if strings.HasPrefix(fun, "p") {
+		return NewPercentile(fun)
+	}
 	return nil, fmt.Errorf("no such aggregation function '%s'", fun)
 }
+
+// GetProcessorsConstructor returns the constructor for the comma separated list of functions funs.
+// All the percentiles (the percentiles function, median and p<N>) are computed by a single processor: Percentiles,
+// or a Sketch with the given relative accuracy if it's not 0.
+// For several processors, the processor is a Multi with each of them.
+// multi is whether the processor may output several results, which are then told apart by their function name
+func GetProcessorsConstructor(funs string, interval uint, sketchAccuracy float64) (constr func(val float64, ts uint32) Processor, multi bool, err error) {
+	if sketchAccuracy < 0 || sketchAccuracy >= 1 {
+		return nil, false, fmt.Errorf("sketch accuracy must be between 0 and 1, got %f", sketchAccuracy)
+	}
+	names := strings.Split(funs, ",")
+	var constrs []func(val float64, ts uint32) Processor
+	var percents map[string]float64
+	addPercent := func(name string, percent float64) error {
+		if percents == nil {
+			percents = make(map[string]float64)
+		}
+		if _, ok := percents[name]; ok {
+			return fmt.Errorf("duplicate aggregation function '%s'", name)
+		}
+		percents[name] = percent
+		return nil
+	}
+	seen := make(map[string]bool, len(names))
+	for _, name := range names {
+		if seen[name] {
+			return nil, false, fmt.Errorf("duplicate aggregation function '%s'", name)
+		}
+		seen[name] = true
+		if name == "percentiles" {
+			for p, percent := range defaultPercents {
+				if err := addPercent(p, percent); err != nil {
+					return nil, false, err
+				}
+			}
+			continue
+		}
+		if name == "median" {
+			if err := addPercent(name, 50); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		if strings.HasPrefix(name, "p") {
+			p, percent, err := parsePercentile(name)
+			if err != nil {
+				return nil, false, err
+			}
+			if err := addPercent(p, percent); err != nil {
+				return nil, false, err
+			}
+			continue
+		}
+		c, err := GetProcessorConstructor(name, interval)
+		if err != nil {
+			return nil, false, err
+		}
+		constrs = append(constrs, c)
+	}
+	if percents != nil {
+		if sketchAccuracy > 0 {
+			constrs = append(constrs, NewSketchConstructor(percents, sketchAccuracy))
+		} else {
+			constrs = append(constrs, NewPercentilesConstructor(percents))
+		}
+	}
+	multi = len(names) > 1 || funs == "percentiles"
+	if len(constrs) == 1 {
+		return constrs[0], multi, nil
+	}
+	return func(val float64, ts uint32) Processor {
+		m := &Multi{procs: make([]Processor, len(constrs))}
+		for i, c := range constrs {
+			m.procs[i] = c(val, ts)
+		}
+		return m
+	}, multi, nil
+}
@@ -0,0 +1,209 @@
+package aggregator
+
+import (
+	"bytes"
+	"encoding/gob"
+	"errors"
+	"fmt"
+)
+
+var errMultiStateMismatch = errors.New("the number of processor states doesn't match the number of functions")
+
+// the state of the processors is encoded with gob rather than json, as it has to cope with NaN and infinite values
+
+func gobEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(v)
+	return buf.Bytes(), err
+}
+
+func gobDecode(data []byte, v interface{}) error {
+	return gob.NewDecoder(bytes.NewReader(data)).Decode(v)
+}
+
+type avgState struct {
+	Sum float64
+	Cnt int
+}
+
+func (a *Avg) GobEncode() ([]byte, error) {
+	return gobEncode(avgState{a.sum, a.cnt})
+}
+
+func (a *Avg) GobDecode(data []byte) error {
+	var s avgState
+	if err := gobDecode(data, &s); err != nil {
+		return err
+	}
+	a.sum, a.cnt = s.Sum, s.Cnt
+	return nil
+}
+
+func (c *Count) GobEncode() ([]byte, error) {
+	return gobEncode(c.cnt)
+}
+
+func (c *Count) GobDecode(data []byte) error {
+	return gobDecode(data, &c.cnt)
+}
+
+func (c *CountDistinct) GobEncode() ([]byte, error) {
+	return gobEncode(c.registers)
+}
+
+func (c *CountDistinct) GobDecode(data []byte) error {
+	c.registers = nil
+	if err := gobDecode(data, &c.registers); err != nil {
+		return err
+	}
+	if len(c.registers) != countDistinctRegisters {
+		return fmt.Errorf("expected %d countDistinct registers, got %d", countDistinctRegisters, len(c.registers))
+	}
+	return nil
+}
+
+type deltaState struct {
+	Max float64
+	Min float64
+}
+
+func (d *Delta) GobEncode() ([]byte, error) {
+	return gobEncode(deltaState{d.max, d.min})
+}
+
+func (d *Delta) GobDecode(data []byte) error {
+	var s deltaState
+	if err := gobDecode(data, &s); err != nil {
+		return err
+	}
+	d.max, d.min = s.Max, s.Min
+	return nil
+}
+
+type deriveState struct {
+	OldestTs  uint32
+	NewestTs  uint32
+	OldestVal float64
+	NewestVal float64
+}
+
+func (d *Derive) GobEncode() ([]byte, error) {
+	return gobEncode(deriveState{d.oldestTs, d.newestTs, d.oldestVal, d.newestVal})
+}
+
+func (d *Derive) GobDecode(data []byte) error {
+	var s deriveState
+	if err := gobDecode(data, &s); err != nil {
+		return err
+	}
+	d.oldestTs, d.newestTs, d.oldestVal, d.newestVal = s.OldestTs, s.NewestTs, s.OldestVal, s.NewestVal
+	return nil
+}
+
+func (f *First) GobEncode() ([]byte, error) {
+	return gobEncode(f.val)
+}
+
+func (f *First) GobDecode(data []byte) error {
+	return gobDecode(data, &f.val)
+}
+
+func (l *Last) GobEncode() ([]byte, error) {
+	return gobEncode(l.val)
+}
+
+func (l *Last) GobDecode(data []byte) error {
+	return gobDecode(data, &l.val)
+}
+
+func (m *Max) GobEncode() ([]byte, error) {
+	return gobEncode(m.val)
+}
+
+func (m *Max) GobDecode(data []byte) error {
+	return gobDecode(data, &m.val)
+}
+
+func (m *Min) GobEncode() ([]byte, error) {
+	return gobEncode(m.val)
+}
+
+func (m *Min) GobDecode(data []byte) error {
+	return gobDecode(data, &m.val)
+}
+
+type stdevState struct {
+	Sum    float64
+	Values []float64
+}
+
+func (s *Stdev) GobEncode() ([]byte, error) {
+	return gobEncode(stdevState{s.sum, s.values})
+}
+
+func (s *Stdev) GobDecode(data []byte) error {
+	var st stdevState
+	if err := gobDecode(data, &st); err != nil {
+		return err
+	}
+	s.sum, s.values = st.Sum, st.Values
+	return nil
+}
+
+// the percents are set by the constructor, only the values are part of the state
+func (p *Percentiles) GobEncode() ([]byte, error) {
+	return gobEncode(p.values)
+}
+
+func (p *Percentiles) GobDecode(data []byte) error {
+	p.values = nil
+	return gobDecode(data, &p.values)
+}
+
+// Range has the state of Delta, which it embeds
+
+// the interval is set by the constructor, only the sum is part of the state
+func (r *Rate) GobEncode() ([]byte, error) {
+	return gobEncode(r.sum)
+}
+
+func (r *Rate) GobDecode(data []byte) error {
+	return gobDecode(data, &r.sum)
+}
+
+func (s *Sum) GobEncode() ([]byte, error) {
+	return gobEncode(s.sum)
+}
+
+func (s *Sum) GobDecode(data []byte) error {
+	return gobDecode(data, &s.sum)
+}
+
+// the state of a Multi is the state of each of its processors, in order
+func (m *Multi) GobEncode() ([]byte, error) {
+	states := make([][]byte, len(m.procs))
+	for i, proc := range m.procs {
+		state, err := proc.GobEncode()
+		if err != nil {
+			return nil, err
+		}
+		states[i] = state
+	}
+	return gobEncode(states)
+}
+
+func (m *Multi) GobDecode(data []byte) error {
+	var states [][]byte
+	if err := gobDecode(data, &states); err != nil {
+		return err
+	}
+	if len(states) != len(m.procs) {
+		return errMultiStateMismatch
+	}
+	for i, state := range states {
+		if err := m.procs[i].GobDecode(state); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package aggregator
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// resolution is an additional resolution an aggregator aggregates to, from the same input as its own interval,
+// written to the output key suffixed with suffix.
+// If step is set, it's a sliding window of interval seconds, emitted every step seconds,
+// otherwise it's a tumbling window, like the aggregator's own interval.
+type resolution struct {
+	interval uint
+	step     uint
+	suffix   string
+}
+
+// parseResolution parses a resolution, as <interval>[/<step>][:<suffix>] in seconds, e.g. 300:.5m or 300/60:.5m_sliding.
+// The suffix defaults to .<interval>s for tumbling windows and .<interval>s_<step>s for sliding ones.
+// Intervals and steps must be multiples of the aggregator's interval, as they are flushed on its ticks.
+func parseResolution(s string, baseInterval uint) (resolution, error) {
+	var r resolution
+	spec := s
+	if pos := strings.Index(spec, ":"); pos >= 0 {
+		r.suffix = spec[pos+1:]
+		if r.suffix == "" {
+			return r, fmt.Errorf("invalid resolution %q: empty suffix", s)
+		}
+		spec = spec[:pos]
+	}
+	interval, step := spec, ""
+	if pos := strings.Index(spec, "/"); pos >= 0 {
+		interval, step = spec[:pos], spec[pos+1:]
+	}
+	i, err := strconv.ParseUint(interval, 10, 32)
+	if err != nil || i == 0 {
+		return r, fmt.Errorf("invalid resolution %q: interval must be a number of seconds > 0", s)
+	}
+	r.interval = uint(i)
+	if step != "" {
+		st, err := strconv.ParseUint(step, 10, 32)
+		if err != nil || st == 0 {
+			return r, fmt.Errorf("invalid resolution %q: step must be a number of seconds > 0", s)
+		}
+		r.step = uint(st)
+		if r.interval%r.step != 0 {
+			return r, fmt.Errorf("invalid resolution %q: interval must be a multiple of the step", s)
+		}
+		if r.step == r.interval {
+			r.step = 0 // that's just a tumbling window
+		}
+	}
+	if r.interval%baseInterval != 0 || r.step%baseInterval != 0 {
+		return r, fmt.Errorf("invalid resolution %q: interval and step must be multiples of the aggregator interval %d", s, baseInterval)
+	}
+	if r.suffix == "" {
+		r.suffix = fmt.Sprintf(".%ds", r.interval)
+		if r.step != 0 {
+			r.suffix += fmt.Sprintf("_%ds", r.step)
+		}
+	}
+	return r, nil
+}
+
+// parseResolutions parses the given resolutions, see parseResolution. their suffixes must be unique.
+// the aggregator's own interval is the first resolution, without suffix.
+func parseResolutions(specs []string, baseInterval uint) ([]resolution, error) {
+	resolutions := []resolution{{interval: baseInterval}}
+	suffixes := make(map[string]bool, len(specs))
+	for _, spec := range specs {
+		r, err := parseResolution(spec, baseInterval)
+		if err != nil {
+			return nil, err
+		}
+		if suffixes[r.suffix] {
+			return nil, fmt.Errorf("duplicate resolution suffix %q", r.suffix)
+		}
+		suffixes[r.suffix] = true
+		resolutions = append(resolutions, r)
+	}
+	return resolutions, nil
+}
+
+// windows calls fn with the start of each window the timestamp ts belongs to:
+// one for a tumbling window, interval/step for a sliding window
+func (r resolution) windows(ts uint, fn func(start uint)) {
+	if r.step == 0 {
+		fn(ts - ts%r.interval)
+		return
+	}
+	start := ts - ts%r.step
+	for n := r.interval / r.step; n > 0; n-- {
+		fn(start)
+		if start < r.step {
+			return
+		}
+		start -= r.step
+	}
+}
+
+// last returns the start of the last interval of the aggregator within the window starting at start.
+// the window is due when that interval is, i.e. for the aggregator's own interval, when the window itself is.
+func (r resolution) last(start, baseInterval uint) uint {
+	return start + r.interval - baseInterval
+}
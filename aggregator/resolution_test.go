@@ -0,0 +1,66 @@
+package aggregator
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResolution(t *testing.T) {
+	cases := []struct {
+		in  string
+		exp resolution
+		err bool
+	}{
+		{"60", resolution{60, 0, ".60s"}, false},
+		{"60:.1m", resolution{60, 0, ".1m"}, false},
+		{"300/60", resolution{300, 60, ".300s_60s"}, false},
+		{"300/60:_5m_sliding", resolution{300, 60, "_5m_sliding"}, false},
+		{"60/60:.1m", resolution{60, 0, ".1m"}, false},
+		{"60:", resolution{}, true},
+		{"0", resolution{}, true},
+		{"foo", resolution{}, true},
+		{"300/0", resolution{}, true},
+		{"300/70", resolution{}, true},  // not a multiple of the step
+		{"65", resolution{}, true},      // not a multiple of the aggregator interval
+		{"300/15", resolution{}, true},  // step not a multiple of the aggregator interval
+		{"-60:.1m", resolution{}, true}, // negative
+	}
+	for _, c := range cases {
+		got, err := parseResolution(c.in, 10)
+		if c.err {
+			if err == nil {
+				t.Errorf("%q: expected an error, got %v", c.in, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error %s", c.in, err)
+		} else if got != c.exp {
+			t.Errorf("%q: expected %v, got %v", c.in, c.exp, got)
+		}
+	}
+	if _, err := parseResolutions([]string{"60:.1m", "120:.1m"}, 10); err == nil {
+		t.Errorf("expected an error for duplicate suffixes")
+	}
+}
+
+func TestResolutionWindows(t *testing.T) {
+	cases := []struct {
+		res resolution
+		ts  uint
+		exp []uint
+	}{
+		{resolution{60, 0, ""}, 125, []uint{120}},
+		{resolution{60, 0, ""}, 120, []uint{120}},
+		{resolution{30, 10, ""}, 125, []uint{120, 110, 100}},
+		{resolution{30, 10, ""}, 120, []uint{120, 110, 100}},
+		{resolution{30, 10, ""}, 15, []uint{10, 0}}, // no windows before the epoch
+	}
+	for _, c := range cases {
+		var got []uint
+		c.res.windows(c.ts, func(start uint) { got = append(got, start) })
+		if !reflect.DeepEqual(got, c.exp) {
+			t.Errorf("%v at %d: expected windows %v, got %v", c.res, c.ts, c.exp, got)
+		}
+	}
+}
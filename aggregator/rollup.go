@@ -0,0 +1,142 @@
+package aggregator
+
+import (
+	"fmt"
+	"math/bits"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/storage"
+)
+
+// rollupFunctions maps the aggregation methods of storage-aggregation.conf to our functions
+var rollupFunctions = map[string]string{
+	"average": "avg",
+	"last":    "last",
+	"max":     "max",
+	"min":     "min",
+	"sum":     "sum",
+}
+
+// Rollup aggregates the points of a bucket with proc, but only outputs if at least xFilesFactor of the intervals
+// of the bucket got points, like whisper does when rolling up points into a lower precision archive
+type Rollup struct {
+	proc     Processor
+	start    uint32
+	interval uint32
+	slots    uint32   // number of intervals in the bucket
+	known    []uint64 // bitset of the intervals which got points
+	xff      float64
+}
+
+// newRollupConstructor returns a constructor of Rollups of buckets of precision seconds, made of intervals of interval seconds
+func newRollupConstructor(constr func(val float64, ts uint32) Processor, precision, interval uint, xff float64) func(val float64, ts uint32) Processor {
+	slots := uint32(precision / interval)
+	return func(val float64, ts uint32) Processor {
+		r := &Rollup{
+			proc:     constr(val, ts),
+			start:    ts - ts%uint32(precision),
+			interval: uint32(interval),
+			slots:    slots,
+			known:    make([]uint64, (slots+63)/64),
+			xff:      xff,
+		}
+		r.markKnown(ts)
+		return r
+	}
+}
+
+func (r *Rollup) markKnown(ts uint32) {
+	if ts < r.start {
+		return
+	}
+	slot := (ts - r.start) / r.interval
+	if slot < r.slots {
+		r.known[slot/64] |= 1 << (slot % 64)
+	}
+}
+
+func (r *Rollup) Add(val float64, ts uint32) {
+	r.proc.Add(val, ts)
+	r.markKnown(ts)
+}
+
+func (r *Rollup) Flush() ([]processorResult, bool) {
+	var known int
+	for _, b := range r.known {
+		known += bits.OnesCount64(b)
+	}
+	if float64(known)/float64(r.slots) < r.xff {
+		return nil, false
+	}
+	return r.proc.Flush()
+}
+
+type rollupState struct {
+	Proc  []byte
+	Start uint32
+	Known []uint64
+}
+
+// the interval, slots and xFilesFactor are set by the constructor
+func (r *Rollup) GobEncode() ([]byte, error) {
+	proc, err := r.proc.GobEncode()
+	if err != nil {
+		return nil, err
+	}
+	return gobEncode(rollupState{proc, r.start, r.known})
+}
+
+func (r *Rollup) GobDecode(data []byte) error {
+	var s rollupState
+	if err := gobDecode(data, &s); err != nil {
+		return err
+	}
+	if len(s.Known) != len(r.known) {
+		return fmt.Errorf("expected %d words of known intervals, got %d", len(r.known), len(s.Known))
+	}
+	r.start, r.known = s.Start, s.Known
+	return r.proc.GobDecode(s.Proc)
+}
+
+// rollupEntry is the rule of an output key of a rollup aggregator, cached in rollupCache
+type rollupEntry struct {
+	precision uint // seconds
+	constr    func(val float64, ts uint32) Processor
+	seen      uint32
+}
+
+// newRollupConstructors returns the constructor of each rule of rules, for an aggregator of the given interval.
+// The precisions must be multiples of the interval, and the aggregation methods supported
+func newRollupConstructors(rules *storage.RollupRules, interval uint) (map[storage.RollupRule]func(val float64, ts uint32) Processor, error) {
+	constrs := make(map[storage.RollupRule]func(val float64, ts uint32) Processor)
+	err := rules.Each(func(rule storage.RollupRule) error {
+		precision := uint(rule.Precision / time.Second)
+		if precision%interval != 0 {
+			return fmt.Errorf("precision %s of a storage schema is not a multiple of the aggregator interval %d", rule.Precision, interval)
+		}
+		fun, ok := rollupFunctions[rule.AggregationMethod]
+		if !ok {
+			return fmt.Errorf("unsupported aggregation method '%s', need average/last/max/min/sum", rule.AggregationMethod)
+		}
+		constr, err := GetProcessorConstructor(fun, precision)
+		if err != nil {
+			return err
+		}
+		constrs[rule] = newRollupConstructor(constr, precision, interval, rule.XFilesFactor)
+		return nil
+	})
+	return constrs, err
+}
+
+// rollupRule returns the rule of the output key of a rollup aggregator
+func (a *Aggregator) rollupRule(key string) rollupEntry {
+	entry, ok := a.rollupCache[key]
+	if !ok {
+		rule := a.rollup.Match(key)
+		entry.precision = uint(rule.Precision / time.Second)
+		entry.constr = a.rollupConstrs[rule]
+	}
+	entry.seen = uint32(a.now().Unix())
+	a.rollupCache[key] = entry
+	return entry
+}
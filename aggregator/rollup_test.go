@@ -0,0 +1,153 @@
+package aggregator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+func writeRollupConf(t *testing.T, schemas, aggregation string) (string, string, func()) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-rollup")
+	if err != nil {
+		t.Fatal(err)
+	}
+	schemasFile := filepath.Join(dir, "storage-schemas.conf")
+	aggregationFile := filepath.Join(dir, "storage-aggregation.conf")
+	if err := ioutil.WriteFile(schemasFile, []byte(schemas), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(aggregationFile, []byte(aggregation), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return schemasFile, aggregationFile, func() { os.RemoveAll(dir) }
+}
+
+const rollupSchemas = `
+[carbon]
+pattern = ^agg\.carbon\.
+retentions = 60s:1d
+
+[default]
+pattern = .*
+retentions = 30s:1d,5m:30d
+`
+
+const rollupAggregation = `
+[max]
+pattern = \.max$
+xFilesFactor = 0
+aggregationMethod = max
+
+[default]
+pattern = .*
+xFilesFactor = 0.5
+aggregationMethod = average
+`
+
+func TestRollup(t *testing.T) {
+	schemas, aggregation, cleanup := writeRollupConf(t, rollupSchemas, rollupAggregation)
+	defer cleanup()
+
+	out := make(chan encoding.Datapoint, 10)
+	now := func() time.Time { return time.Unix(1000, 0) }
+	o := testOptions("rollup")
+	o.Wait = 200
+	o.StorageSchemas = schemas
+	o.StorageAggregation = aggregation
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+	in := []encoding.Datapoint{
+		// 3 of the 6 intervals of a minute, which is enough for the xFilesFactor of 0.5
+		{Name: "raw.carbon.a", Value: 1, Timestamp: 900},
+		{Name: "raw.carbon.a", Value: 2, Timestamp: 910},
+		{Name: "raw.carbon.a", Value: 3, Timestamp: 925},
+		// 1 of 6 isn't
+		{Name: "raw.carbon.b", Value: 1, Timestamp: 900},
+		// 30s buckets, any number of points is enough for max
+		{Name: "raw.x.max", Value: 5, Timestamp: 930},
+		{Name: "raw.x.max", Value: 7, Timestamp: 950},
+		{Name: "raw.y", Value: 1, Timestamp: 960},
+		{Name: "raw.y", Value: 3, Timestamp: 975},
+		// not due yet
+		{Name: "raw.y", Value: 1, Timestamp: 990},
+	}
+	for _, dp := range in {
+		agg.add(dp)
+	}
+	agg.Flush(1000)
+	exp := map[string]encoding.Datapoint{
+		"agg.carbon.a": {Name: "agg.carbon.a", Value: 2, Timestamp: 900},
+		"agg.x.max":    {Name: "agg.x.max", Value: 7, Timestamp: 930},
+		"agg.y":        {Name: "agg.y", Value: 2, Timestamp: 960},
+	}
+	if len(out) != len(exp) {
+		t.Errorf("expected %d outputs, got %d", len(exp), len(out))
+	}
+	for len(out) > 0 {
+		dp := <-out
+		if e, ok := exp[dp.Name]; !ok || e.Value != dp.Value || e.Timestamp != dp.Timestamp {
+			t.Errorf("unexpected output %s", dp)
+		}
+	}
+	if n := len(agg.aggregations); n != 1 {
+		t.Errorf("expected 1 aggregation not due yet, got %d", n)
+	}
+
+	// the state of a bucket includes its known intervals
+	proc := agg.newProcessor(aggkey{key: "agg.carbon.c"}, 1, 900)
+	state, err := proc.GobEncode()
+	if err != nil {
+		t.Fatalf("can't encode state: %s", err)
+	}
+	restored := agg.newProcessor(aggkey{key: "agg.carbon.c"}, 0, 0)
+	if err := restored.GobDecode(state); err != nil {
+		t.Fatalf("can't decode state: %s", err)
+	}
+	restored.Add(1, 930)
+	restored.Add(1, 950)
+	if _, ok := restored.Flush(); !ok {
+		t.Errorf("expected the restored bucket to be filled enough")
+	}
+}
+
+func TestRollupInvalid(t *testing.T) {
+	schemas, aggregation, cleanup := writeRollupConf(t, rollupSchemas, rollupAggregation)
+	defer cleanup()
+	_, unsupportedAggregation, cleanup2 := writeRollupConf(t, rollupSchemas, "[default]\npattern = .*\naggregationMethod = avg_zero\n")
+	defer cleanup2()
+
+	cases := []struct {
+		fun         string
+		interval    uint
+		resolutions []string
+		schemas     string
+		aggregation string
+	}{
+		{"rollup", 10, nil, "", ""},
+		{"rollup", 10, nil, schemas, ""},
+		{"sum", 10, nil, schemas, aggregation},
+		{"rollup", 20, nil, schemas, aggregation}, // 30s is not a multiple of 20s
+		{"rollup", 10, []string{"60"}, schemas, aggregation},
+		{"rollup", 10, nil, schemas, unsupportedAggregation},
+		{"rollup", 10, nil, schemas, "/does/not/exist"},
+	}
+	for i, c := range cases {
+		o := testOptions(c.fun)
+		o.Interval = c.interval
+		o.Wait = 60
+		o.Resolutions = c.resolutions
+		o.StorageSchemas = c.schemas
+		o.StorageAggregation = c.aggregation
+		_, err := NewMocked(o, nil, nil, 10, time.Now, nil)
+		if err == nil {
+			t.Errorf("case %d: expected an error", i)
+		}
+	}
+}
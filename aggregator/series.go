@@ -0,0 +1,90 @@
+package aggregator
+
+import (
+	"fmt"
+	"regexp"
+)
+
+// overflow policies: what an aggregator does with the points of new series, once it has MaxSeries series in process
+const (
+	OverflowDrop      = "drop"      // drop them, the default
+	OverflowAggregate = "aggregate" // aggregate them into the overflow series, see overflowKey
+)
+
+// series is an output series of an aggregator: an output key, with the values of its groupBy tags
+type series struct {
+	key  string
+	tags string
+}
+
+// templateRef matches the references to the groups of the regex in an output format, see regexp.Expand
+var templateRef = regexp.MustCompile(`\$(\$|\w+|\{\w+\})`)
+
+func validateOverflowPolicy(maxSeries uint, policy string) error {
+	switch policy {
+	case "", OverflowDrop, OverflowAggregate:
+		if maxSeries == 0 && policy != "" {
+			return fmt.Errorf("overflow policy is only used with max series")
+		}
+	default:
+		return fmt.Errorf("unknown overflow policy '%s', need %s/%s", policy, OverflowDrop, OverflowAggregate)
+	}
+	return nil
+}
+
+// overflowKey returns the output key of the overflow series: the output format,
+// with all its references to the groups of the regex replaced by __overflow__, e.g. agg.__overflow__ for agg.$1
+func overflowKey(outFmt string) string {
+	return templateRef.ReplaceAllStringFunc(outFmt, func(ref string) string {
+		if ref == "$$" {
+			return "$"
+		}
+		return "__overflow__"
+	})
+}
+
+// admit returns the series to aggregate a point of s into, and whether to aggregate it at all:
+// the points of new series are rejected once there are MaxSeries series in process, per the overflow policy.
+// The overflow series is always admitted, so there can be MaxSeries+1 series in process
+func (a *Aggregator) admit(s series) (series, bool) {
+	if a.MaxSeries == 0 || a.series[s] > 0 || uint(len(a.series)) < a.MaxSeries {
+		return s, true
+	}
+	a.RejectedSeries++
+	a.am.RejectedSeries.Inc()
+	if a.OverflowPolicy == OverflowAggregate {
+		return series{a.overflowKey, ""}, true
+	}
+	return s, false
+}
+
+// setBucket adds a bucket to the aggregations in process, and counts it for its series
+func (a *Aggregator) setBucket(k aggkey, proc Processor) {
+	a.aggregations[k] = proc
+	s := series{k.key, k.tags}
+	a.series[s]++
+	if a.series[s] == 1 {
+		a.am.Series.Set(float64(len(a.series)))
+	}
+}
+
+// deleteBucket removes a bucket from the aggregations in process, and its series when it was its last bucket
+func (a *Aggregator) deleteBucket(k aggkey) {
+	delete(a.aggregations, k)
+	s := series{k.key, k.tags}
+	a.series[s]--
+	if a.series[s] <= 0 {
+		delete(a.series, s)
+		a.am.Series.Set(float64(len(a.series)))
+	}
+}
+
+// uncache removes the match of key from the regex cache, so that the cache doesn't grow with the input of the rejected series
+func (a *Aggregator) uncache(key string) {
+	if a.reCache == nil {
+		return
+	}
+	a.reCacheMutex.Lock()
+	delete(a.reCache, key)
+	a.reCacheMutex.Unlock()
+}
@@ -0,0 +1,117 @@
+package aggregator
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+func TestOverflowKey(t *testing.T) {
+	cases := map[string]string{
+		"agg.$1":            "agg.__overflow__",
+		"agg.${1}_sum.$2":   "agg.__overflow___sum.__overflow__",
+		"${app}.all.${env}": "__overflow__.all.__overflow__",
+		"agg.static":        "agg.static",
+		"cost.$$.$1":        "cost.$.__overflow__",
+	}
+	for outFmt, exp := range cases {
+		if key := overflowKey(outFmt); key != exp {
+			t.Errorf("expected overflow key %q for %q, got %q", exp, outFmt, key)
+		}
+	}
+}
+
+func TestMaxSeries(t *testing.T) {
+	for _, policy := range []string{OverflowDrop, OverflowAggregate} {
+		out := make(chan encoding.Datapoint, 10)
+		now := func() time.Time { return time.Unix(1000, 0) }
+		o := testOptions("sum")
+		o.Cache = true
+		o.MaxSeries = 2
+		o.OverflowPolicy = policy
+		agg, err := NewMocked(o, out, nil, 10, now, nil)
+		if err != nil {
+			t.Fatalf("%s: couldn't create aggregation: %q", policy, err)
+		}
+		agg.Shutdown()
+
+		for _, name := range []string{"raw.a", "raw.b", "raw.a", "raw.c", "raw.d"} {
+			agg.add(encoding.Datapoint{Name: name, Value: 1, Timestamp: 990})
+		}
+		if agg.RejectedSeries != 2 {
+			t.Errorf("%s: expected 2 rejected points, got %d", policy, agg.RejectedSeries)
+		}
+		for _, name := range []string{"raw.c", "raw.d"} {
+			if _, ok := agg.reCache[name]; ok {
+				t.Errorf("%s: expected the input of rejected series not to be cached, got %s", policy, name)
+			}
+		}
+
+		agg.Flush(1000)
+		exp := map[string]float64{"agg.a": 2, "agg.b": 1}
+		if policy == OverflowAggregate {
+			exp["agg.__overflow__"] = 2
+		}
+		if len(out) != len(exp) {
+			t.Errorf("%s: expected %d outputs, got %d", policy, len(exp), len(out))
+		}
+		for len(out) > 0 {
+			dp := <-out
+			if val, ok := exp[dp.Name]; !ok || val != dp.Value {
+				t.Errorf("%s: unexpected output %s", policy, dp)
+			}
+		}
+		if len(agg.series) != 0 {
+			t.Errorf("%s: expected no series once all flushed, got %d", policy, len(agg.series))
+		}
+
+		// the series which are flushed no longer count
+		agg.add(encoding.Datapoint{Name: "raw.c", Value: 1, Timestamp: 1000})
+		if _, ok := agg.series[series{"agg.c", ""}]; !ok {
+			t.Errorf("%s: expected a new series to be admitted once others are flushed", policy)
+		}
+	}
+}
+
+func TestMaxSeriesSnapshot(t *testing.T) {
+	out := make(chan encoding.Datapoint, 10)
+	o := testOptions("sum")
+	o.MaxSeries = 1
+	agg, err := NewMocked(o, out, nil, 10, time.Now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	defer agg.Shutdown()
+	ts := uint64(time.Now().Unix())
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: ts})
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.b", Value: 1, Timestamp: ts})
+	var snap *Aggregator
+	for i := 0; i < 100; i++ {
+		if snap = agg.Snapshot(); snap.RejectedSeries == 1 {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if snap.Series != 1 || snap.RejectedSeries != 1 {
+		t.Errorf("expected 1 series and 1 rejected point in the snapshot, got %d and %d", snap.Series, snap.RejectedSeries)
+	}
+}
+
+func TestOverflowPolicyInvalid(t *testing.T) {
+	cases := []struct {
+		maxSeries uint
+		policy    string
+	}{
+		{10, "foo"},
+		{0, OverflowAggregate},
+	}
+	for _, c := range cases {
+		o := testOptions("sum")
+		o.MaxSeries = c.maxSeries
+		o.OverflowPolicy = c.policy
+		if _, err := NewMocked(o, nil, nil, 10, time.Now, nil); err == nil {
+			t.Errorf("expected an error for overflow policy %q with max series %d", c.policy, c.maxSeries)
+		}
+	}
+}
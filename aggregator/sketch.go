@@ -0,0 +1,174 @@
+package aggregator
+
+import (
+	"math"
+	"sort"
+)
+
+// sketchMaxBins bounds the number of bins of each side of a Sketch, and hence its memory.
+// with an accuracy of 1%, 2048 bins cover values over more than 17 orders of magnitude,
+// beyond that the lowest bins get collapsed, which only affects the accuracy of the lowest percentiles.
+const sketchMaxBins = 2048
+
+// Sketch aggregates to percentiles, like Percentiles, but from a quantile sketch (DDSketch) rather than from all the values:
+// values are counted in bins of exponentially increasing width, so that the percentiles are within the relative
+// accuracy of the true values, and memory is bounded by the number of bins rather than by the number of values.
+// See https://arxiv.org/abs/1908.10693
+type Sketch struct {
+	percents map[string]float64
+	gamma    float64
+	logGamma float64
+	count    uint64
+	zero     uint64         // count of zeros, and of values too small to be binned
+	pos      map[int]uint64 // bins of the positive values
+	neg      map[int]uint64 // bins of the absolute negative values
+	min      float64
+	max      float64
+}
+
+// sketchMinValue is the smallest absolute value binned, smaller ones count as zero
+const sketchMinValue = 1e-9
+
+// NewSketchConstructor returns a constructor of sketches with the given relative accuracy, e.g. 0.01 for 1%,
+// aggregating to the given percentiles
+func NewSketchConstructor(percents map[string]float64, accuracy float64) func(val float64, ts uint32) Processor {
+	gamma := (1 + accuracy) / (1 - accuracy)
+	logGamma := math.Log(gamma)
+	return func(val float64, ts uint32) Processor {
+		s := &Sketch{
+			percents: percents,
+			gamma:    gamma,
+			logGamma: logGamma,
+			pos:      make(map[int]uint64),
+			neg:      make(map[int]uint64),
+			min:      math.Inf(1),
+			max:      math.Inf(-1),
+		}
+		s.Add(val, ts)
+		return s
+	}
+}
+
+func (s *Sketch) index(val float64) int {
+	return int(math.Ceil(math.Log(val) / s.logGamma))
+}
+
+// value returns the value representing the bin with the given index, within the relative accuracy of all its values
+func (s *Sketch) value(index int) float64 {
+	return 2 * math.Pow(s.gamma, float64(index)) / (s.gamma + 1)
+}
+
+func (s *Sketch) Add(val float64, ts uint32) {
+	if math.IsNaN(val) {
+		return
+	}
+	s.count++
+	if val < s.min {
+		s.min = val
+	}
+	if val > s.max {
+		s.max = val
+	}
+	switch {
+	case val > sketchMinValue:
+		s.pos[s.index(val)]++
+		collapse(s.pos)
+	case val < -sketchMinValue:
+		s.neg[s.index(-val)]++
+		collapse(s.neg)
+	default:
+		s.zero++
+	}
+}
+
+// collapse merges the lowest bins into the next one, as long as there are too many bins
+func collapse(bins map[int]uint64) {
+	for len(bins) > sketchMaxBins {
+		lowest, next := math.MaxInt64, math.MaxInt64
+		for index := range bins {
+			if index < lowest {
+				lowest, next = index, lowest
+			} else if index < next {
+				next = index
+			}
+		}
+		bins[next] += bins[lowest]
+		delete(bins, lowest)
+	}
+}
+
+func (s *Sketch) Flush() ([]processorResult, bool) {
+	if s.count == 0 {
+		return nil, false
+	}
+	type bin struct {
+		val   float64
+		count uint64
+	}
+	// all the bins, by increasing value
+	bins := make([]bin, 0, len(s.neg)+len(s.pos)+1)
+	for index, count := range s.neg {
+		bins = append(bins, bin{-s.value(index), count})
+	}
+	if s.zero > 0 {
+		bins = append(bins, bin{0, s.zero})
+	}
+	for index, count := range s.pos {
+		bins = append(bins, bin{s.value(index), count})
+	}
+	sort.Slice(bins, func(i, j int) bool { return bins[i].val < bins[j].val })
+
+	results := make([]processorResult, 0, len(s.percents))
+	for fcnName, percent := range s.percents {
+		rank := uint64(percent / 100 * float64(s.count-1))
+		var cumulative uint64
+		val := s.max
+		for _, b := range bins {
+			cumulative += b.count
+			if cumulative > rank {
+				val = b.val
+				break
+			}
+		}
+		// the true percentile is within the observed values
+		val = math.Max(s.min, math.Min(s.max, val))
+		results = append(results, processorResult{fcnName, val})
+	}
+	return results, true
+}
+
+// MemoryBytes estimates the memory used by the sketch
+func (s *Sketch) MemoryBytes() int {
+	// a map entry takes about its key and value, plus a byte of overhead, times the inverse load factor
+	return 96 + (len(s.pos)+len(s.neg))*17*2
+}
+
+type sketchState struct {
+	Count uint64
+	Zero  uint64
+	Pos   map[int]uint64
+	Neg   map[int]uint64
+	Min   float64
+	Max   float64
+}
+
+// the percents and accuracy are set by the constructor, only the counts are part of the state
+func (s *Sketch) GobEncode() ([]byte, error) {
+	return gobEncode(sketchState{s.count, s.zero, s.pos, s.neg, s.min, s.max})
+}
+
+func (s *Sketch) GobDecode(data []byte) error {
+	var st sketchState
+	if err := gobDecode(data, &st); err != nil {
+		return err
+	}
+	s.count, s.zero, s.min, s.max = st.Count, st.Zero, st.Min, st.Max
+	s.pos, s.neg = st.Pos, st.Neg
+	if s.pos == nil {
+		s.pos = make(map[int]uint64)
+	}
+	if s.neg == nil {
+		s.neg = make(map[int]uint64)
+	}
+	return nil
+}
@@ -0,0 +1,96 @@
+package aggregator
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestSketchAccuracy(t *testing.T) {
+	const accuracy = 0.01
+	percents := map[string]float64{"p1": 1, "p25": 25, "p50": 50, "p90": 90, "p99": 99, "p99_9": 99.9}
+	gens := map[string]func() float64{
+		"uniform":     func() float64 { return rand.Float64() * 1000 },
+		"exponential": func() float64 { return rand.ExpFloat64() * 50 },
+		"lognormal":   func() float64 { return math.Exp(rand.NormFloat64() * 3) },
+		"signed":      func() float64 { return rand.NormFloat64() * 100 },
+	}
+	for name, gen := range gens {
+		values := make([]float64, 100000)
+		for i := range values {
+			values[i] = gen()
+		}
+		proc := NewSketchConstructor(percents, accuracy)(values[0], 0)
+		for _, v := range values[1:] {
+			proc.Add(v, 0)
+		}
+		results, ok := proc.Flush()
+		if !ok || len(results) != len(percents) {
+			t.Fatalf("%s: expected %d results, got %v (%t)", name, len(percents), results, ok)
+		}
+		sort.Float64s(values)
+		for _, r := range results {
+			exp := values[int(percents[r.fcnName]/100*float64(len(values)-1))]
+			if math.Abs(r.val-exp) > accuracy*math.Abs(exp)+sketchMinValue {
+				t.Errorf("%s: expected %s within %.0f%% of %f, got %f", name, r.fcnName, accuracy*100, exp, r.val)
+			}
+		}
+	}
+}
+
+func TestSketchBoundedMemory(t *testing.T) {
+	proc := NewSketchConstructor(map[string]float64{"p50": 50, "p99": 99}, 0.01)(1, 0).(*Sketch)
+	// values over 300 orders of magnitude, on both sides of zero
+	values := []float64{1}
+	for e := -150.0; e < 150; e += 0.001 {
+		values = append(values, math.Pow(10, e), -math.Pow(10, e))
+	}
+	for _, v := range values[1:] {
+		proc.Add(v, 0)
+	}
+	if len(proc.pos) > sketchMaxBins || len(proc.neg) > sketchMaxBins {
+		t.Errorf("expected at most %d bins per side, got %d positive and %d negative", sketchMaxBins, len(proc.pos), len(proc.neg))
+	}
+	if max := 96 + 2*sketchMaxBins*17*2; proc.MemoryBytes() > max {
+		t.Errorf("expected at most %d bytes, got %d", max, proc.MemoryBytes())
+	}
+	// the highest percentiles are not affected by collapsing the lowest bins
+	sort.Float64s(values)
+	exp := values[int(0.99*float64(len(values)-1))]
+	results, _ := proc.Flush()
+	for _, r := range results {
+		if r.fcnName == "p99" && math.Abs(r.val-exp) > 0.01*exp {
+			t.Errorf("expected p99 within 1%% of %g, got %g", exp, r.val)
+		}
+	}
+}
+
+func TestSketchFunctions(t *testing.T) {
+	constr, multi, err := GetProcessorsConstructor("sum,p50,p99.9", 10, 0.01)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !multi {
+		t.Errorf("expected several outputs")
+	}
+	proc := constr(1, 0)
+	for i := 2; i <= 1000; i++ {
+		proc.Add(float64(i), 0)
+	}
+	results, _ := proc.Flush()
+	exp := map[string]float64{"sum": 500500, "p50": 500, "p99_9": 999}
+	if len(results) != len(exp) {
+		t.Fatalf("expected %d results, got %v", len(exp), results)
+	}
+	for _, r := range results {
+		if math.Abs(r.val-exp[r.fcnName]) > 0.01*exp[r.fcnName] {
+			t.Errorf("expected %s to be %f, got %f", r.fcnName, exp[r.fcnName], r.val)
+		}
+	}
+	for _, acc := range []float64{-0.1, 1, 2} {
+		if _, _, err := GetProcessorsConstructor("p99", 10, acc); err == nil {
+			t.Errorf("expected an error for sketch accuracy %f", acc)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package aggregator
+
+import (
+	"time"
+)
+
+// clock returns the current time of the aggregator, in seconds, which tells which buckets are due:
+// the wall clock, or in watermark mode the highest timestamp seen, so that replayed data is aggregated like live data
+func (a *Aggregator) clock() uint {
+	if a.Watermark {
+		return a.maxTs
+	}
+	return uint(a.now().Unix())
+}
+
+// observe advances the watermark to ts, in watermark mode, and flushes the buckets which got due when it enters a new interval,
+// like the ticks of the wall clock do otherwise.
+// The watermark doesn't go beyond the wall clock, so that a point from the future doesn't make all the others late
+func (a *Aggregator) observe(ts uint) {
+	if !a.Watermark {
+		return
+	}
+	a.lastPoint = a.now()
+	if wall := uint(a.lastPoint.Unix()); ts > wall {
+		ts = wall
+	}
+	if ts <= a.maxTs {
+		return
+	}
+	prev := a.maxTs - a.maxTs%a.Interval
+	a.maxTs = ts
+	if start := ts - ts%a.Interval; start > prev && start > a.Wait {
+		a.Flush(start - a.Wait)
+		if a.flushed != nil {
+			a.pruneFlushed(a.maxTs)
+		}
+	}
+}
+
+// flushIdle flushes all the buckets in process, in watermark mode, when no point came for IdleTimeout seconds:
+// the watermark doesn't advance anymore, so the last buckets would never get due otherwise.
+// The watermark then advances to when the flushed buckets are due, so that later points for them are late rather than
+// emitted again in a new bucket. Like in observe, it doesn't go beyond the wall clock
+func (a *Aggregator) flushIdle(now time.Time) {
+	if a.IdleTimeout == 0 || len(a.aggregations) == 0 || now.Sub(a.lastPoint) < time.Duration(a.IdleTimeout)*time.Second {
+		return
+	}
+	due := a.maxTs
+	for k := range a.aggregations {
+		if ts := a.last(k) + a.Wait; ts > due {
+			due = ts
+		}
+	}
+	if wall := uint(now.Unix()); due > wall {
+		due = wall
+	}
+	a.maxTs = due
+	a.Flush(^uint(0))
+}
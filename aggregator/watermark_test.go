@@ -0,0 +1,138 @@
+package aggregator
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+func TestWatermark(t *testing.T) {
+	out := make(chan encoding.Datapoint, 100)
+	nowUnix := int64(100000)
+	now := func() time.Time { return time.Unix(nowUnix, 0) }
+	o := testOptions("sum")
+	o.Watermark = true
+	o.IdleTimeout = 60
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+
+	// replayed data, way older than the wall clock, is aggregated as it would have been live
+	for ts := uint64(1000); ts <= 1100; ts += 5 {
+		agg.add(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: ts})
+	}
+	if agg.clock() != 1100 {
+		t.Errorf("expected the clock to be the highest timestamp, got %d", agg.clock())
+	}
+	// the buckets before 1100-30 are due
+	if len(out) != 7 {
+		t.Errorf("expected 7 outputs, got %d", len(out))
+	}
+	for ts := uint64(1000); len(out) > 0; ts += 10 {
+		if dp := <-out; dp.Name != "agg.a" || dp.Value != 2 || dp.Timestamp != ts {
+			t.Errorf("expected agg.a at %d to be 2, got %s", ts, dp)
+		}
+	}
+
+	// late per the watermark
+	agg.add(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: 1010})
+	if _, ok := agg.aggregations[aggkey{"agg.a", "", 1010, 0}]; ok {
+		t.Errorf("expected a point older than the watermark minus wait to be late")
+	}
+
+	// points from the future don't advance the watermark beyond the wall clock
+	agg.add(encoding.Datapoint{Name: "raw.b", Value: 1, Timestamp: uint64(nowUnix) + 1000})
+	if agg.clock() != uint(nowUnix) {
+		t.Errorf("expected the clock to be capped to the wall clock %d, got %d", nowUnix, agg.clock())
+	}
+	for len(out) > 0 {
+		<-out
+	}
+
+	// once idle, everything is flushed
+	agg.flushIdle(now().Add(59 * time.Second))
+	if len(agg.aggregations) == 0 {
+		t.Errorf("expected no flush before the idle timeout")
+	}
+	agg.flushIdle(now().Add(60 * time.Second))
+	if len(agg.aggregations) != 0 || len(out) != 1 {
+		t.Errorf("expected all the aggregations to be flushed after the idle timeout, got %d left and %d outputs", len(agg.aggregations), len(out))
+	}
+}
+
+func TestWatermarkIdle(t *testing.T) {
+	out := make(chan encoding.Datapoint, 100)
+	now := func() time.Time { return time.Unix(100000, 0) }
+	o := testOptions("sum")
+	o.Watermark = true
+	o.IdleTimeout = 60
+	agg, err := NewMocked(o, out, nil, 10, now, nil)
+	if err != nil {
+		t.Fatalf("couldn't create aggregation: %q", err)
+	}
+	agg.Shutdown()
+
+	for ts := uint64(1000); ts <= 1020; ts += 5 {
+		agg.add(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: ts})
+	}
+	agg.flushIdle(now().Add(60 * time.Second))
+	if len(out) != 3 {
+		t.Errorf("expected 3 outputs after the idle timeout, got %d", len(out))
+	}
+	for len(out) > 0 {
+		<-out
+	}
+
+	// the flushed buckets don't take points anymore, so that they're not emitted twice
+	agg.add(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: 1015})
+	if len(agg.aggregations) != 0 {
+		t.Errorf("expected a point for a bucket flushed when idle to be late, got %d aggregations", len(agg.aggregations))
+	}
+	// newer points still are aggregated
+	agg.add(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: 1060})
+	if len(agg.aggregations) != 1 {
+		t.Errorf("expected a point newer than the flushed buckets to be aggregated, got %d aggregations", len(agg.aggregations))
+	}
+}
+
+func TestWatermarkCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-aggregator")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	now := func() time.Time { return time.Unix(100000, 0) }
+	newAgg := func() *Aggregator {
+		o := testOptions("sum")
+		o.StateDir = dir
+		o.Watermark = true
+		agg, err := NewMocked(o, nil, nil, 10, now, nil)
+		if err != nil {
+			t.Fatalf("couldn't create aggregation: %q", err)
+		}
+		return agg
+	}
+	agg := newAgg()
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: 1000})
+	agg.Shutdown()
+
+	agg = newAgg()
+	agg.Shutdown()
+	if agg.clock() != 1000 || len(agg.aggregations) != 1 {
+		t.Errorf("expected the watermark and the aggregation to be resumed, got %d and %d aggregations", agg.clock(), len(agg.aggregations))
+	}
+}
+
+func TestIdleTimeoutInvalid(t *testing.T) {
+	o := testOptions("sum")
+	o.IdleTimeout = 60
+	if _, err := NewMocked(o, nil, nil, 10, time.Now, nil); err == nil {
+		t.Errorf("expected an error for an idle timeout without watermark")
+	}
+}
@@ -7,17 +7,26 @@ import (
 
 type BadMetrics struct {
 	maxAge  time.Duration
-	seen    map[string]Record
+	seen    map[recordKey]Record
 	In      chan Record
 	getReq  chan time.Time
 	getResp chan []Record
 }
 
 type Record struct {
-	Metric   string // the key parsed, or "" if parse failure
-	LastMsg  string // metric line read
-	LastErr  string
-	LastSeen time.Time
+	Metric     string // the key parsed, or "" if parse failure
+	Input      string // name of the input the message was read from
+	RemoteAddr string // address of the client that sent the message, if known
+	LastMsg    string // metric line read
+	LastErr    string
+	LastSeen   time.Time
+}
+
+// records are kept per metric and client, so that parse failures of different clients show up separately
+type recordKey struct {
+	metric     string
+	input      string
+	remoteAddr string
 }
 
 // ByMetric implements sort.Interface for []Record based on
@@ -32,7 +41,7 @@ func (a ByMetric) Less(i, j int) bool { return a[i].Metric < a[j].Metric }
 func New(maxAge time.Duration) *BadMetrics {
 	b := &BadMetrics{
 		maxAge,
-		make(map[string]Record),
+		make(map[recordKey]Record),
 		// needs to big enough so we don't start blocking when cleans or Get()'s happen
 		// if this fills up, Add() starts blocking, which blocks the table.
 		make(chan Record, 100000),
@@ -50,9 +59,11 @@ func (b *BadMetrics) Get(expiry time.Duration) []Record {
 	return filtered
 }
 
-func (b *BadMetrics) Add(metric []byte, msg []byte, err error) {
+func (b *BadMetrics) Add(input, remoteAddr, metric string, msg []byte, err error) {
 	b.In <- Record{
-		string(metric),
+		metric,
+		input,
+		remoteAddr,
 		string(msg),
 		err.Error(),
 		time.Now(),
@@ -65,12 +76,12 @@ func (b *BadMetrics) manage() {
 	for {
 		select {
 		case in := <-b.In:
-			b.seen[in.Metric] = in
+			b.seen[recordKey{in.Metric, in.Input, in.RemoteAddr}] = in
 		case <-clean.C:
 			cutoff := time.Now().Add(-b.maxAge)
-			for metric, record := range b.seen {
+			for key, record := range b.seen {
 				if record.LastSeen.Before(cutoff) {
-					delete(b.seen, metric)
+					delete(b.seen, key)
 				}
 			}
 		case oldest := <-b.getReq:
@@ -0,0 +1,125 @@
+package cfg
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// aggregationRuleMethods maps the methods of carbon-aggregator's aggregation-rules.conf to our functions
+var aggregationRuleMethods = map[string]string{
+	"avg":   "avg",
+	"count": "count",
+	"max":   "max",
+	"min":   "min",
+	"sum":   "sum",
+	"p50":   "p50",
+	"p75":   "p75",
+	"p80":   "p80",
+	"p90":   "p90",
+	"p95":   "p95",
+	"p99":   "p99",
+	"p999":  "p99.9",
+}
+
+var (
+	// a <<field>> matches one or more nodes, a <field> a single one
+	multiNodeField  = regexp.MustCompile(`<<(\w+)>>`)
+	singleNodeField = regexp.MustCompile(`<(\w+)>`)
+	outputField     = regexp.MustCompile(`<<?(\w+)>>?`)
+)
+
+// ReadAggregationRules reads the rules of a carbon-aggregator aggregation-rules.conf file,
+// and returns the aggregation of each of them, see ParseAggregationRule
+func ReadAggregationRules(path string) ([]Aggregation, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var aggs []Aggregation
+	scanner := bufio.NewScanner(f)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		agg, err := ParseAggregationRule(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", path, lineNum, err)
+		}
+		aggs = append(aggs, agg)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return aggs, nil
+}
+
+// ParseAggregationRule parses a rule of carbon-aggregator, as `output_template (frequency) = method input_pattern`,
+// e.g. `<env>.applications.<app>.all.requests (60) = sum <env>.applications.<app>.*.requests`, into an aggregation:
+//   - the input pattern is compiled to the regex, where a <field> matches a single node, a <<field>> one or more nodes,
+//     and a * any single node (or the rest of the node when part of it), like carbon does.
+//   - the fields of the output template are replaced by what they matched.
+//   - the frequency is the interval. the wait is twice as much, so that points up to an interval late are aggregated.
+func ParseAggregationRule(line string) (Aggregation, error) {
+	var agg Aggregation
+	sides := strings.SplitN(line, "=", 2)
+	if len(sides) != 2 {
+		return agg, fmt.Errorf("invalid rule %q: expected `output_template (frequency) = method input_pattern`", line)
+	}
+	left, right := strings.Fields(sides[0]), strings.Fields(sides[1])
+	if len(left) != 2 || len(right) != 2 {
+		return agg, fmt.Errorf("invalid rule %q: expected `output_template (frequency) = method input_pattern`", line)
+	}
+	output, frequency, method, input := left[0], left[1], right[0], right[1]
+
+	interval, err := strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(frequency, "("), ")"))
+	if err != nil || interval <= 0 {
+		return agg, fmt.Errorf("invalid rule %q: frequency must be a number of seconds > 0, got %s", line, frequency)
+	}
+	fun, ok := aggregationRuleMethods[method]
+	if !ok {
+		return agg, fmt.Errorf("invalid rule %q: unsupported method '%s'", line, method)
+	}
+
+	fields := make(map[string]bool)
+	parts := strings.Split(input, ".")
+	for i, part := range parts {
+		switch {
+		case multiNodeField.MatchString(part):
+			parts[i] = multiNodeField.ReplaceAllString(part, "(?P<$1>.+?)")
+		case singleNodeField.MatchString(part):
+			parts[i] = singleNodeField.ReplaceAllString(part, "(?P<$1>[^.]+?)")
+		case part == "*":
+			parts[i] = "[^.]+"
+		default:
+			parts[i] = strings.Replace(part, "*", "[^.]*", -1)
+		}
+		for _, m := range outputField.FindAllStringSubmatch(part, -1) {
+			fields[m[1]] = true
+		}
+	}
+	regex := "^" + strings.Join(parts, `\.`) + "$"
+	if _, err := regexp.Compile(regex); err != nil {
+		return agg, fmt.Errorf("invalid rule %q: invalid input pattern: %s", line, err)
+	}
+
+	for _, m := range outputField.FindAllStringSubmatch(output, -1) {
+		if !fields[m[1]] {
+			return agg, fmt.Errorf("invalid rule %q: field '%s' of the output template is not in the input pattern", line, m[1])
+		}
+	}
+
+	agg.Function = Functions{fun}
+	agg.Regex = regex
+	agg.Format = outputField.ReplaceAllString(output, "$${$1}")
+	agg.Cache = true
+	agg.Interval = interval
+	agg.Wait = 2 * interval
+	return agg, nil
+}
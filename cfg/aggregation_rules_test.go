@@ -0,0 +1,65 @@
+package cfg
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAggregationRule(t *testing.T) {
+	cases := []struct {
+		rule   string
+		input  string
+		output string
+		fun    string
+		ivl    int
+	}{
+		{"<env>.applications.<app>.all.requests (60) = sum <env>.applications.<app>.*.requests", "prod.applications.shop.host1.requests", "prod.applications.shop.all.requests", "sum", 60},
+		{"<env>.hosts.<<host>>.cpu (300) = avg <env>.hosts.<<host>>.cpu.*", "prod.hosts.web.eu.1.cpu.user", "prod.hosts.web.eu.1.cpu", "avg", 300},
+		{"total.<app>.latency (10) = p999 app-<app>.server*.latency", "app-shop.server12.latency", "total.shop.latency", "p99.9", 10},
+		{"all.count (10) = count *.requests", "foo.requests", "all.count", "count", 10},
+	}
+	for _, c := range cases {
+		agg, err := ParseAggregationRule(c.rule)
+		if !assert.NoError(t, err, c.rule) {
+			continue
+		}
+		assert.Equal(t, Functions{c.fun}, agg.Function, c.rule)
+		assert.Equal(t, c.ivl, agg.Interval, c.rule)
+		assert.Equal(t, 2*c.ivl, agg.Wait, c.rule)
+		re := regexp.MustCompile(agg.Regex)
+		matches := re.FindStringSubmatchIndex(c.input)
+		if assert.NotNil(t, matches, "%s must match %s", agg.Regex, c.input) {
+			assert.Equal(t, c.output, string(re.ExpandString(nil, agg.Format, c.input, matches)), c.rule)
+		}
+	}
+
+	agg, _ := ParseAggregationRule("<app>.all (60) = sum <app>.*.requests")
+	assert.False(t, regexp.MustCompile(agg.Regex).MatchString("shop.host1.requests.rate"), "the input pattern must match the whole name")
+	assert.False(t, regexp.MustCompile(agg.Regex).MatchString("shop.eu.host1.requests"), "a field must match a single node")
+}
+
+func TestParseAggregationRuleInvalid(t *testing.T) {
+	for _, rule := range []string{
+		"foo (60) sum foo.*",
+		"foo = sum foo.*",
+		"foo (0) = sum foo.*",
+		"foo (a) = sum foo.*",
+		"foo (60) = stdev foo.*",
+		"<app>.all (60) = sum <host>.*",
+		"foo (60) = sum foo.(",
+	} {
+		_, err := ParseAggregationRule(rule)
+		assert.Error(t, err, rule)
+	}
+}
+
+func TestReadAggregationRules(t *testing.T) {
+	aggs, err := ReadAggregationRules("../examples/aggregation-rules.conf")
+	assert.NoError(t, err)
+	assert.Len(t, aggs, 3)
+
+	_, err = ReadAggregationRules("non-existent.conf")
+	assert.Error(t, err)
+}
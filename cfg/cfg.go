@@ -1,6 +1,8 @@
 package cfg
 
 import (
+	"fmt"
+	"strings"
 	"time"
 
 	"github.com/graphite-ng/carbon-relay-ng/input"
@@ -20,11 +22,14 @@ type Config struct {
 	Log_level           string
 	Bad_metrics_max_age string
 	Pid_file            string
+	Auto_save_file      string // where to save the routing table after changes made at runtime, see Table.AutoSave
 	BlackList           []string
 	Aggregation         []Aggregation
 	Route               []Route
 	Rewriter            []Rewriter
 
+	Aggregation_rules_file string // carbon-aggregator aggregation-rules.conf, whose rules are added to the aggregations
+
 	// Should we crash if no input can be initialized ?
 	NoInputError bool
 
@@ -46,16 +51,65 @@ func (d *Duration) UnmarshalText(text []byte) error {
 	return err
 }
 
+// Functions is a list of aggregation functions, set as a single function or as an array of functions
+type Functions []string
+
+func (f *Functions) UnmarshalTOML(data interface{}) error {
+	switch v := data.(type) {
+	case string:
+		*f = Functions{v}
+	case []interface{}:
+		funs := make(Functions, 0, len(v))
+		for _, fun := range v {
+			s, ok := fun.(string)
+			if !ok {
+				return fmt.Errorf("aggregation function must be a string, got %v", fun)
+			}
+			funs = append(funs, s)
+		}
+		*f = funs
+	default:
+		return fmt.Errorf("function must be a string or an array of strings, got %v", data)
+	}
+	return nil
+}
+
+// String returns the functions as the comma separated list expected by aggregator.New
+func (f Functions) String() string {
+	return strings.Join(f, ",")
+}
+
 type Aggregation struct {
-	Function string
+	Function Functions
 	Regex    string
 	Prefix   string
 	Substr   string
+	Tag      string
 	Format   string
 	Cache    bool
 	Interval int
 	Wait     int
 	DropRaw  bool
+	GroupBy  []string
+	OutTags  map[string]string
+
+	Resolutions []string // additional resolutions to aggregate to, as <interval>[/<step>][:<suffix>], see the aggregation docs
+
+	Storage_schemas      string // storage-schemas.conf of the rollup function
+	Storage_aggregations string // storage-aggregation.conf of the rollup function
+
+	Late_policy string // what to do with the points which come too late for their bucket: drop, forward, reemit or route
+	Late_route  string // key of the route to send the late points to, with the route late policy
+
+	Max_series      int    // maximum number of output series in process, 0 for no limit
+	Overflow_policy string // what to do with the points of new series beyond max_series: drop or aggregate into an overflow series
+
+	Watermark    bool // whether buckets get due per the highest timestamp seen rather than the wall clock, e.g. to aggregate replayed data
+	Idle_timeout int  // in watermark mode, seconds without points after which all the buckets are flushed, 0 to never flush them
+
+	Sketch_accuracy     float64 // relative accuracy of the percentiles, computed from a sketch if set, e.g. 0.01 for 1%
+	State_dir           string  // where to save the aggregations in process on shutdown, to resume them on start
+	Checkpoint_interval int     // seconds between saves of the aggregations in process, 0 to only save them on shutdown
 }
 
 type Route struct {
@@ -64,6 +118,7 @@ type Route struct {
 	Prefix       string   `toml:"prefix,omitempty"`
 	Substr       string   `toml:"substr,omitempty"`
 	Regex        string   `toml:"regex,omitempty"`
+	Tag          string   `toml:"tag,omitempty"`
 	Destinations []string `toml:"destinations,omitempty"`
 
 	// grafanaNet & kafkaMdm & Google PubSub
@@ -103,6 +158,13 @@ type Route struct {
 	CacheSize        int               `toml:"cache_size,omitempty"` // In bytes
 	// Note than the cache will be disabled if <= 0
 	// Then it will minimize at 512KB. To optimize the cache, you need to set it to at least (n * 1024) with n being the max len of your key size
+
+	// LoadBalance
+	Strategy string `toml:"strategy,omitempty"` // roundRobin (default) or leastQueued
+
+	// Failover
+	DownPeriod int `toml:"down_period,omitempty"` // seconds the active destination must be offline before failing over, default 10
+	UpPeriod   int `toml:"up_period,omitempty"`   // seconds a preferred destination must be back online before failing back, default 60
 }
 
 type KafkaRouteConfig struct {
@@ -3,6 +3,7 @@ package cfg
 import (
 	"errors"
 	"fmt"
+	"strings"
 	"time"
 
 	"github.com/Shopify/sarama"
@@ -15,8 +16,9 @@ import (
 )
 
 const (
-	ListenerConfigType = "listener"
-	KafkaConfigType    = "kafka"
+	ListenerConfigType        = "listener"
+	KafkaConfigType           = "kafka"
+	PromRemoteWriteConfigType = "prom_remote_write"
 )
 
 const (
@@ -38,6 +40,8 @@ var (
 	kafkaEmptyTopicError         = errors.New("topic can't be empty in kafka config")
 	kafkaEmptyBrokersError       = errors.New("brokers can't be empty in kafka config")
 	noInputError                 = errors.New("no inputs could be found")
+	promEmptyListenAddrError     = errors.New("listen_addr can't be empty in prom_remote_write config")
+	promInvalidPathError         = errors.New("path must start with '/' in prom_remote_write config")
 )
 
 type InputConfig interface {
@@ -60,6 +64,18 @@ type ListenerConfig struct {
 	ListenAddr      string        `mapstructure:"listen_addr,omitempty"`
 	ReadTimeout     time.Duration `mapstructure:"read_timeout,omitempty"`
 	instance        string
+	// serve TLS over tcp, without udp
+	TLS *ListenerTLSConfig `mapstructure:"tls,omitempty"`
+	// keep the provenance tags of the upstream relays, with the internal format
+	TrustTags bool `mapstructure:"trust_tags,omitempty"`
+}
+
+// ListenerTLSConfig makes a listener serve TLS over tcp, see input.NewTLSConfig
+type ListenerTLSConfig struct {
+	CertFile          string `mapstructure:"cert_file,omitempty"`
+	KeyFile           string `mapstructure:"key_file,omitempty"`
+	ClientCAFile      string `mapstructure:"client_ca_file,omitempty"`
+	RequireClientCert bool   `mapstructure:"require_client_cert,omitempty"`
 }
 
 func (c *ListenerConfig) Build() (input.Input, error) {
@@ -67,10 +83,49 @@ func (c *ListenerConfig) Build() (input.Input, error) {
 	if err != nil {
 		return nil, fmt.Errorf(handlerErrorFmt, fmt.Sprintf("listener[%s] config: %s", c.ListenAddr, err))
 	}
-	l := input.NewListener(c.ListenAddr, c.ReadTimeout, c.Workers, c.Workers, h, c.instance)
+	if c.TrustTags && h.Kind() != encoding.InternalFormat {
+		return nil, fmt.Errorf("listener[%s] config: trust_tags needs the internal format", c.ListenAddr)
+	}
+	if c.TLS == nil {
+		return input.NewListener(c.ListenAddr, c.ReadTimeout, c.Workers, c.Workers, h, c.instance, nil, c.TrustTags), nil
+	}
+	tlsConfig, err := input.NewTLSConfig(c.TLS.CertFile, c.TLS.KeyFile, c.TLS.ClientCAFile, c.TLS.RequireClientCert)
+	if err != nil {
+		return nil, fmt.Errorf("listener[%s] tls config: %s", c.ListenAddr, err)
+	}
+	// no udp workers: udp can't be authenticated
+	l := input.NewListener(c.ListenAddr, c.ReadTimeout, c.Workers, 0, h, c.instance, tlsConfig, c.TrustTags)
 	return l, nil
 }
 
+type PromRemoteWriteConfig struct {
+	ListenAddr     string        `mapstructure:"listen_addr,omitempty"`
+	Path           string        `mapstructure:"path,omitempty"`
+	PathTemplate   string        `mapstructure:"path_template,omitempty"`
+	MaxRequestSize int           `mapstructure:"max_request_size,omitempty"`
+	ReadTimeout    time.Duration `mapstructure:"read_timeout,omitempty"`
+	instance       string
+}
+
+// Handler always is the prometheus WriteRequest decoder, there is no `format` to choose
+func (c *PromRemoteWriteConfig) Handler() (encoding.FormatAdapter, error) {
+	return encoding.NewPromWriteRequest(c.PathTemplate)
+}
+
+func (c *PromRemoteWriteConfig) Build() (input.Input, error) {
+	if c.ListenAddr == "" {
+		return nil, promEmptyListenAddrError
+	}
+	if !strings.HasPrefix(c.Path, "/") {
+		return nil, promInvalidPathError
+	}
+	h, err := encoding.NewPromWriteRequest(c.PathTemplate)
+	if err != nil {
+		return nil, fmt.Errorf(handlerErrorFmt, fmt.Sprintf("prom_remote_write[%s] config: %s", c.ListenAddr, err))
+	}
+	return input.NewPromRemoteWrite(c.ListenAddr, c.Path, c.ReadTimeout, c.MaxRequestSize, h, c.instance), nil
+}
+
 // for more informations about the fields, go look at https://github.com/segmentio/kafka-go/blob/master/reader.go#L291
 type KafkaConfig struct {
 	baseInputConfig     `mapstructure:",squash"`
@@ -195,13 +250,20 @@ func (c *Config) ProcessInputConfig() error {
 			n = &KafkaConfig{}
 		case ListenerConfigType:
 			n = &ListenerConfig{Workers: 1, ReadTimeout: 2 * time.Minute, instance: c.Instance}
+		case PromRemoteWriteConfigType:
+			n = &PromRemoteWriteConfig{Path: "/write", ReadTimeout: 2 * time.Minute, instance: c.Instance}
 		case "":
 			return fmt.Errorf("input type can't be \"\"")
 		default:
 			return fmt.Errorf("unknown input type: \"%s\"", configMap["type"])
 		}
-		// To avoid being catched by the strict decoding
-		delete(configMap, "type")
+		// To avoid being catched by the strict decoding. on a copy, as the raw inputs are exported as they are, see table.Export
+		settings := make(map[string]interface{}, len(configMap))
+		for k, v := range configMap {
+			if k != "type" {
+				settings[k] = v
+			}
+		}
 
 		d, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
 			ErrorUnused:      true,
@@ -212,7 +274,7 @@ func (c *Config) ProcessInputConfig() error {
 		if err != nil {
 			return fmt.Errorf(decoderErrorFmt, err)
 		}
-		err = d.Decode(configMap)
+		err = d.Decode(settings)
 		if err != nil {
 			return fmt.Errorf(decodingErrorFmt, t, err)
 		}
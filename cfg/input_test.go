@@ -1,5 +1,13 @@
 package cfg
 
+import (
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+
+	"github.com/stretchr/testify/assert"
+)
+
 // const ValidKafka = `
 // [[inputs]]
 // type = "kafka"
@@ -17,3 +25,32 @@ package cfg
 // 	assert.NoError(t, c.ProcessInputConfig())
 // 	assert.NotEmpty(t, c.Inputs)
 // }
+
+func TestPromRemoteWritePath(t *testing.T) {
+	for _, path := range []string{"", "write"} {
+		c := PromRemoteWriteConfig{ListenAddr: "127.0.0.1:0", Path: path}
+		_, err := c.Build()
+		assert.Error(t, err, "path %q", path)
+	}
+	c := PromRemoteWriteConfig{ListenAddr: "127.0.0.1:0", Path: "/write"}
+	_, err := c.Build()
+	assert.NoError(t, err)
+}
+
+func TestProcessInputConfigKeepsRaw(t *testing.T) {
+	c := NewConfig()
+	c.InputsRaw = []map[string]interface{}{{"type": "listener", "listen_addr": "127.0.0.1:0", "format": "plain"}}
+	assert.NoError(t, c.ProcessInputConfig())
+	assert.Len(t, c.Inputs, 1)
+	assert.Equal(t, "listener", c.InputsRaw[0]["type"], "the raw inputs are exported, they must be left as they are")
+}
+
+func TestListenerTrustTags(t *testing.T) {
+	c := ListenerConfig{ListenAddr: "127.0.0.1:0", TrustTags: true}
+	c.Format = encoding.PlainFormat
+	_, err := c.Build()
+	assert.Error(t, err, "trust_tags needs the internal format")
+	c.Format = encoding.InternalFormat
+	_, err = c.Build()
+	assert.NoError(t, err)
+}
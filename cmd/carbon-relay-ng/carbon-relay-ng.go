@@ -83,6 +83,8 @@ func main() {
 
 	log := logger.Sugar()
 
+	// the table keeps the unexpanded instance, so that the config it exports works on any host
+	fileConfig := config
 	config.Instance = os.Expand(config.Instance, expandVars)
 	if len(config.Instance) == 0 {
 		log.Error("instance identifier cannot be empty")
@@ -119,7 +121,7 @@ func main() {
 
 	log.Info("initializing routing table...")
 
-	table, err := tbl.InitFromConfig(config, meta)
+	table, err := tbl.InitFromConfig(fileConfig, meta)
 	if err != nil {
 		log.Error(err.Error())
 		os.Exit(1)
@@ -145,18 +147,27 @@ func main() {
 	}
 
 	sigChan := make(chan os.Signal, 1)
-	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
 
-	sig, ok := <-sigChan
-	if ok {
+	for sig := range sigChan {
+		if sig == syscall.SIGHUP {
+			log.Infof("Received signal %q. Reloading %s", sig, config_file)
+			err := reload(table)
+			if err != nil {
+				log.Errorf("failed to reload config, keeping the current one: %s", err)
+			}
+			continue
+		}
 		log.Infof("Received signal %q. Shutting down", sig)
+		break
 	}
 	wg := sync.WaitGroup{}
 	wg.Add(len(config.Inputs))
 	for _, i := range config.Inputs {
+		i := i
 		go func() {
 			defer wg.Done()
-			err = i.Stop()
+			err := i.Stop()
 			if err != nil {
 				log.Warnf("failed to stop input %s: %s", i.Name(), err)
 			}
@@ -169,6 +180,28 @@ func main() {
 	wg.Wait()
 }
 
+// reload re-reads the config file and applies the routing table part of it (blacklist, rewriters,
+// aggregators and routes). the other settings, such as the inputs, need a restart
+func reload(table *tbl.Table) error {
+	newConfig := cfg.NewConfig()
+	meta, err := toml.DecodeFile(config_file, &newConfig)
+	if err != nil {
+		return fmt.Errorf("invalid config file %q: %s", config_file, err)
+	}
+	if len(meta.Undecoded()) > 0 {
+		return fmt.Errorf("unknown configuration keys in %s: %q", config_file, meta.Undecoded())
+	}
+	err = table.Reload(newConfig)
+	if err != nil {
+		return err
+	}
+	table.AutoSave()
+	for _, line := range strings.Split(table.Print(), "\n") {
+		zap.S().Info(line)
+	}
+	return nil
+}
+
 func expandVars(in string) (out string) {
 	switch in {
 	case "HOST":
@@ -2,10 +2,10 @@ package destination
 
 import (
 	"bufio"
+	"crypto/tls"
 	"fmt"
 	"io"
 	"net"
-	"os"
 	"strconv"
 	"sync"
 	"time"
@@ -26,6 +26,9 @@ var keepsafe_keep_duration = time.Duration(10 * time.Second)
 
 var newLine = []byte{'\n'}
 
+// how long to wait for the TLS handshake, like net/http does
+var tlsHandshakeTimeout = 10 * time.Second
+
 // Conn represents a connection to a tcp endpoint.
 // As long as conn.isAlive(), caller may write data to conn.In
 // when no longer alive, caller must call either getRedo or clearRedo:
@@ -39,12 +42,14 @@ var newLine = []byte{'\n'}
 // can be the same buffer. but this requires significant refactoring.
 
 type Conn struct {
-	conn        *net.TCPConn
+	conn        net.Conn // *net.TCPConn, or *tls.Conn over it
 	buffered    *bufio.Writer
 	shutdown    chan bool
 	In          chan encoding.Datapoint
 	key         string
 	pickle      bool
+	internal    bool
+	tagFilter   *TagFilter // nil unless tagged names are sent
 	flush       chan bool
 	flushErr    chan error
 	periodFlush time.Duration
@@ -54,21 +59,35 @@ type Conn struct {
 
 	wg sync.WaitGroup
 
+	// the pickle frame in progress, see writePickle
+	pickleBatch     []encoding.Datapoint
+	pickleBatchSize int
+	pickleFrame     []byte
+
 	droppedMetricsCounter *prometheus.CounterVec
 	bm                    *metrics.BufferMetrics
 	logger                *zap.Logger
 }
 
-func NewConn(key, addr string, periodFlush time.Duration, pickle bool, connBufSize, ioBufSize int) (*Conn, error) {
+// NewConn connects to addr, over TLS if tlsConfig is set
+func NewConn(key, addr string, periodFlush time.Duration, pickle, internal bool, tagFilter *TagFilter, tlsConfig *tls.Config, connBufSize, ioBufSize int) (*Conn, error) {
 	raddr, err := net.ResolveTCPAddr("tcp", addr)
 	if err != nil {
 		return nil, err
 	}
 	laddr, _ := net.ResolveTCPAddr("tcp", "0.0.0.0")
-	conn, err := net.DialTCP("tcp", laddr, raddr)
+	tcpConn, err := net.DialTCP("tcp", laddr, raddr)
 	if err != nil {
 		return nil, err
 	}
+	var conn net.Conn = tcpConn
+	if tlsConfig != nil {
+		conn, err = tlsHandshake(tcpConn, addr, tlsConfig)
+		if err != nil {
+			tcpConn.Close()
+			return nil, err
+		}
+	}
 	connObj := &Conn{
 		conn:     conn,
 		buffered: bufio.NewWriterSize(conn, ioBufSize),
@@ -80,6 +99,8 @@ func NewConn(key, addr string, periodFlush time.Duration, pickle bool, connBufSi
 		key:         key,
 		up:          true,
 		pickle:      pickle,
+		internal:    internal,
+		tagFilter:   tagFilter,
 		flush:       make(chan bool),
 		flushErr:    make(chan error),
 		periodFlush: periodFlush,
@@ -98,6 +119,26 @@ func NewConn(key, addr string, periodFlush time.Duration, pickle bool, connBufSi
 	return connObj, nil
 }
 
+// tlsHandshake does the TLS handshake over conn, verifying the server certificate with the host of addr unless the config has a server name.
+// it's done here rather than on the first read or write, so that a failed handshake fails the connect
+func tlsHandshake(conn *net.TCPConn, addr string, config *tls.Config) (*tls.Conn, error) {
+	if config.ServerName == "" {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		config = config.Clone()
+		config.ServerName = host
+	}
+	tlsConn := tls.Client(conn, config)
+	tlsConn.SetDeadline(time.Now().Add(tlsHandshakeTimeout))
+	if err := tlsConn.Handshake(); err != nil {
+		return nil, err
+	}
+	tlsConn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
 func (c *Conn) isAlive() bool {
 	c.upMutex.RLock()
 	up := c.up
@@ -110,6 +151,8 @@ func (c *Conn) isAlive() bool {
 // but we know when we get EOF that the other end closed the conn
 // if not for this, we can happily write and flush without getting errors (in Go) but getting RST tcp packets back (!)
 // props to Tv` for this trick.
+// over TLS, Read also handles the messages of the server after the handshake, like session tickets,
+// and returns io.EOF as well when the server closes the conn, with or without a close_notify alert.
 func (c *Conn) checkEOF() {
 	defer c.wg.Done()
 	b := make([]byte, 1024)
@@ -185,13 +228,14 @@ func (c *Conn) HandleData() {
 			c.logger.Debug("conn HandleData: writing datapoint", zap.Stringer("datapoint", dp))
 			c.keepSafe.Add(dp)
 
-			writeBuf = append(writeBuf[:0], dp.Name...)
-			writeBuf = append(writeBuf, ' ')
-			writeBuf = strconv.AppendFloat(writeBuf, dp.Value, 'f', -1, 64)
-			writeBuf = append(writeBuf, ' ')
-			writeBuf = strconv.AppendUint(writeBuf, dp.Timestamp, 10)
-
-			n, err := c.Write(writeBuf)
+			var n int
+			var err error
+			if c.pickle {
+				n, err = c.writePickle(dp)
+			} else {
+				writeBuf = c.appendDatapoint(writeBuf[:0], dp)
+				n, err = c.Write(writeBuf)
+			}
 			if err != nil {
 				c.logger.Warn("conn write error. closing", zap.Error(err))
 				c.close() // this can take a while but that's ok. this conn won't be used anymore
@@ -203,7 +247,11 @@ func (c *Conn) HandleData() {
 			active = time.Now()
 			action = "auto-flush"
 			c.logger.Debug("conn HandleData: c.buffered auto-flushing...")
-			err := c.buffered.Flush()
+			n, err := c.flushPickle()
+			flushSize += int64(n)
+			if err == nil {
+				err = c.buffered.Flush()
+			}
 			if err != nil {
 				c.logger.Warn("conn HandleData c.buffered auto-flush done but with error. closing", zap.Error(err))
 				errCounter.WithLabelValues(c.key, "flush").Inc()
@@ -217,7 +265,11 @@ func (c *Conn) HandleData() {
 			active = time.Now()
 			action = "manual-flush"
 			c.logger.Debug("conn HandleData: c.buffered manual flushing...")
-			err := c.buffered.Flush()
+			n, err := c.flushPickle()
+			flushSize += int64(n)
+			if err == nil {
+				err = c.buffered.Flush()
+			}
 			c.flushErr <- err
 			if err != nil {
 				c.logger.Warn("conn HandleData c.buffered manual flush done but witth error. closing", zap.Error(err))
@@ -239,23 +291,30 @@ func (c *Conn) HandleData() {
 	}
 }
 
+// appendDatapoint appends dp to buf, as an internal frame or a line of the plain text protocol without the newline.
+// pickle is written in frames of many datapoints instead, see writePickle
+func (c *Conn) appendDatapoint(buf []byte, dp encoding.Datapoint) []byte {
+	if c.internal {
+		return encoding.AppendInternalFrame(buf, []encoding.Datapoint{dp})
+	}
+	if c.tagFilter != nil {
+		buf = append(buf, c.tagFilter.Name(dp)...)
+	} else {
+		buf = append(buf, dp.Name...)
+	}
+	buf = append(buf, ' ')
+	buf = strconv.AppendFloat(buf, dp.Value, 'f', -1, 64)
+	buf = append(buf, ' ')
+	return strconv.AppendUint(buf, dp.Timestamp, 10)
+}
+
 // returns a network/write error, so that it can be retried later
-// deals with pickle errors internally because retrying wouldn't help anyway
 func (c *Conn) Write(buf []byte) (int, error) {
-	if c.pickle {
-		dp, err := ParseDataPoint(buf)
-		if err != nil {
-			fmt.Fprintln(os.Stderr, err)
-			droppedMetricsCounter.WithLabelValues(c.key, "bad_pickle").Inc()
-			return 0, nil
-		}
-		buf = Pickle(dp)
-	}
 	written := 0
 	size := len(buf)
 	n, err := c.buffered.Write(buf)
 	written += n
-	if err == nil && size == n && !c.pickle {
+	if err == nil && size == n && !c.internal {
 		size = 1
 		n, err = c.buffered.Write(newLine)
 		written += n
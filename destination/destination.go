@@ -1,7 +1,10 @@
 package destination
 
 import (
+	"crypto/tls"
 	"errors"
+	"fmt"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -12,6 +15,8 @@ import (
 	"go.uber.org/zap"
 )
 
+var errPickleAndInternal = errors.New("pickle and internal formats are mutually exclusive")
+
 func addrInstanceSplit(addr string) (string, string) {
 	var instance string
 	// The address may be specified as server, server:port or server:port:instance.
@@ -28,15 +33,19 @@ type Destination struct {
 	lockMatcher sync.Mutex
 	Matcher     matcher.Matcher `json:"matcher"`
 
-	Addr         string `json:"address"`  // tcp dest
-	Instance     string `json:"instance"` // Optional carbon instance name, useful only with consistent hashing
-	SpoolDir     string // where to store spool files (if enabled)
-	Key          string // unique key per destination, based on routeName and destination addr/port combination
-	Spool        bool   `json:"spool"`        // spool metrics to disk while dest down?
-	Pickle       bool   `json:"pickle"`       // send in pickle format?
-	Online       bool   `json:"online"`       // state of connection online/offline.
-	SlowNow      bool   `json:"slowNow"`      // did we have to drop packets in current loop
-	SlowLastLoop bool   `json:"slowLastLoop"` // "" last loop
+	Addr         string   `json:"address"`  // tcp dest
+	Instance     string   `json:"instance"` // Optional carbon instance name, useful only with consistent hashing
+	SpoolDir     string   // where to store spool files (if enabled)
+	Key          string   // unique key per destination, based on routeName and destination addr/port combination
+	Spool        bool     `json:"spool"`        // spool metrics to disk while dest down?
+	Pickle       bool     `json:"pickle"`       // send in pickle format?
+	Internal     bool     `json:"internal"`     // send in the internal relay-to-relay format?
+	Tags         bool     `json:"tags"`         // send graphite 1.1 tagged names (name;k=v;...)?
+	TagsAllow    []string `json:"tagsAllow"`    // if set, only send these tag keys
+	TagsDeny     []string `json:"tagsDeny"`     // never send these tag keys, on top of DefaultTagsDeny
+	Online       bool     `json:"online"`       // state of connection online/offline.
+	SlowNow      bool     `json:"slowNow"`      // did we have to drop packets in current loop
+	SlowLastLoop bool     `json:"slowLastLoop"` // "" last loop
 	periodFlush  time.Duration
 	periodReConn time.Duration
 	connBufSize  int // in metrics. (each metric line is typically about 70 bytes). default 30k. to make sure writes to In are fast until conn flushing can't keep up
@@ -50,6 +59,9 @@ type Destination struct {
 	UnspoolSleep         time.Duration // how long to wait between loads from spool
 	RouteName            string
 
+	TLS       TLSConfig  `json:"tls"`
+	tlsLoader *tlsLoader // nil unless TLS is enabled
+
 	// set in/via Run()
 	In                  chan encoding.Datapoint `json:"-"` // incoming metrics
 	shutdown            chan bool               // signals shutdown internally
@@ -62,11 +74,22 @@ type Destination struct {
 	tasks               sync.WaitGroup
 	logger              *zap.Logger
 	closer              sync.Once
+
+	// the conn of relay, and Online, for the routes to read. see setConn
+	lockConn sync.Mutex
+	conn     *Conn
 }
 
 // New creates a destination object. Note that it still needs to be told to run via Run().
-func New(routeName, prefix, sub, regex, addr, spoolDir string, spool, pickle bool, periodFlush, periodReConn time.Duration, connBufSize, ioBufSize, spoolBufSize int, spoolMaxBytesPerFile, spoolSyncEvery int64, spoolSyncPeriod, spoolSleep, unspoolSleep time.Duration) (*Destination, error) {
-	m, err := matcher.New(prefix, sub, regex)
+func New(routeName, prefix, sub, regex, tag, addr, spoolDir string, spool, pickle, internal, tags bool, tagsAllow, tagsDeny []string, tlsConfig TLSConfig, periodFlush, periodReConn time.Duration, connBufSize, ioBufSize, spoolBufSize int, spoolMaxBytesPerFile, spoolSyncEvery int64, spoolSyncPeriod, spoolSleep, unspoolSleep time.Duration) (*Destination, error) {
+	if pickle && internal {
+		return nil, errPickleAndInternal
+	}
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
+	if err != nil {
+		return nil, err
+	}
+	tlsLoader, err := newTLSLoader(tlsConfig)
 	if err != nil {
 		return nil, err
 	}
@@ -80,10 +103,16 @@ func New(routeName, prefix, sub, regex, addr, spoolDir string, spool, pickle boo
 		Key:                  key,
 		Spool:                spool,
 		Pickle:               pickle,
+		Internal:             internal,
+		Tags:                 tags,
+		TagsAllow:            tagsAllow,
+		TagsDeny:             tagsDeny,
+		TLS:                  tlsConfig,
 		periodFlush:          periodFlush,
 		periodReConn:         periodReConn,
 		connBufSize:          connBufSize,
 		ioBufSize:            ioBufSize,
+		tlsLoader:            tlsLoader,
 		SpoolBufSize:         spoolBufSize,
 		SpoolMaxBytesPerFile: spoolMaxBytesPerFile,
 		SpoolSyncEvery:       spoolSyncEvery,
@@ -103,18 +132,25 @@ func (dest *Destination) MatchString(s string) bool {
 	return dest.Matcher.MatchString(s)
 }
 
+func (dest *Destination) MatchDatapoint(dp encoding.Datapoint) bool {
+	dest.lockMatcher.Lock()
+	defer dest.lockMatcher.Unlock()
+	return dest.Matcher.MatchDatapoint(dp)
+}
+
 func (dest *Destination) Match(s []byte) bool {
 	dest.lockMatcher.Lock()
 	defer dest.lockMatcher.Unlock()
 	return dest.Matcher.Match(s)
 }
 
-// can't be changed yet: pickle, spool, flush, reconn
+// can't be changed yet: pickle, internal, tags, spool, flush, reconn. see Copy for those
 func (dest *Destination) Update(opts map[string]string) error {
 	match := dest.GetMatcher()
 	prefix := match.Prefix
 	sub := match.Sub
 	regex := match.Regex
+	tag := match.Tag
 	updateMatcher := false
 	addr := ""
 
@@ -122,6 +158,9 @@ func (dest *Destination) Update(opts map[string]string) error {
 		switch name {
 		case "addr":
 			addr = val
+		case "tag":
+			tag = val
+			updateMatcher = true
 		case "prefix":
 			prefix = val
 			updateMatcher = true
@@ -139,7 +178,7 @@ func (dest *Destination) Update(opts map[string]string) error {
 		dest.updateConn(addr)
 	}
 	if updateMatcher {
-		match, err := matcher.New(prefix, sub, regex)
+		match, err := matcher.NewWithTag(prefix, sub, regex, tag)
 		if err != nil {
 			return err
 		}
@@ -148,6 +187,78 @@ func (dest *Destination) Update(opts map[string]string) error {
 	return nil
 }
 
+// NeedsCopy returns whether opts contain options which Update can't apply to a running destination,
+// in which case the destination must be replaced by a Copy
+func NeedsCopy(opts map[string]string) bool {
+	for name := range opts {
+		switch name {
+		case "spool", "pickle", "flush", "reconn":
+			return true
+		}
+	}
+	return false
+}
+
+// Copy returns a destination which is not running yet, with the settings of dest overridden by opts.
+// on top of the options of Update, it supports spool and pickle (true/false), flush and reconn (in ms)
+func (dest *Destination) Copy(opts map[string]string) (*Destination, error) {
+	match := dest.GetMatcher()
+	prefix := match.Prefix
+	sub := match.Sub
+	regex := match.Regex
+	tag := match.Tag
+	addr := dest.Addr
+	if dest.Instance != "" {
+		addr += ":" + dest.Instance
+	}
+	spool := dest.Spool
+	pickle := dest.Pickle
+	periodFlush := dest.periodFlush
+	periodReConn := dest.periodReConn
+
+	for name, val := range opts {
+		var err error
+		switch name {
+		case "addr":
+			addr = val
+		case "tag":
+			tag = val
+		case "prefix":
+			prefix = val
+		case "sub":
+			sub = val
+		case "regex":
+			regex = val
+		case "spool":
+			spool, err = strconv.ParseBool(val)
+		case "pickle":
+			pickle, err = strconv.ParseBool(val)
+		case "flush":
+			periodFlush, err = parsePeriod(val)
+		case "reconn":
+			periodReConn, err = parsePeriod(val)
+		default:
+			return nil, errors.New("no such option: " + name)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid value %q for option %s: %s", val, name, err)
+		}
+	}
+	return New(dest.RouteName, prefix, sub, regex, tag, addr, dest.SpoolDir, spool, pickle, dest.Internal, dest.Tags, dest.TagsAllow, dest.TagsDeny, dest.TLS, periodFlush, periodReConn, dest.connBufSize, dest.ioBufSize, dest.SpoolBufSize, dest.SpoolMaxBytesPerFile, dest.SpoolSyncEvery, dest.SpoolSyncPeriod, dest.SpoolSleep, dest.UnspoolSleep)
+}
+
+// parsePeriod parses a strictly positive amount of milliseconds
+func parsePeriod(val string) (time.Duration, error) {
+	ms, err := strconv.Atoi(val)
+	if err != nil {
+		return 0, err
+	}
+	if ms <= 0 {
+		return 0, errors.New("must be > 0")
+	}
+	return time.Duration(ms) * time.Millisecond, nil
+}
+
 func (dest *Destination) UpdateMatcher(matcher matcher.Matcher) {
 	dest.lockMatcher.Lock()
 	defer dest.lockMatcher.Unlock()
@@ -163,16 +274,123 @@ func (dest *Destination) GetMatcher() matcher.Matcher {
 // a "basic" static copy of the dest, not actually running
 func (dest *Destination) Snapshot() *Destination {
 	return &Destination{
-		Matcher:  dest.GetMatcher(),
-		Addr:     dest.Addr,
-		SpoolDir: dest.SpoolDir,
-		Spool:    dest.Spool,
-		Pickle:   dest.Pickle,
-		Online:   dest.Online,
-		Key:      dest.Key,
+		Matcher:              dest.GetMatcher(),
+		Addr:                 dest.Addr,
+		Instance:             dest.Instance,
+		SpoolDir:             dest.SpoolDir,
+		Spool:                dest.Spool,
+		Pickle:               dest.Pickle,
+		Internal:             dest.Internal,
+		Tags:                 dest.Tags,
+		TagsAllow:            dest.TagsAllow,
+		TagsDeny:             dest.TagsDeny,
+		TLS:                  dest.TLS,
+		Online:               dest.IsOnline(),
+		Key:                  dest.Key,
+		periodFlush:          dest.periodFlush,
+		periodReConn:         dest.periodReConn,
+		connBufSize:          dest.connBufSize,
+		ioBufSize:            dest.ioBufSize,
+		SpoolBufSize:         dest.SpoolBufSize,
+		SpoolMaxBytesPerFile: dest.SpoolMaxBytesPerFile,
+		SpoolSyncEvery:       dest.SpoolSyncEvery,
+		SpoolSyncPeriod:      dest.SpoolSyncPeriod,
+		SpoolSleep:           dest.SpoolSleep,
+		UnspoolSleep:         dest.UnspoolSleep,
+		RouteName:            dest.RouteName,
 	}
 }
 
+// ConfigString returns dest in the syntax of the destinations of a route in the config file, with all its options.
+// matcher values are written as is, see the imperatives package for what that syntax supports
+func (dest *Destination) ConfigString() string {
+	addr := dest.Addr
+	if dest.Instance != "" {
+		addr += ":" + dest.Instance
+	}
+	opts := []string{addr}
+	m := dest.GetMatcher()
+	for _, opt := range []struct{ name, val string }{{"prefix", m.Prefix}, {"sub", m.Sub}, {"regex", m.Regex}, {"tag", m.Tag}} {
+		if opt.val != "" {
+			opts = append(opts, opt.name+"="+opt.val)
+		}
+	}
+	opts = append(opts,
+		fmt.Sprintf("flush=%d", dest.periodFlush/time.Millisecond),
+		fmt.Sprintf("reconn=%d", dest.periodReConn/time.Millisecond),
+		fmt.Sprintf("pickle=%t", dest.Pickle),
+		fmt.Sprintf("internal=%t", dest.Internal),
+		fmt.Sprintf("tags=%t", dest.Tags),
+	)
+	if len(dest.TagsAllow) > 0 {
+		opts = append(opts, "tagsallow="+strings.Join(dest.TagsAllow, ","))
+	}
+	if len(dest.TagsDeny) > 0 {
+		opts = append(opts, "tagsdeny="+strings.Join(dest.TagsDeny, ","))
+	}
+	if dest.TLS.Enabled {
+		opts = append(opts, "tls=true")
+		for _, opt := range []struct{ name, val string }{{"tlsca", dest.TLS.CAFile}, {"tlscert", dest.TLS.CertFile}, {"tlskey", dest.TLS.KeyFile}, {"tlsservername", dest.TLS.ServerName}, {"tlsminversion", dest.TLS.MinVersion}} {
+			if opt.val != "" {
+				opts = append(opts, opt.name+"="+opt.val)
+			}
+		}
+	}
+	opts = append(opts,
+		fmt.Sprintf("spool=%t", dest.Spool),
+		fmt.Sprintf("connbuf=%d", dest.connBufSize),
+		fmt.Sprintf("iobuf=%d", dest.ioBufSize),
+		fmt.Sprintf("spoolbuf=%d", dest.SpoolBufSize),
+		fmt.Sprintf("spoolmaxbytesperfile=%d", dest.SpoolMaxBytesPerFile),
+		fmt.Sprintf("spoolsyncevery=%d", dest.SpoolSyncEvery),
+		fmt.Sprintf("spoolsyncperiod=%d", dest.SpoolSyncPeriod/time.Millisecond),
+		fmt.Sprintf("spoolsleep=%d", dest.SpoolSleep/time.Microsecond),
+		fmt.Sprintf("unspoolsleep=%d", dest.UnspoolSleep/time.Microsecond),
+	)
+	return strings.Join(opts, " ")
+}
+
+// Equivalent returns whether other was set up with the same settings as dest,
+// in which case dest can be kept running in place of other, e.g. on a config reload
+func (dest *Destination) Equivalent(other *Destination) bool {
+	m, o := dest.GetMatcher(), other.GetMatcher()
+	return m.Equal(&o) &&
+		dest.Addr == other.Addr &&
+		dest.Instance == other.Instance &&
+		dest.Key == other.Key &&
+		dest.SpoolDir == other.SpoolDir &&
+		dest.Spool == other.Spool &&
+		dest.Pickle == other.Pickle &&
+		dest.Internal == other.Internal &&
+		dest.Tags == other.Tags &&
+		equalStrings(dest.TagsAllow, other.TagsAllow) &&
+		equalStrings(dest.TagsDeny, other.TagsDeny) &&
+		dest.TLS == other.TLS &&
+		dest.periodFlush == other.periodFlush &&
+		dest.periodReConn == other.periodReConn &&
+		dest.connBufSize == other.connBufSize &&
+		dest.ioBufSize == other.ioBufSize &&
+		dest.SpoolBufSize == other.SpoolBufSize &&
+		dest.SpoolMaxBytesPerFile == other.SpoolMaxBytesPerFile &&
+		dest.SpoolSyncEvery == other.SpoolSyncEvery &&
+		dest.SpoolSyncPeriod == other.SpoolSyncPeriod &&
+		dest.SpoolSleep == other.SpoolSleep &&
+		dest.UnspoolSleep == other.UnspoolSleep &&
+		dest.RouteName == other.RouteName
+}
+
+func equalStrings(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
 func (dest *Destination) Run() {
 	if dest.In != nil {
 		dest.logger.Panic("Run() called on already running dest")
@@ -198,6 +416,7 @@ func (dest *Destination) Run() {
 		)
 	}
 	dest.tasks = sync.WaitGroup{}
+	dest.tasks.Add(1)
 	go dest.relay()
 }
 
@@ -224,7 +443,20 @@ func (dest *Destination) updateConn(addr string) {
 	defer func() { dest.inConnUpdate <- false }()
 	key := util.Key(dest.RouteName, addr)
 	addr, instance := addrInstanceSplit(addr)
-	conn, err := NewConn(dest.Key, addr, dest.periodFlush, dest.Pickle, dest.connBufSize, dest.ioBufSize)
+	var tagFilter *TagFilter
+	if dest.Tags {
+		tagFilter = NewTagFilter(dest.TagsAllow, dest.TagsDeny)
+	}
+	var tlsConfig *tls.Config
+	if dest.tlsLoader != nil {
+		var err error
+		tlsConfig, err = dest.tlsLoader.get()
+		if err != nil {
+			dest.logger.Error("dest tls config error", zap.Error(err))
+			return
+		}
+	}
+	conn, err := NewConn(dest.Key, addr, dest.periodFlush, dest.Pickle, dest.Internal, tagFilter, tlsConfig, dest.connBufSize, dest.ioBufSize)
 	if err != nil {
 		dest.logger.Debug("dest updateConn error", zap.Error(err))
 		return
@@ -246,6 +478,33 @@ func (dest *Destination) collectRedo(conn *Conn) {
 	dest.tasks.Done()
 }
 
+// setConn sets the conn which the destination sends to, nil when it's offline
+func (dest *Destination) setConn(conn *Conn) {
+	dest.lockConn.Lock()
+	dest.conn = conn
+	dest.Online = conn != nil
+	dest.lockConn.Unlock()
+}
+
+// IsOnline returns whether the destination has a conn up. unlike Online, it's safe to call while the destination runs
+func (dest *Destination) IsOnline() bool {
+	dest.lockConn.Lock()
+	defer dest.lockConn.Unlock()
+	return dest.Online
+}
+
+// Queued returns how many metrics are waiting to be sent: in the input of the destination, and in the buffer of its conn
+func (dest *Destination) Queued() int {
+	dest.lockConn.Lock()
+	conn := dest.conn
+	dest.lockConn.Unlock()
+	queued := len(dest.In)
+	if conn != nil {
+		queued += len(conn.In)
+	}
+	return queued
+}
+
 func (dest *Destination) WaitOnline() chan struct{} {
 	signalConnOnline := make(chan struct{})
 	dest.setSignalConnOnline <- signalConnOnline
@@ -270,6 +529,8 @@ func (dest *Destination) nonBlockingSend(dp encoding.Datapoint, conn *Conn) {
 // TODO func (l *TCPListener) SetDeadline(t time.Time)
 // TODO Decide when to drop this buffer and move on.
 func (dest *Destination) relay() {
+	// Shutdown waits for the conn and spool to be closed
+	defer dest.tasks.Done()
 	ticker := time.NewTicker(dest.periodReConn)
 	defer ticker.Stop()
 	var toUnspool chan encoding.Datapoint
@@ -297,7 +558,7 @@ func (dest *Destination) relay() {
 	for {
 		if conn != nil {
 			if !conn.isAlive() {
-				dest.Online = false
+				dest.setConn(nil)
 				if dest.Spool {
 					dest.tasks.Add(1)
 					go dest.collectRedo(conn)
@@ -329,7 +590,7 @@ func (dest *Destination) relay() {
 				numConnUpdates -= 1
 			}
 		case conn = <-dest.connUpdates:
-			dest.Online = true
+			dest.setConn(conn)
 			dest.logger.Info("dest new conn online")
 			// new conn? start with a clean slate!
 			dest.SlowLastLoop = false
@@ -6,9 +6,22 @@ import (
 
 	"go.uber.org/zap"
 
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
 	ogorek "github.com/kisielk/og-rek"
 )
 
+// a pickle frame holds up to pickleMaxDatapoints datapoints, like the MAX_DATAPOINTS_PER_MESSAGE of carbon,
+// and about pickleMaxFrameSize bytes, well below the max frame size of the receivers
+var (
+	pickleMaxDatapoints = 500
+	pickleMaxFrameSize  = 256 * 1024
+)
+
+// pickleDatapointOverhead is about how many bytes a datapoint takes in a pickle frame, on top of its name
+const pickleDatapointOverhead = 32
+
+// Pickle returns a frame holding the single datapoint dp.
+// connections send frames of many datapoints instead, see Conn.writePickle
 func Pickle(dp *Datapoint) []byte {
 	dataBuf := &bytes.Buffer{}
 	pickler := ogorek.NewEncoder(dataBuf)
@@ -25,3 +38,33 @@ func Pickle(dp *Datapoint) []byte {
 	messageBuf.Write(dataBuf.Bytes())
 	return messageBuf.Bytes()
 }
+
+// writePickle adds dp to the pickle frame in progress, and writes the frame once it's full.
+// it returns the number of bytes written, which is 0 until the frame is written
+func (c *Conn) writePickle(dp encoding.Datapoint) (int, error) {
+	name := dp.Name
+	if c.tagFilter != nil {
+		name = c.tagFilter.Name(dp)
+	}
+	c.pickleBatch = append(c.pickleBatch, encoding.Datapoint{Name: name, Value: dp.Value, Timestamp: dp.Timestamp})
+	c.pickleBatchSize += len(name) + pickleDatapointOverhead
+	if len(c.pickleBatch) < pickleMaxDatapoints && c.pickleBatchSize < pickleMaxFrameSize {
+		return 0, nil
+	}
+	return c.flushPickle()
+}
+
+// flushPickle writes the pickle frame in progress, if any, to the buffered writer
+func (c *Conn) flushPickle() (int, error) {
+	if len(c.pickleBatch) == 0 {
+		return 0, nil
+	}
+	c.pickleFrame = encoding.AppendPickleFrame(c.pickleFrame[:0], c.pickleBatch)
+	c.pickleBatch = c.pickleBatch[:0]
+	c.pickleBatchSize = 0
+	n, err := c.buffered.Write(c.pickleFrame)
+	if err != nil {
+		errCounter.WithLabelValues(c.key, "write").Inc()
+	}
+	return n, err
+}
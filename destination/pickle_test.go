@@ -0,0 +1,126 @@
+package destination
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConnPickleFrames(t *testing.T) {
+	defer func(max int) { pickleMaxDatapoints = max }(pickleMaxDatapoints)
+	pickleMaxDatapoints = 3
+
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	accepted := make(chan net.Conn, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			accepted <- conn
+		}
+	}()
+
+	conn, err := NewConn("test", ln.Addr().String(), time.Minute, true, false, NewTagFilter(nil, nil), nil, 10, 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	serverConn := <-accepted
+	defer serverConn.Close()
+
+	for i := 0; i < 7; i++ {
+		conn.In <- encoding.Datapoint{Name: "a.b" + strconv.Itoa(i), Value: float64(i), Timestamp: 10, Tags: encoding.Tags{"dc": "par"}}
+	}
+	// wait for the points to be handled, as a flush may come first
+	for i := 0; i < 100 && len(conn.In) > 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	assert.NoError(t, conn.Flush())
+
+	// the points come in full frames, and the flush writes the last one
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(serverConn)
+	h := encoding.NewPickle(false, 0)
+	var sizes []int
+	var received []encoding.Datapoint
+	for len(received) < 7 {
+		var header [4]byte
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			t.Fatal(err)
+		}
+		frame := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(r, frame); err != nil {
+			t.Fatal(err)
+		}
+		dps, err := h.LoadFrame(frame, encoding.Tags{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(dps))
+		received = append(received, dps...)
+	}
+	assert.Equal(t, []int{3, 3, 1}, sizes)
+	// the names are tagged, as with the plain format
+	assert.Equal(t, encoding.Datapoint{Name: "a.b0", Value: 0, Timestamp: 10, Tags: encoding.Tags{"dc": "par"}}, received[0])
+	assert.Equal(t, encoding.Datapoint{Name: "a.b6", Value: 6, Timestamp: 10, Tags: encoding.Tags{"dc": "par"}}, received[6])
+}
+
+func benchmarkDatapoints() []encoding.Datapoint {
+	dps := make([]encoding.Datapoint, 1000)
+	for i := range dps {
+		dps[i] = encoding.Datapoint{Name: "abcde_fghij.klmnopqrst.uv_wxyz.1234567890abcdefg." + strconv.Itoa(i), Value: 12345.6789, Timestamp: 1234567890}
+	}
+	return dps
+}
+
+// BenchmarkPicklePerPoint is how conns used to write pickle: a text line per datapoint, parsed back and pickled in its own frame
+func BenchmarkPicklePerPoint(b *testing.B) {
+	dps := benchmarkDatapoints()
+	buffered := bufio.NewWriter(ioutil.Discard)
+	var line []byte
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		dp := dps[i%len(dps)]
+		line = append(line[:0], dp.Name...)
+		line = append(line, ' ')
+		line = strconv.AppendFloat(line, dp.Value, 'f', -1, 64)
+		line = append(line, ' ')
+		line = strconv.AppendUint(line, dp.Timestamp, 10)
+		parsed, err := ParseDataPoint(line)
+		if err != nil {
+			b.Fatal(err)
+		}
+		n, _ := buffered.Write(Pickle(parsed))
+		b.SetBytes(int64(n))
+	}
+}
+
+func BenchmarkPickleFrames(b *testing.B) {
+	dps := benchmarkDatapoints()
+	c := &Conn{buffered: bufio.NewWriter(ioutil.Discard), key: "bench", pickle: true}
+	var written int64
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		n, err := c.writePickle(dps[i%len(dps)])
+		if err != nil {
+			b.Fatal(err)
+		}
+		written += int64(n)
+	}
+	n, _ := c.flushPickle()
+	written += int64(n)
+	b.SetBytes(written / int64(b.N))
+}
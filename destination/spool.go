@@ -91,7 +91,12 @@ func (s *Spool) Reader() {
 	h := encoding.NewPlain(false)
 	for {
 		if queue.Length() == 0 {
-			time.Sleep(500 * time.Millisecond)
+			select {
+			case <-s.shutdownReader:
+				close(ch)
+				return
+			case <-time.After(500 * time.Millisecond):
+			}
 			continue
 		}
 		i, err := queue.Dequeue()
@@ -162,6 +167,7 @@ func (s *Spool) Buffer() {
 func (s *Spool) Close() {
 	s.shutdownWriter <- true
 	s.shutdownBuffer <- true
-	// we don't need to close Out, our user should just not read from it anymore. destination does this
+	// the reader closes Out. our user should just not read from it anymore. destination does this
+	s.shutdownReader <- true
 	s.queue.Close()
 }
@@ -0,0 +1,66 @@
+package destination
+
+import (
+	"strings"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+)
+
+// DefaultTagsDeny are the provenance tags set by the inputs. they are useful within
+// the relay but not meant for the backends, so they are stripped unless allowed explicitly
+var DefaultTagsDeny = []string{"carbonRelayInstance", "appIpPortSrc", "appClientCert"}
+
+// TagFilter selects the tags sent along with the name, as graphite 1.1 tagged series (name;k=v;...)
+// a tag is kept if it is in the allowlist (or the allowlist is empty) and is not in the denylist.
+// DefaultTagsDeny is always part of the denylist, except for the keys which are explicitly allowed
+type TagFilter struct {
+	allow map[string]struct{}
+	deny  map[string]struct{}
+}
+
+func NewTagFilter(allow, deny []string) *TagFilter {
+	f := &TagFilter{
+		allow: make(map[string]struct{}, len(allow)),
+		deny:  make(map[string]struct{}, len(deny)+len(DefaultTagsDeny)),
+	}
+	for _, k := range allow {
+		f.allow[k] = struct{}{}
+	}
+	for _, k := range DefaultTagsDeny {
+		if _, ok := f.allow[k]; !ok {
+			f.deny[k] = struct{}{}
+		}
+	}
+	for _, k := range deny {
+		f.deny[k] = struct{}{}
+	}
+	return f
+}
+
+func (f *TagFilter) Keep(key string) bool {
+	if _, ok := f.deny[key]; ok {
+		return false
+	}
+	if len(f.allow) == 0 {
+		return true
+	}
+	_, ok := f.allow[key]
+	return ok
+}
+
+// Name returns the graphite 1.1 tagged name of dp, holding the kept tags.
+// tags which can't be expressed in the carbon protocol are left out
+func (f *TagFilter) Name(dp encoding.Datapoint) string {
+	tags := make(encoding.Tags, len(dp.Tags))
+	for k, v := range dp.Tags {
+		if f.Keep(k) && validTag(k, v) {
+			tags[k] = v
+		}
+	}
+	dp.Tags = tags
+	return dp.FullName()
+}
+
+func validTag(k, v string) bool {
+	return k != "" && v != "" && !strings.ContainsAny(k, " ;=!^") && !strings.ContainsAny(v, " ;") && v[0] != '~'
+}
@@ -0,0 +1,49 @@
+package destination
+
+import (
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagFilterName(t *testing.T) {
+	dp := encoding.Datapoint{Name: "a.b", Value: 1, Timestamp: 1, Tags: encoding.Tags{
+		"carbonRelayInstance": "relay1",
+		"appIpPortSrc":        "10.0.0.1:1234",
+		"dc":                  "par",
+		"env":                 "prod",
+	}}
+	cases := []struct {
+		name  string
+		allow []string
+		deny  []string
+		exp   string
+	}{
+		{"default", nil, nil, "a.b;dc=par;env=prod"},
+		{"deny", nil, []string{"env"}, "a.b;dc=par"},
+		{"allow", []string{"env"}, nil, "a.b;env=prod"},
+		{"allowProvenance", []string{"carbonRelayInstance", "dc"}, nil, "a.b;carbonRelayInstance=relay1;dc=par"},
+		{"allowAndDeny", []string{"dc", "env"}, []string{"dc"}, "a.b;env=prod"},
+		{"nothingLeft", []string{"missing"}, nil, "a.b"},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.exp, NewTagFilter(c.allow, c.deny).Name(dp))
+		})
+	}
+	// the datapoint is shared between destinations, it must be left untouched
+	assert.Len(t, dp.Tags, 4)
+}
+
+func TestTagFilterInvalidTags(t *testing.T) {
+	dp := encoding.Datapoint{Name: "a.b", Tags: encoding.Tags{
+		"space": "a b",
+		"semi":  "a;b",
+		"tilde": "~a",
+		"empty": "",
+		"k=v":   "a",
+		"valid": "a=b",
+	}}
+	assert.Equal(t, "a.b;valid=a=b", NewTagFilter(nil, nil).Name(dp))
+}
@@ -0,0 +1,149 @@
+package destination
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+)
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+// TLSConfig holds the TLS settings of a destination. TLS is used when Enabled, plain tcp otherwise
+type TLSConfig struct {
+	Enabled    bool   `json:"enabled"`
+	CAFile     string `json:"caFile"`     // CA bundle to verify the server with. the system's CAs when empty
+	CertFile   string `json:"certFile"`   // client certificate, for mutual TLS
+	KeyFile    string `json:"keyFile"`    // key of the client certificate
+	ServerName string `json:"serverName"` // name to verify the server certificate with. the host of the address when empty
+	MinVersion string `json:"minVersion"` // 1.0, 1.1, 1.2 or 1.3. 1.2 when empty
+}
+
+func (c TLSConfig) validate() error {
+	if !c.Enabled {
+		if c != (TLSConfig{}) {
+			return errors.New("tls options need tls to be enabled")
+		}
+		return nil
+	}
+	if (c.CertFile == "") != (c.KeyFile == "") {
+		return errors.New("tls cert and key must be set together")
+	}
+	if _, ok := tlsVersions[c.MinVersion]; !ok && c.MinVersion != "" {
+		return fmt.Errorf("unknown tls min version '%s', need 1.0/1.1/1.2/1.3", c.MinVersion)
+	}
+	return nil
+}
+
+// tlsLoader builds the tls.Config of a destination from its TLSConfig, and builds it again when its files change,
+// so that rotated certificates are used by the next connections, without replacing the destination
+type tlsLoader struct {
+	TLSConfig
+
+	sync.Mutex
+	config *tls.Config
+	stats  []fileStat // of the CA, cert and key files, when config was built
+}
+
+type fileStat struct {
+	modTime time.Time
+	size    int64
+}
+
+func newTLSLoader(c TLSConfig) (*tlsLoader, error) {
+	if err := c.validate(); err != nil {
+		return nil, err
+	}
+	if !c.Enabled {
+		return nil, nil
+	}
+	l := &tlsLoader{TLSConfig: c}
+	if _, err := l.get(); err != nil {
+		return nil, err
+	}
+	return l, nil
+}
+
+// get returns the tls.Config to connect with, built again if any of the files changed since the last time
+func (l *tlsLoader) get() (*tls.Config, error) {
+	l.Lock()
+	defer l.Unlock()
+	stats, err := l.statFiles()
+	if err != nil {
+		return nil, err
+	}
+	if l.config != nil && equalStats(stats, l.stats) {
+		return l.config, nil
+	}
+	config, err := l.build()
+	if err != nil {
+		return nil, err
+	}
+	l.config, l.stats = config, stats
+	return config, nil
+}
+
+func (l *tlsLoader) statFiles() ([]fileStat, error) {
+	var stats []fileStat
+	for _, path := range []string{l.CAFile, l.CertFile, l.KeyFile} {
+		if path == "" {
+			stats = append(stats, fileStat{})
+			continue
+		}
+		fi, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, fileStat{fi.ModTime(), fi.Size()})
+	}
+	return stats, nil
+}
+
+func equalStats(a, b []fileStat) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].modTime.Equal(b[i].modTime) || a[i].size != b[i].size {
+			return false
+		}
+	}
+	return true
+}
+
+func (l *tlsLoader) build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName: l.ServerName,
+		MinVersion: tls.VersionTLS12,
+	}
+	if l.MinVersion != "" {
+		config.MinVersion = tlsVersions[l.MinVersion]
+	}
+	if l.CAFile != "" {
+		pem, err := ioutil.ReadFile(l.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in tls ca file '%s'", l.CAFile)
+		}
+	}
+	if l.CertFile != "" {
+		cert, err := tls.LoadX509KeyPair(l.CertFile, l.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+	return config, nil
+}
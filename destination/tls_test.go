@@ -0,0 +1,177 @@
+package destination
+
+import (
+	"bufio"
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/internal/testcert"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfigValidate(t *testing.T) {
+	cases := []struct {
+		name  string
+		conf  TLSConfig
+		valid bool
+	}{
+		{"plain", TLSConfig{}, true},
+		{"systemCAs", TLSConfig{Enabled: true}, true},
+		{"optionsWithoutTLS", TLSConfig{ServerName: "graphite"}, false},
+		{"certWithoutKey", TLSConfig{Enabled: true, CertFile: "cert.pem"}, false},
+		{"minVersion", TLSConfig{Enabled: true, MinVersion: "1.3"}, true},
+		{"unknownMinVersion", TLSConfig{Enabled: true, MinVersion: "1.4"}, false},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			err := c.conf.validate()
+			assert.Equal(t, c.valid, err == nil, "error: %v", err)
+		})
+	}
+}
+
+func TestTLSConn(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testcert.Issue(t, "ca", 1, nil)
+	server := testcert.Issue(t, "graphite", 2, &ca)
+	client := testcert.Issue(t, "relay", 3, &ca)
+	conf := TLSConfig{
+		Enabled:    true,
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "relay.pem"),
+		KeyFile:    filepath.Join(dir, "relay.key"),
+		ServerName: "graphite",
+		MinVersion: "1.2",
+	}
+	testcert.Write(t, ca, conf.CAFile, "")
+	testcert.Write(t, client, conf.CertFile, conf.KeyFile)
+
+	// the server requires a client certificate issued by the CA
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(ca.Leaf)
+	ln, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{server},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    clientCAs,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	// the server does the handshake on its first read or write, while NewConn waits for it
+	accepted := make(chan net.Conn, 1)
+	accept := func() {
+		conn, err := ln.Accept()
+		if err == nil {
+			conn.(*tls.Conn).Handshake()
+			accepted <- conn
+		}
+	}
+	go accept()
+
+	loader, err := newTLSLoader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err := loader.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := NewConn("test", ln.Addr().String(), time.Second, false, false, nil, tlsConfig, 10, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConn := <-accepted
+
+	conn.In <- encoding.Datapoint{Name: "a.b", Value: 1, Timestamp: 10}
+	assert.NoError(t, conn.Flush())
+	serverConn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	line, err := bufio.NewReader(serverConn).ReadString('\n')
+	assert.NoError(t, err)
+	assert.Equal(t, "a.b 1 10\n", line)
+
+	// checkEOF must notice when the server closes the conn, even without a close_notify alert
+	serverConn.(*tls.Conn).NetConn().Close()
+	for i := 0; i < 100 && conn.isAlive(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, conn.isAlive(), "conn must be closed after the server closed it")
+
+	// a server with a certificate for another name is refused
+	conf.ServerName = "other"
+	loader, err = newTLSLoader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tlsConfig, err = loader.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	go accept()
+	_, err = NewConn("test", ln.Addr().String(), time.Second, false, false, nil, tlsConfig, 10, 100)
+	assert.Error(t, err)
+}
+
+func TestTLSLoaderReload(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testcert.Issue(t, "ca", 1, nil)
+	conf := TLSConfig{
+		Enabled:  true,
+		CertFile: filepath.Join(dir, "relay.pem"),
+		KeyFile:  filepath.Join(dir, "relay.key"),
+	}
+	testcert.Write(t, testcert.Issue(t, "relay", 2, &ca), conf.CertFile, conf.KeyFile)
+	loader, err := newTLSLoader(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	first, err := loader.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	same, err := loader.get()
+	assert.NoError(t, err)
+	assert.True(t, first == same, "config must be kept while the files don't change")
+
+	// rotate the certificate, with a later modification time in case the filesystem has a coarse one
+	testcert.Write(t, testcert.Issue(t, "relay", 3, &ca), conf.CertFile, conf.KeyFile)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{conf.CertFile, conf.KeyFile} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rotated, err := loader.get()
+	if err != nil {
+		t.Fatal(err)
+	}
+	leaf, err := x509.ParseCertificate(rotated.Certificates[0].Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, int64(3), leaf.SerialNumber.Int64())
+
+	// a broken rotation fails the next connections, until it's fixed
+	if err := ioutil.WriteFile(conf.KeyFile, []byte("garbage"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	_, err = loader.get()
+	assert.Error(t, err)
+}
@@ -1,60 +1,192 @@
 package encoding
 
-// import (
-// 	"bufio"
-// 	"encoding/binary"
-// 	"fmt"
-// 	"io"
-// 	"math"
-
-// 	"github.com/graphite-ng/carbon-relay-ng/input"
-// 	log "github.com/sirupsen/logrus"
-// )
-
-// // Internal Format is an kinda-optimized serialization to be used between relays
-// type InternalFormatAdapter struct {
-// }
-
-// func (b *InternalFormatAdapter) Kind() string {
-// 	return "internal"
-// }
-
-// func (b *InternalFormatAdapter) Handle(c io.Reader) error {
-// 	r := bufio.NewReaderSize(c, 1024)
-// 	log.Debug("Launching internal format handler")
-// 	for {
-// 		// Note that everything in this loop should proceed as fast as it can
-// 		// so we're not blocked and can keep processing
-// 		// so the validation, the pipeline initiated via dispatcher.Dispatch(), etc
-// 		// must never block.
-// 		var nameLenght uint8
-// 		err := binary.Read(r, binary.BigEndian, &nameLenght)
-// 		if err != nil {
-// 			if err != io.EOF {
-// 				return fmt.Errorf("couldn't metric name length: %s", err)
-// 			}
-// 			log.Debug("binary.go: error detecting metric name length. Message is finished")
-// 			return nil
-// 		}
-// 		log.Debugf("binary.go: done detecting metric name length with binary.Read, length is %d", nameLenght)
-
-// 		log.Debug("binary.go: reading metric name")
-// 		name := make([]byte, nameLenght)
-// 		err = binary.Read(r, binary.BigEndian, name)
-// 		if err != nil {
-// 			return fmt.Errorf("couldn't read metric name: %s", err)
-// 		}
-// 		var valueRaw uint64
-// 		err = binary.Read(r, binary.BigEndian, valueRaw)
-// 		if err != nil {
-// 			return fmt.Errorf("can't read the datapoint float value for `%s`: %s", string(name), err)
-// 		}
-// 		value := math.Float64frombits(valueRaw)
-// 		var time uint64
-// 		time, err = binary.ReadUvarint(r)
-// 		if err != nil {
-// 			return fmt.Errorf("can't read the datapoint time value for `%s`: %s", string(name), err)
-// 		}
-// 		input.Dispatcher.DispatchMetric(Datapoint{Name: name, Timestamp: time, Value: value})
-// 	}
-// }
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+)
+
+var (
+	errInternalVersion   = "unsupported internal format version %d"
+	errInternalTruncated = errors.New("truncated internal frame")
+	errInternalVarint    = errors.New("malformed varint in internal frame")
+	errInternalBadName   = "metric name %q is not a plain metric path"
+)
+
+const InternalFormat FormatName = "internal"
+
+// InternalFormatVersion is the version byte starting every internal frame
+const InternalFormatVersion uint8 = 1
+
+// InternalFormatAdapter is a kinda-optimized serialization to be used between relays.
+// Frames are length prefixed like pickle ones (4 bytes, big endian), the payload is:
+//
+//   version         uint8
+//   then for every datapoint:
+//   name            uvarint length + bytes
+//   value           float64 bits, 8 bytes big endian
+//   timestamp       uvarint
+//   tags            uvarint count, then for every tag: uvarint length + key, uvarint length + value
+//
+// Tags carried by the frame take precedence over the ones of the input, so that
+// the tags set by the first relay (carbonRelayInstance, appIpPortSrc, ...) are kept,
+// unless the input resets them, see the trust_tags setting of the listeners.
+type InternalFormatAdapter struct {
+	maxFrameSize uint32
+}
+
+func NewInternal(maxFrameSize uint32) InternalFormatAdapter {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return InternalFormatAdapter{maxFrameSize: maxFrameSize}
+}
+
+func (i InternalFormatAdapter) KindS() string {
+	return string(InternalFormat)
+}
+
+func (i InternalFormatAdapter) Kind() FormatName {
+	return InternalFormat
+}
+
+func (i InternalFormatAdapter) MaxFrameSize() uint32 {
+	return i.maxFrameSize
+}
+
+// Dump returns the payload of a frame holding dp, without the length prefix
+func (i InternalFormatAdapter) Dump(dp Datapoint) []byte {
+	return appendInternalDatapoint([]byte{InternalFormatVersion}, dp)
+}
+
+// Load decodes a frame which must contain exactly one datapoint.
+// Use LoadFrame for frames carrying several datapoints
+func (i InternalFormatAdapter) Load(msg []byte, tags Tags) (Datapoint, error) {
+	dps, err := i.LoadFrame(msg, tags)
+	if err != nil {
+		return Datapoint{}, err
+	}
+	if len(dps) != 1 {
+		return Datapoint{}, fmt.Errorf("expected a single datapoint in internal frame, got %d", len(dps))
+	}
+	return dps[0], nil
+}
+
+// LoadFrame decodes the payload of a frame (without its length prefix)
+func (i InternalFormatAdapter) LoadFrame(msg []byte, tags Tags) ([]Datapoint, error) {
+	if tags == nil {
+		return nil, fmt.Errorf(errNoTags)
+	}
+	if len(msg) == 0 {
+		return nil, errEmptyline
+	}
+	if msg[0] != InternalFormatVersion {
+		return nil, fmt.Errorf(errInternalVersion, msg[0])
+	}
+	r := internalReader{buf: msg[1:]}
+	var dps []Datapoint
+	for len(r.buf) > 0 {
+		name, err := r.string()
+		if err != nil {
+			return dps, err
+		}
+		path, err := parseMetricPath(name)
+		if err != nil {
+			return dps, newValidationError(ErrorType(err), errInternalBadName, name)
+		}
+		if path != name || name == "" {
+			return dps, newValidationError(ErrorTypeValidation, errInternalBadName, name)
+		}
+		if len(r.buf) < 8 {
+			return dps, errInternalTruncated
+		}
+		value := math.Float64frombits(binary.BigEndian.Uint64(r.buf))
+		r.buf = r.buf[8:]
+		ts, err := r.uvarint()
+		if err != nil {
+			return dps, err
+		}
+		numTags, err := r.uvarint()
+		if err != nil {
+			return dps, err
+		}
+		// every tag takes at least 2 bytes, don't trust the count blindly
+		if numTags > uint64(len(r.buf)/2) {
+			return dps, errInternalTruncated
+		}
+		dpTags := make(Tags, len(tags)+int(numTags))
+		for k, v := range tags {
+			dpTags[k] = v
+		}
+		for t := uint64(0); t < numTags; t++ {
+			k, err := r.string()
+			if err != nil {
+				return dps, err
+			}
+			v, err := r.string()
+			if err != nil {
+				return dps, err
+			}
+			dpTags[k] = v
+		}
+		dps = append(dps, Datapoint{Name: name, Timestamp: ts, Value: value, Tags: dpTags})
+	}
+	return dps, nil
+}
+
+// AppendInternalFrame appends to buf a length prefixed frame holding dps
+func AppendInternalFrame(buf []byte, dps []Datapoint) []byte {
+	start := len(buf)
+	buf = append(buf, 0, 0, 0, 0, InternalFormatVersion)
+	for _, dp := range dps {
+		buf = appendInternalDatapoint(buf, dp)
+	}
+	binary.BigEndian.PutUint32(buf[start:], uint32(len(buf)-start-4))
+	return buf
+}
+
+func appendInternalDatapoint(buf []byte, dp Datapoint) []byte {
+	buf = appendInternalString(buf, dp.Name)
+	var value [8]byte
+	binary.BigEndian.PutUint64(value[:], math.Float64bits(dp.Value))
+	buf = append(buf, value[:]...)
+	buf = appendVarint(buf, dp.Timestamp)
+	buf = appendVarint(buf, uint64(len(dp.Tags)))
+	for k, v := range dp.Tags {
+		buf = appendInternalString(buf, k)
+		buf = appendInternalString(buf, v)
+	}
+	return buf
+}
+
+func appendInternalString(buf []byte, s string) []byte {
+	buf = appendVarint(buf, uint64(len(s)))
+	return append(buf, s...)
+}
+
+type internalReader struct {
+	buf []byte
+}
+
+func (r *internalReader) uvarint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errInternalVarint
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+func (r *internalReader) string() (string, error) {
+	l, err := r.uvarint()
+	if err != nil {
+		return "", err
+	}
+	if l > uint64(len(r.buf)) {
+		return "", errInternalTruncated
+	}
+	s := string(r.buf[:l])
+	r.buf = r.buf[l:]
+	return s, nil
+}
@@ -0,0 +1,51 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInternalRoundTrip(t *testing.T) {
+	h := NewInternal(0)
+	in := []Datapoint{
+		{Name: "test.metric", Value: 10.5, Timestamp: 1000, Tags: Tags{"carbonRelayInstance": "relay1", "dc": "par"}},
+		{Name: "test.other", Value: -3, Timestamp: 1001, Tags: Tags{}},
+	}
+	frame := AppendInternalFrame(nil, in)
+	assert.Equal(t, uint32(len(frame)-4), binary.BigEndian.Uint32(frame[:4]))
+	assert.Equal(t, InternalFormatVersion, frame[4])
+
+	dps, err := h.LoadFrame(frame[4:], Tags{"carbonRelayInstance": "relay2", "appIpPortSrc": "10.0.0.1:1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, []Datapoint{
+		// tags of the frame win over the ones of the input
+		{Name: "test.metric", Value: 10.5, Timestamp: 1000, Tags: Tags{"carbonRelayInstance": "relay1", "appIpPortSrc": "10.0.0.1:1234", "dc": "par"}},
+		{Name: "test.other", Value: -3, Timestamp: 1001, Tags: Tags{"carbonRelayInstance": "relay2", "appIpPortSrc": "10.0.0.1:1234"}},
+	}, dps)
+
+	dp, err := h.Load(h.Dump(in[0]), Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, in[0], dp)
+}
+
+func TestInternalInvalid(t *testing.T) {
+	h := NewInternal(0)
+	valid := h.Dump(Datapoint{Name: "test.metric", Value: 1, Timestamp: 1, Tags: Tags{"a": "b"}})
+	payloads := map[string][]byte{
+		"empty":        {},
+		"version":      append([]byte{InternalFormatVersion + 1}, valid[1:]...),
+		"truncated":    valid[:len(valid)-1],
+		"noValue":      valid[:1+1+len("test.metric")+4],
+		"tagCount":     {InternalFormatVersion, 1, 'a', 0, 0, 0, 0, 0, 0, 0, 0, 1, 0xff, 0x01},
+		"badName":      h.Dump(Datapoint{Name: "test..metric", Value: 1, Timestamp: 1}),
+		"graphiteTags": h.Dump(Datapoint{Name: "test.metric;a=b", Value: 1, Timestamp: 1}),
+	}
+	for test, payload := range payloads {
+		t.Run(test, func(t *testing.T) {
+			_, err := h.LoadFrame(payload, Tags{})
+			assert.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package encoding
+
+import "fmt"
+
+// types of the errors returned when loading a message, see ErrorType
+const (
+	ErrorTypeParse      = "parse"      // the message can't be parsed
+	ErrorTypeNonASCII   = "non_ascii"  // the metric name has non-ascii (or invalid utf-8) characters
+	ErrorTypeValidation = "validation" // the metric name or tags are invalid
+)
+
+// ValidationError is the error of a message that parses, but whose metric name or tags are invalid
+type ValidationError struct {
+	Type string // ErrorTypeNonASCII or ErrorTypeValidation
+	msg  string
+}
+
+func (e *ValidationError) Error() string {
+	return e.msg
+}
+
+func newValidationError(errType, format string, a ...interface{}) *ValidationError {
+	return &ValidationError{Type: errType, msg: fmt.Sprintf(format, a...)}
+}
+
+// ErrorType returns the type of an error returned by FormatAdapter.Load or FramedFormatAdapter.LoadFrame
+func ErrorType(err error) string {
+	if e, ok := err.(*ValidationError); ok {
+		return e.Type
+	}
+	return ErrorTypeParse
+}
@@ -4,6 +4,8 @@ import "fmt"
 
 type FormatOptions struct {
 	Strict bool `mapstructure:"strict,omitempty"`
+	// MaxFrameSize bounds the size of a single frame for framed formats (pickle, internal)
+	MaxFrameSize uint32 `mapstructure:"max_frame_size,omitempty"`
 }
 
 type FormatAdapter interface {
@@ -13,14 +15,26 @@ type FormatAdapter interface {
 	Kind() FormatName
 }
 
+// FramedFormatAdapter is implemented by formats whose stream is made of
+// length prefixed frames, each of them carrying several datapoints
+type FramedFormatAdapter interface {
+	FormatAdapter
+	MaxFrameSize() uint32
+	LoadFrame(frame []byte, tags Tags) ([]Datapoint, error)
+}
+
 type FormatName string
 
 func (f FormatName) ToHandler(fo FormatOptions) (FormatAdapter, error) {
 	switch f {
 	case PlainFormat:
 		return NewPlain(fo.Strict), nil
+	case PickleFormat:
+		return NewPickle(fo.Strict, fo.MaxFrameSize), nil
+	case InternalFormat:
+		return NewInternal(fo.MaxFrameSize), nil
 	case "":
-		return nil, fmt.Errorf("`format` key can't be empty. Possible value: [plain]")
+		return nil, fmt.Errorf("`format` key can't be empty. Possible value: [plain, pickle, internal]")
 	default:
 		return nil, fmt.Errorf("please use a valid \"format\" for `%s`", f)
 	}
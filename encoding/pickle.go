@@ -0,0 +1,254 @@
+package encoding
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+	"strconv"
+
+	ogorek "github.com/kisielk/og-rek"
+)
+
+var (
+	errPickleNotAList       = "pickle payload is not a list but %T"
+	errPickleBadItem        = "pickle item #%d is not a (path, (timestamp, value)) tuple"
+	errPickleBadName        = "pickle item #%d has a name of type %T"
+	errPickleBadTimestamp   = "pickle item #%d has a bad timestamp: %s"
+	errPickleBadValue       = "pickle item #%d has a bad value: %s"
+	errPickleTooLong        = "pickle opcode %q at %d has a length of %d bytes, but only %d bytes are left in the frame"
+	errPickleUnexpectedType = errors.New("unexpected type")
+	errNegativeTimestamp    = errors.New("negative timestamp")
+)
+
+const PickleFormat FormatName = "pickle"
+
+// DefaultMaxFrameSize is the default maximum size of a single framed message.
+// It mirrors the MAX_LENGTH of the python carbon pickle receiver.
+const DefaultMaxFrameSize = 1 << 20
+
+// PickleAdapter handles the carbon pickle protocol: each frame is a pickled
+// list of `(path, (timestamp, value))` tuples.
+// Only plain python types are accepted, anything referring to a class
+// (GLOBAL, REDUCE, INST, ...) is rejected rather than interpreted.
+type PickleAdapter struct {
+	Validate     bool
+	maxFrameSize uint32
+}
+
+func NewPickle(validate bool, maxFrameSize uint32) PickleAdapter {
+	if maxFrameSize == 0 {
+		maxFrameSize = DefaultMaxFrameSize
+	}
+	return PickleAdapter{Validate: validate, maxFrameSize: maxFrameSize}
+}
+
+func (p PickleAdapter) KindS() string {
+	return string(PickleFormat)
+}
+
+func (p PickleAdapter) Kind() FormatName {
+	return PickleFormat
+}
+
+func (p PickleAdapter) MaxFrameSize() uint32 {
+	return p.maxFrameSize
+}
+
+// Dump returns the pickled datapoint, without the length prefix used for framing
+func (p PickleAdapter) Dump(dp Datapoint) []byte {
+	return pickleDatapoints(&bytes.Buffer{}, []Datapoint{dp}).Bytes()
+}
+
+// Load decodes a frame which must contain exactly one datapoint.
+// Use LoadFrame for frames carrying several datapoints
+func (p PickleAdapter) Load(msg []byte, tags Tags) (Datapoint, error) {
+	dps, err := p.LoadFrame(msg, tags)
+	if err != nil {
+		return Datapoint{}, err
+	}
+	if len(dps) != 1 {
+		return Datapoint{}, fmt.Errorf("expected a single datapoint in pickle payload, got %d", len(dps))
+	}
+	return dps[0], nil
+}
+
+// LoadFrame decodes the payload of a frame (without its length prefix)
+// every returned datapoint gets its own copy of tags
+func (p PickleAdapter) LoadFrame(msg []byte, tags Tags) ([]Datapoint, error) {
+	if tags == nil {
+		return nil, fmt.Errorf(errNoTags)
+	}
+	if len(msg) == 0 {
+		return nil, errEmptyline
+	}
+	// the decoder allocates the declared length of strings before reading them
+	if err := checkPickleLengths(msg); err != nil {
+		return nil, err
+	}
+	raw, err := ogorek.NewDecoder(bytes.NewReader(msg)).Decode()
+	if err != nil {
+		return nil, fmt.Errorf("can't unpickle payload: %s", err)
+	}
+	var items []interface{}
+	switch v := raw.(type) {
+	case []interface{}:
+		items = v
+	case ogorek.Tuple:
+		items = v
+	default:
+		return nil, fmt.Errorf(errPickleNotAList, raw)
+	}
+
+	dps := make([]Datapoint, 0, len(items))
+	for i, rawItem := range items {
+		item, ok := rawItem.(ogorek.Tuple)
+		if !ok || len(item) != 2 {
+			return dps, fmt.Errorf(errPickleBadItem, i)
+		}
+		name, ok := item[0].(string)
+		if !ok {
+			return dps, fmt.Errorf(errPickleBadName, i, item[0])
+		}
+		point, ok := item[1].(ogorek.Tuple)
+		if !ok || len(point) != 2 {
+			return dps, fmt.Errorf(errPickleBadItem, i)
+		}
+		ts, err := pickleToFloat(point[0])
+		if err == nil && ts < 0 {
+			err = errNegativeTimestamp
+		}
+		if err != nil {
+			return dps, fmt.Errorf(errPickleBadTimestamp, i, err)
+		}
+		value, err := pickleToFloat(point[1])
+		if err != nil {
+			return dps, fmt.Errorf(errPickleBadValue, i, err)
+		}
+
+		dpTags := make(Tags, len(tags))
+		for k, v := range tags {
+			dpTags[k] = v
+		}
+		path, err := parseMetricPath(name)
+		if err != nil {
+			return dps, newValidationError(ErrorType(err), errBadMetricPath, name)
+		}
+		err = putGraphiteTagInTags(path, name, dpTags)
+		if err != nil {
+			return dps, err
+		}
+		dps = append(dps, Datapoint{
+			Name:      path,
+			Timestamp: uint64(ts),
+			Value:     value,
+			Tags:      dpTags,
+		})
+	}
+	return dps, nil
+}
+
+// checkPickleLengths walks the opcodes of the pickle msg and checks that the length-prefixed arguments
+// are within msg, so that a small frame can't make the decoder allocate gigabytes.
+// Everything else is left to the decoder: the walk stops at STOP, at an unknown opcode, or at a truncated argument
+func checkPickleLengths(msg []byte) error {
+	for pos := 0; pos < len(msg); {
+		op := msg[pos]
+		pos++
+		var lenSize int // size of the little endian length of the argument, if it has one
+		switch op {
+		case '.':
+			return nil
+		case 'U', 'C', '\x8a', '\x8c':
+			lenSize = 1
+		case 'T', 'X', 'B', '\x8b':
+			lenSize = 4
+		case '\x8d', '\x8e', '\x96':
+			lenSize = 8
+		case 'K', 'h', 'q', '\x80', '\x82':
+			pos++
+		case 'M', '\x83':
+			pos += 2
+		case 'J', 'j', 'r', '\x84':
+			pos += 4
+		case 'G', '\x95':
+			pos += 8
+		case 'F', 'I', 'L', 'P', 'S', 'V', 'g', 'p':
+			pos = skipPickleLines(msg, pos, 1)
+		case 'c', 'i':
+			pos = skipPickleLines(msg, pos, 2)
+		case '(', '0', '1', '2', 'N', 'Q', 'R', 'a', 'b', 'd', '}', 'e', 'l', ']', 'o', 's', 't', ')', 'u',
+			'\x81', '\x85', '\x86', '\x87', '\x88', '\x89', '\x93', '\x94':
+		default:
+			return nil
+		}
+		if lenSize == 0 {
+			continue
+		}
+		if pos+lenSize > len(msg) {
+			return nil
+		}
+		var length uint64
+		for i := lenSize - 1; i >= 0; i-- {
+			length = length<<8 | uint64(msg[pos+i])
+		}
+		pos += lenSize
+		if left := uint64(len(msg) - pos); length > left {
+			return fmt.Errorf(errPickleTooLong, op, pos-lenSize-1, length, left)
+		}
+		pos += int(length)
+	}
+	return nil
+}
+
+// skipPickleLines returns the position after the n newline terminated arguments starting at pos, or len(msg)
+func skipPickleLines(msg []byte, pos, n int) int {
+	for ; n > 0; n-- {
+		i := bytes.IndexByte(msg[pos:], '\n')
+		if i < 0 {
+			return len(msg)
+		}
+		pos += i + 1
+	}
+	return pos
+}
+
+// pickleToFloat converts the numeric types the python clients are known to send
+func pickleToFloat(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case int64:
+		return float64(n), nil
+	case *big.Int:
+		if !n.IsInt64() {
+			return 0, fmt.Errorf("%s overflows", n)
+		}
+		return float64(n.Int64()), nil
+	case string:
+		return strconv.ParseFloat(n, 64)
+	default:
+		return 0, errPickleUnexpectedType
+	}
+}
+
+// pickleDatapoints writes dps to w, in python talk: [(path, (timestamp, value)), ...]
+func pickleDatapoints(w *bytes.Buffer, dps []Datapoint) *bytes.Buffer {
+	list := make([]interface{}, len(dps))
+	for i, dp := range dps {
+		list[i] = ogorek.Tuple{dp.Name, ogorek.Tuple{dp.Timestamp, dp.Value}}
+	}
+	// encoding only fails on types we never hand over
+	ogorek.NewEncoder(w).Encode(list)
+	return w
+}
+
+// AppendPickleFrame appends to buf a length prefixed frame holding dps
+func AppendPickleFrame(buf []byte, dps []Datapoint) []byte {
+	payload := pickleDatapoints(&bytes.Buffer{}, dps)
+	var size [4]byte
+	binary.BigEndian.PutUint32(size[:], uint32(payload.Len()))
+	buf = append(buf, size[:]...)
+	return append(buf, payload.Bytes()...)
+}
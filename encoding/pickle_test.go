@@ -0,0 +1,91 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPickleRoundTrip(t *testing.T) {
+	h := NewPickle(false, 0)
+	in := []Datapoint{
+		{Name: "test.metric", Value: 10.5, Timestamp: 1000},
+		{Name: "test.other", Value: -3, Timestamp: 1001},
+	}
+	frame := AppendPickleFrame(nil, in)
+	assert.Equal(t, uint32(len(frame)-4), binary.BigEndian.Uint32(frame[:4]))
+
+	dps, err := h.LoadFrame(frame[4:], Tags{"appIpPortSrc": "127.0.0.1:1234"})
+	assert.NoError(t, err)
+	assert.Len(t, dps, 2)
+	for i, dp := range dps {
+		assert.Equal(t, in[i].Name, dp.Name)
+		assert.Equal(t, in[i].Value, dp.Value)
+		assert.Equal(t, in[i].Timestamp, dp.Timestamp)
+		assert.Equal(t, Tags{"appIpPortSrc": "127.0.0.1:1234"}, dp.Tags)
+	}
+	// each datapoint must own its tags
+	dps[0].Tags["foo"] = "bar"
+	assert.NotContains(t, dps[1].Tags, "foo")
+
+	dp, err := h.Load(h.Dump(in[0]), Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, Datapoint{Name: "test.metric", Value: 10.5, Timestamp: 1000, Tags: Tags{}}, dp)
+}
+
+func TestPickleGraphiteTags(t *testing.T) {
+	h := NewPickle(false, 0)
+	frame := AppendPickleFrame(nil, []Datapoint{{Name: "test.metric;app=woot;env=prod", Value: 1, Timestamp: 1}})
+	dps, err := h.LoadFrame(frame[4:], Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Datapoint{{Name: "test.metric", Value: 1, Timestamp: 1, Tags: Tags{"app": "woot", "env": "prod"}}}, dps)
+}
+
+func TestPickleInvalid(t *testing.T) {
+	h := NewPickle(false, 0)
+	payloads := map[string][]byte{
+		// cos\nsystem\n(S'echo hello'\ntR. : must never be interpreted
+		"global":      []byte("cos\nsystem\n(S'echo hello'\ntR."),
+		"notAList":    []byte("S'test.metric'\np0\n."),
+		"badItem":     []byte("(lp0\nS'test.metric'\np1\na."),
+		"badValue":    []byte("(lp0\n(S'test.metric'\np1\n(I1000\nS'abc'\np2\ntp3\ntp4\na."),
+		"negativeTs":  []byte("(lp0\n(S'test.metric'\np1\n(I-1\nF1.0\ntp2\ntp3\na."),
+		"truncated":   []byte("(lp0\n(S'test.metric'"),
+		"empty":       []byte(""),
+		"consecutive": AppendPickleFrame(nil, []Datapoint{{Name: "test..metric", Value: 1, Timestamp: 1}})[4:],
+		// lengths way beyond the frame, which the decoder would allocate
+		"binstringTooLong":  []byte("T\xff\xff\xff\x7f"),
+		"binunicodeTooLong": []byte("(lp0\n(X\x00\x00\x00\x40abc"),
+		"long1TooLong":      []byte("\x80\x02\x8a\xff\x01."),
+	}
+	for test, payload := range payloads {
+		t.Run(test, func(t *testing.T) {
+			_, err := h.LoadFrame(payload, Tags{})
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestPickleLengths(t *testing.T) {
+	// a valid frame, of every kind of item, passes
+	frame := AppendPickleFrame(nil, []Datapoint{{Name: "a.b", Value: 1.5, Timestamp: 10}, {Name: "c.d;dc=paris", Value: -2, Timestamp: 1 << 40}})[4:]
+	assert.NoError(t, checkPickleLengths(frame))
+	assert.NoError(t, checkPickleLengths([]byte("(lp0\n(S'test.metric'\np1\n(I1000\nF1.0\ntp2\ntp3\na.")))
+
+	err := checkPickleLengths([]byte("(lp0\n(T\xff\xff\xff\x7fabc"))
+	assert.EqualError(t, err, `pickle opcode 'T' at 6 has a length of 2147483647 bytes, but only 3 bytes are left in the frame`)
+	assert.Error(t, checkPickleLengths([]byte("\x8e\x00\x00\x00\x00\x00\x00\x00\x01")))
+}
+
+func TestPickleProtocol0(t *testing.T) {
+	h := NewPickle(false, 0)
+	// pickle.dumps([('test.metric', (1000, 10.5)), ('test.other', (1001L, '3'))], 0)
+	payload := []byte("(lp0\n(S'test.metric'\np1\n(I1000\nF10.5\ntp2\ntp3\na(S'test.other'\np4\n(L1001L\nS'3'\np5\ntp6\ntp7\na.")
+	dps, err := h.LoadFrame(payload, Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Datapoint{
+		{Name: "test.metric", Value: 10.5, Timestamp: 1000, Tags: Tags{}},
+		{Name: "test.other", Value: 3, Timestamp: 1001, Tags: Tags{}},
+	}, dps)
+}
@@ -36,7 +36,7 @@ func NewPlain(validate bool) PlainAdapter {
 func (p PlainAdapter) parseKey(firstPartDataPoint string) (string, error) {
 	metricPath, err := parseMetricPath(firstPartDataPoint)
 	if err != nil {
-		return "", fmt.Errorf(errBadMetricPath, firstPartDataPoint)
+		return "", newValidationError(ErrorType(err), errBadMetricPath, firstPartDataPoint)
 	}
 
 	return metricPath, nil
@@ -45,7 +45,7 @@ func putGraphiteTagInTags(metricPath string, firstPartDataPoint string, tags Tag
 	if len(metricPath) != len(firstPartDataPoint) {
 		err := addGraphiteTagToTags(firstPartDataPoint[len(metricPath)+1:], tags)
 		if err != nil {
-			return fmt.Errorf(errBadTags, firstPartDataPoint)
+			return newValidationError(ErrorTypeValidation, errBadTags, firstPartDataPoint)
 		}
 	}
 	return nil
@@ -56,13 +56,13 @@ func parseMetricPath(key string) (string, error) {
 	i := 0
 	for ; i < len(key) && key[i] != ';'; i++ {
 		if key[i] == 0 {
-			return "", fmt.Errorf(errFmtNullInKey, i)
+			return "", newValidationError(ErrorTypeValidation, errFmtNullInKey, i)
 		}
 		if key[i] > unicode.MaxASCII {
-			return "", fmt.Errorf(errFmtNotAscii, i)
+			return "", newValidationError(ErrorTypeNonASCII, errFmtNotAscii, i)
 		}
 		if key[i] == dotChar && key[i] == previousChar {
-			return "", fmt.Errorf(errTwoConsecutiveDot, i, i-1)
+			return "", newValidationError(ErrorTypeValidation, errTwoConsecutiveDot, i, i-1)
 		}
 		previousChar = key[i]
 	}
@@ -144,6 +144,14 @@ func (p PlainAdapter) load(msgbuf []byte, tags Tags) (Datapoint, error) {
 	if err != nil {
 		return d, err
 	}
+	if len(d.Name) != firstSpace-start {
+		// the tags of the message go to a copy: the input tags are shared by all its messages
+		msgTags := make(Tags, len(tags)+1)
+		for k, v := range tags {
+			msgTags[k] = v
+		}
+		tags = msgTags
+	}
 	err = putGraphiteTagInTags(d.Name, msg[start:firstSpace], tags)
 	if err != nil {
 		return d, err
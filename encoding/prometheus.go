@@ -0,0 +1,401 @@
+package encoding
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+var (
+	errPromUnclosedPlaceholder = "unclosed placeholder in path template %q"
+	errPromEmptyPlaceholder    = "empty placeholder in path template %q"
+	errPromWireType            = "unsupported protobuf wire type %d"
+	errPromVarint              = errors.New("malformed protobuf varint")
+	errPromNoName              = errors.New("time series without __name__ label")
+	errPromEmptyPath           = errors.New("path template rendered an empty metric path")
+)
+
+const PromWriteRequestFormat FormatName = "prom_write_request"
+
+// DefaultPromPathTemplate only uses the metric name as the graphite path
+const DefaultPromPathTemplate = "{__name__}"
+
+const promNameLabel = "__name__"
+
+// staleNaN is the NaN prometheus uses to mark a series as stale
+const staleNaN uint64 = 0x7ff0000000000002
+
+// protobuf field numbers and wire types of the remote write protocol
+// https://github.com/prometheus/prometheus/blob/master/prompb/remote.proto
+const (
+	promWriteRequestTimeseries = 1
+	promTimeseriesLabels       = 1
+	promTimeseriesSamples      = 2
+	promLabelName              = 1
+	promLabelValue             = 2
+	promSampleValue            = 1
+	promSampleTimestamp        = 2
+
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+// templatePart is either a literal string, or a label whose value is substituted
+type templatePart struct {
+	literal string
+	label   string
+}
+
+// PromWriteRequestAdapter decodes the (uncompressed) protobuf WriteRequest
+// of the prometheus remote write protocol.
+// Labels become tags, the path is rendered from a template such as
+// `prometheus.{job}.{__name__}` where every `{label}` is replaced by the
+// value of the label, nodes whose label is missing are left out.
+type PromWriteRequestAdapter struct {
+	template []templatePart
+}
+
+func NewPromWriteRequest(pathTemplate string) (PromWriteRequestAdapter, error) {
+	if pathTemplate == "" {
+		pathTemplate = DefaultPromPathTemplate
+	}
+	var parts []templatePart
+	rest := pathTemplate
+	for len(rest) > 0 {
+		start := strings.IndexByte(rest, '{')
+		if start == -1 {
+			parts = append(parts, templatePart{literal: rest})
+			break
+		}
+		end := strings.IndexByte(rest[start:], '}')
+		if end == -1 {
+			return PromWriteRequestAdapter{}, fmt.Errorf(errPromUnclosedPlaceholder, pathTemplate)
+		}
+		end += start
+		if end == start+1 {
+			return PromWriteRequestAdapter{}, fmt.Errorf(errPromEmptyPlaceholder, pathTemplate)
+		}
+		if start > 0 {
+			parts = append(parts, templatePart{literal: rest[:start]})
+		}
+		parts = append(parts, templatePart{label: rest[start+1 : end]})
+		rest = rest[end+1:]
+	}
+	return PromWriteRequestAdapter{template: parts}, nil
+}
+
+func (p PromWriteRequestAdapter) KindS() string {
+	return string(PromWriteRequestFormat)
+}
+
+func (p PromWriteRequestAdapter) Kind() FormatName {
+	return PromWriteRequestFormat
+}
+
+// MaxFrameSize is unbounded, the size of requests is checked by the input
+func (p PromWriteRequestAdapter) MaxFrameSize() uint32 {
+	return math.MaxUint32
+}
+
+// Dump returns a WriteRequest holding a single sample, tags are sent as labels
+func (p PromWriteRequestAdapter) Dump(dp Datapoint) []byte {
+	keys := make([]string, 0, len(dp.Tags))
+	for k := range dp.Tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ts []byte
+	ts = appendLabel(ts, promNameLabel, dp.Name)
+	for _, k := range keys {
+		ts = appendLabel(ts, k, dp.Tags[k])
+	}
+	var sample []byte
+	sample = appendVarint(sample, promSampleValue<<3|wireFixed64)
+	var value [8]byte
+	binary.LittleEndian.PutUint64(value[:], math.Float64bits(dp.Value))
+	sample = append(sample, value[:]...)
+	sample = appendVarint(sample, promSampleTimestamp<<3|wireVarint)
+	sample = appendVarint(sample, dp.Timestamp*1000)
+	ts = appendBytesField(ts, promTimeseriesSamples, sample)
+
+	return appendBytesField(nil, promWriteRequestTimeseries, ts)
+}
+
+func appendLabel(buf []byte, name, value string) []byte {
+	var label []byte
+	label = appendBytesField(label, promLabelName, []byte(name))
+	label = appendBytesField(label, promLabelValue, []byte(value))
+	return appendBytesField(buf, promTimeseriesLabels, label)
+}
+
+func appendVarint(buf []byte, v uint64) []byte {
+	var tmp [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(tmp[:], v)
+	return append(buf, tmp[:n]...)
+}
+
+func appendBytesField(buf []byte, field uint64, value []byte) []byte {
+	buf = appendVarint(buf, field<<3|wireBytes)
+	buf = appendVarint(buf, uint64(len(value)))
+	return append(buf, value...)
+}
+
+// Load decodes a WriteRequest which must contain exactly one sample.
+// Use LoadFrame for requests carrying several samples
+func (p PromWriteRequestAdapter) Load(msg []byte, tags Tags) (Datapoint, error) {
+	dps, err := p.LoadFrame(msg, tags)
+	if err != nil {
+		return Datapoint{}, err
+	}
+	if len(dps) != 1 {
+		return Datapoint{}, fmt.Errorf("expected a single sample in write request, got %d", len(dps))
+	}
+	return dps[0], nil
+}
+
+// LoadFrame decodes a WriteRequest, every sample becomes a datapoint.
+// staleness markers are skipped as graphite has no use for them
+func (p PromWriteRequestAdapter) LoadFrame(msg []byte, tags Tags) ([]Datapoint, error) {
+	if tags == nil {
+		return nil, fmt.Errorf(errNoTags)
+	}
+	if len(msg) == 0 {
+		return nil, errEmptyline
+	}
+	var dps []Datapoint
+	err := walkFields(msg, func(key uint64, r *protoReader) (bool, error) {
+		if key != promWriteRequestTimeseries<<3|wireBytes {
+			return false, nil
+		}
+		raw, err := r.bytes()
+		if err != nil {
+			return true, err
+		}
+		dps, err = p.loadTimeseries(raw, tags, dps)
+		return true, err
+	})
+	return dps, err
+}
+
+func (p PromWriteRequestAdapter) loadTimeseries(msg []byte, tags Tags, dps []Datapoint) ([]Datapoint, error) {
+	labels := make(Tags, len(tags)+4)
+	for k, v := range tags {
+		labels[k] = v
+	}
+	var samples [][]byte
+	err := walkFields(msg, func(key uint64, r *protoReader) (bool, error) {
+		switch key {
+		case promTimeseriesLabels<<3 | wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			var name, value []byte
+			err = walkFields(raw, func(key uint64, r *protoReader) (bool, error) {
+				var err error
+				switch key {
+				case promLabelName<<3 | wireBytes:
+					name, err = r.bytes()
+				case promLabelValue<<3 | wireBytes:
+					value, err = r.bytes()
+				default:
+					return false, nil
+				}
+				return true, err
+			})
+			if err != nil {
+				return true, err
+			}
+			labels[string(name)] = string(value)
+		case promTimeseriesSamples<<3 | wireBytes:
+			raw, err := r.bytes()
+			if err != nil {
+				return true, err
+			}
+			samples = append(samples, raw)
+		default:
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return dps, err
+	}
+	if _, ok := labels[promNameLabel]; !ok {
+		return dps, errPromNoName
+	}
+	path, err := p.renderPath(labels)
+	if err != nil {
+		return dps, err
+	}
+	delete(labels, promNameLabel)
+
+	first := true
+	for _, raw := range samples {
+		var value, ts uint64
+		err := walkFields(raw, func(key uint64, r *protoReader) (bool, error) {
+			var err error
+			switch key {
+			case promSampleValue<<3 | wireFixed64:
+				value, err = r.fixed64()
+			case promSampleTimestamp<<3 | wireVarint:
+				ts, err = r.varint()
+			default:
+				return false, nil
+			}
+			return true, err
+		})
+		if err != nil {
+			return dps, err
+		}
+		if value == staleNaN {
+			continue
+		}
+		if int64(ts) < 0 {
+			return dps, errNegativeTimestamp
+		}
+		dpTags := labels
+		if !first {
+			dpTags = make(Tags, len(labels))
+			for k, v := range labels {
+				dpTags[k] = v
+			}
+		}
+		first = false
+		dps = append(dps, Datapoint{
+			Name:      path,
+			Timestamp: ts / 1000,
+			Value:     math.Float64frombits(value),
+			Tags:      dpTags,
+		})
+	}
+	return dps, nil
+}
+
+func (p PromWriteRequestAdapter) renderPath(labels Tags) (string, error) {
+	var nodes []string
+	for _, part := range p.template {
+		if part.label == "" {
+			nodes = append(nodes, part.literal)
+			continue
+		}
+		if v, ok := labels[part.label]; ok && v != "" {
+			nodes = append(nodes, sanitizeNode(v))
+			continue
+		}
+		// leave out the node of a missing label, along with its separator
+		if l := len(nodes); l > 0 && strings.HasSuffix(nodes[l-1], ".") {
+			nodes[l-1] = strings.TrimSuffix(nodes[l-1], ".")
+		}
+	}
+	path := strings.Trim(strings.Join(nodes, ""), ".")
+	if path == "" {
+		return "", errPromEmptyPath
+	}
+	metricPath, err := parseMetricPath(path)
+	if err != nil || metricPath != path {
+		return "", newValidationError(ErrorTypeValidation, errBadMetricPath, path)
+	}
+	return path, nil
+}
+
+// sanitizeNode makes a label value usable as a single graphite node
+func sanitizeNode(v string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+			return r
+		case r == '_' || r == '-' || r == ':':
+			return r
+		}
+		return '_'
+	}, v)
+}
+
+// protoReader reads the few protobuf wire types the remote write protocol uses
+type protoReader struct {
+	buf []byte
+}
+
+func (r *protoReader) varint() (uint64, error) {
+	v, n := binary.Uvarint(r.buf)
+	if n <= 0 {
+		return 0, errPromVarint
+	}
+	r.buf = r.buf[n:]
+	return v, nil
+}
+
+func (r *protoReader) fixed64() (uint64, error) {
+	if len(r.buf) < 8 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := binary.LittleEndian.Uint64(r.buf)
+	r.buf = r.buf[8:]
+	return v, nil
+}
+
+func (r *protoReader) fixed32() (uint32, error) {
+	if len(r.buf) < 4 {
+		return 0, io.ErrUnexpectedEOF
+	}
+	v := binary.LittleEndian.Uint32(r.buf)
+	r.buf = r.buf[4:]
+	return v, nil
+}
+
+// bytes returns a length delimited value, sharing the memory of the message
+func (r *protoReader) bytes() ([]byte, error) {
+	l, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if l > uint64(len(r.buf)) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	v := r.buf[:l]
+	r.buf = r.buf[l:]
+	return v, nil
+}
+
+// walkFields calls fn with the key (field number and wire type) of every field of a
+// protobuf message. fn reports whether it consumed the value, the others are skipped
+func walkFields(msg []byte, fn func(key uint64, r *protoReader) (bool, error)) error {
+	r := &protoReader{buf: msg}
+	for len(r.buf) > 0 {
+		key, err := r.varint()
+		if err != nil {
+			return err
+		}
+		done, err := fn(key, r)
+		if err != nil {
+			return err
+		}
+		if done {
+			continue
+		}
+		switch wire := key & 7; wire {
+		case wireVarint:
+			_, err = r.varint()
+		case wireFixed64:
+			_, err = r.fixed64()
+		case wireBytes:
+			_, err = r.bytes()
+		case wireFixed32:
+			_, err = r.fixed32()
+		default:
+			err = fmt.Errorf(errPromWireType, wire)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
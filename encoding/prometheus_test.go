@@ -0,0 +1,88 @@
+package encoding
+
+import (
+	"math"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromWriteRequestRoundTrip(t *testing.T) {
+	h, err := NewPromWriteRequest("")
+	assert.NoError(t, err)
+	in := Datapoint{Name: "node_load1", Value: 1.5, Timestamp: 1000, Tags: Tags{"job": "node", "instance": "10.0.0.1:9100"}}
+	dp, err := h.Load(h.Dump(in), Tags{"appIpPortSrc": "127.0.0.1:1234"})
+	assert.NoError(t, err)
+	assert.Equal(t, Datapoint{
+		Name:      "node_load1",
+		Value:     1.5,
+		Timestamp: 1000,
+		Tags:      Tags{"job": "node", "instance": "10.0.0.1:9100", "appIpPortSrc": "127.0.0.1:1234"},
+	}, dp)
+}
+
+func TestPromWriteRequestPathTemplate(t *testing.T) {
+	cases := []struct {
+		template string
+		tags     Tags
+		path     string
+	}{
+		{"{__name__}", Tags{"job": "node"}, "up"},
+		{"prometheus.{job}.{instance}.{__name__}", Tags{"job": "node", "instance": "10.0.0.1:9100"}, "prometheus.node.10_0_0_1:9100.up"},
+		{"prometheus.{job}.{instance}.{__name__}", Tags{"instance": "host"}, "prometheus.host.up"},
+		{"{job}.{__name__}", Tags{}, "up"},
+		{"{__name__}.{job}", Tags{}, "up"},
+	}
+	for _, c := range cases {
+		t.Run(c.template, func(t *testing.T) {
+			h, err := NewPromWriteRequest(c.template)
+			assert.NoError(t, err)
+			dp, err := h.Load(h.Dump(Datapoint{Name: "up", Value: 1, Timestamp: 1, Tags: c.tags}), Tags{})
+			assert.NoError(t, err)
+			assert.Equal(t, c.path, dp.Name)
+		})
+	}
+}
+
+func TestPromWriteRequestInvalidTemplate(t *testing.T) {
+	for _, template := range []string{"prometheus.{job", "prometheus.{}.{__name__}"} {
+		_, err := NewPromWriteRequest(template)
+		assert.Error(t, err, template)
+	}
+	h, err := NewPromWriteRequest("prometheus..{__name__}")
+	assert.NoError(t, err)
+	_, err = h.Load(h.Dump(Datapoint{Name: "up", Value: 1, Timestamp: 1}), Tags{})
+	assert.Error(t, err)
+}
+
+func TestPromWriteRequestMultipleSeries(t *testing.T) {
+	h, _ := NewPromWriteRequest("")
+	msg := h.Dump(Datapoint{Name: "a", Value: 1, Timestamp: 10, Tags: Tags{"k": "v"}})
+	msg = append(msg, h.Dump(Datapoint{Name: "b", Value: 2, Timestamp: 20})...)
+	stale := h.Dump(Datapoint{Name: "c", Value: math.Float64frombits(staleNaN), Timestamp: 30})
+	msg = append(msg, stale...)
+
+	dps, err := h.LoadFrame(msg, Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, []Datapoint{
+		{Name: "a", Value: 1, Timestamp: 10, Tags: Tags{"k": "v"}},
+		{Name: "b", Value: 2, Timestamp: 20, Tags: Tags{}},
+	}, dps)
+}
+
+func TestPromWriteRequestInvalid(t *testing.T) {
+	h, _ := NewPromWriteRequest("")
+	valid := h.Dump(Datapoint{Name: "a", Value: 1, Timestamp: 10})
+	payloads := map[string][]byte{
+		"empty":     {},
+		"truncated": valid[:len(valid)-3],
+		"noName":    appendBytesField(nil, promWriteRequestTimeseries, appendLabel(nil, "job", "node")),
+		"wireType":  {promWriteRequestTimeseries<<3 | 7},
+	}
+	for test, payload := range payloads {
+		t.Run(test, func(t *testing.T) {
+			_, err := h.LoadFrame(payload, Tags{})
+			assert.Error(t, err)
+		})
+	}
+}
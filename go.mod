@@ -15,6 +15,7 @@ require (
 	github.com/elazarl/go-bindata-assetfs v1.0.0
 	github.com/frankban/quicktest v1.4.1 // indirect
 	github.com/gocql/gocql v0.0.0-20191018090344-07ace3bab0f8
+	github.com/golang/snappy v0.0.1
 	github.com/google/go-cmp v0.3.1
 	github.com/google/pprof v0.0.0-20191105193234-27840fff0d09 // indirect
 	github.com/google/uuid v1.1.1
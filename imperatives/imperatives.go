@@ -23,6 +23,8 @@ const (
 	addRouteSendAllMatch
 	addRouteSendFirstMatch
 	addRouteConsistentHashing
+	addRouteLoadBalance
+	addRouteFailover
 	addRouteGrafanaNet
 	addRouteKafkaMdm
 	addRoutePubSub
@@ -34,11 +36,17 @@ const (
 	str
 	sep
 	avgFn
+	countFn
+	countDistinctFn
 	deltaFn
 	deriveFn
+	firstFn
 	lastFn
 	maxFn
+	medianFn
 	minFn
+	rangeFn
+	rateFn
 	stdevFn
 	sumFn
 	num
@@ -46,9 +54,27 @@ const (
 	optAddr
 	optCache
 	optDropRaw
+	optGroupBy
+	optOutTags
+	optSketchAccuracy
+	optStateDir
+	optCheckpointInterval
+	optResolutions
+	optStorageSchemas
+	optStorageAggregation
+	optLatePolicy
+	optLateRoute
+	optMaxSeries
+	optOverflowPolicy
+	optWatermark
+	optIdleTimeout
 	optBlocking
+	optStrategy
+	optDownPeriod
+	optUpPeriod
 	optSub
 	optRegex
+	optTag
 	optFlush
 	optReconn
 	optConnBufSize
@@ -60,6 +86,16 @@ const (
 	optSpoolSleep
 	optUnspoolSleep
 	optPickle
+	optInternal
+	optTags
+	optTagsAllow
+	optTagsDeny
+	optTLS
+	optTLSCA
+	optTLSCert
+	optTLSKey
+	optTLSServerName
+	optTLSMinVersion
 	optSpool
 	optTrue
 	optFalse
@@ -87,6 +123,8 @@ var tokens = []toki.Def{
 	{Token: addRouteSendAllMatch, Pattern: "addRoute sendAllMatch"},
 	{Token: addRouteSendFirstMatch, Pattern: "addRoute sendFirstMatch"},
 	{Token: addRouteConsistentHashing, Pattern: "addRoute consistentHashing"},
+	{Token: addRouteLoadBalance, Pattern: "addRoute loadBalance"},
+	{Token: addRouteFailover, Pattern: "addRoute failover"},
 	{Token: addRouteGrafanaNet, Pattern: "addRoute grafanaNet"},
 	{Token: addRouteKafkaMdm, Pattern: "addRoute kafkaMdm"},
 	{Token: addRoutePubSub, Pattern: "addRoute pubsub"},
@@ -99,9 +137,27 @@ var tokens = []toki.Def{
 	{Token: optAddr, Pattern: "addr="},
 	{Token: optCache, Pattern: "cache="},
 	{Token: optDropRaw, Pattern: "dropRaw="},
+	{Token: optGroupBy, Pattern: "groupBy="},
+	{Token: optOutTags, Pattern: "outTags="},
+	{Token: optSketchAccuracy, Pattern: "sketchAccuracy="},
+	{Token: optStateDir, Pattern: "stateDir="},
+	{Token: optCheckpointInterval, Pattern: "checkpointInterval="},
+	{Token: optResolutions, Pattern: "resolutions="},
+	{Token: optStorageSchemas, Pattern: "storageSchemas="},
+	{Token: optStorageAggregation, Pattern: "storageAggregation="},
+	{Token: optLatePolicy, Pattern: "latePolicy="},
+	{Token: optLateRoute, Pattern: "lateRoute="},
+	{Token: optMaxSeries, Pattern: "maxSeries="},
+	{Token: optOverflowPolicy, Pattern: "overflowPolicy="},
+	{Token: optWatermark, Pattern: "watermark="},
+	{Token: optIdleTimeout, Pattern: "idleTimeout="},
 	{Token: optBlocking, Pattern: "blocking="},
+	{Token: optStrategy, Pattern: "strategy="},
+	{Token: optDownPeriod, Pattern: "downPeriod="},
+	{Token: optUpPeriod, Pattern: "upPeriod="},
 	{Token: optSub, Pattern: "sub="},
 	{Token: optRegex, Pattern: "regex="},
+	{Token: optTag, Pattern: "tag="},
 	{Token: optFlush, Pattern: "flush="},
 	{Token: optReconn, Pattern: "reconn="},
 	{Token: optConnBufSize, Pattern: "connbuf="},
@@ -113,6 +169,16 @@ var tokens = []toki.Def{
 	{Token: optSpoolSleep, Pattern: "spoolsleep="},
 	{Token: optUnspoolSleep, Pattern: "unspoolsleep="},
 	{Token: optPickle, Pattern: "pickle="},
+	{Token: optInternal, Pattern: "internal="},
+	{Token: optTags, Pattern: "tags="},
+	{Token: optTagsAllow, Pattern: "tagsallow="},
+	{Token: optTagsDeny, Pattern: "tagsdeny="},
+	{Token: optTLS, Pattern: "tls="},
+	{Token: optTLSCA, Pattern: "tlsca="},
+	{Token: optTLSCert, Pattern: "tlscert="},
+	{Token: optTLSKey, Pattern: "tlskey="},
+	{Token: optTLSServerName, Pattern: "tlsservername="},
+	{Token: optTLSMinVersion, Pattern: "tlsminversion="},
 	{Token: optSpool, Pattern: "spool="},
 	{Token: optTrue, Pattern: "true"},
 	{Token: optFalse, Pattern: "false"},
@@ -138,22 +204,28 @@ var tokens = []toki.Def{
 	{Token: deltaFn, Pattern: "delta "},
 	{Token: deriveFn, Pattern: "derive "},
 	{Token: stdevFn, Pattern: "stdev "},
+	{Token: countFn, Pattern: "count "},
+	{Token: countDistinctFn, Pattern: "countDistinct "},
+	{Token: firstFn, Pattern: "first "},
+	{Token: medianFn, Pattern: "median "},
+	{Token: rangeFn, Pattern: "range "},
+	{Token: rateFn, Pattern: "rate "},
 	{Token: num, Pattern: "[0-9]+( |$)"}, // unfortunately we need the 2nd piece cause otherwise it would match the first of ip addresses etc. this means we need to TrimSpace later
 	{Token: word, Pattern: "[^ ]+"},
 }
 
 // note the two spaces between a route and endpoints
 // match options can't have spaces for now. sorry
-var errFmtAddBlack = errors.New("addBlack <prefix|sub|regex> <pattern>")
-var errFmtAddAgg = errors.New("addAgg <avg|delta|derive|last|max|min|stdev|sum> [prefix/sub/regex=,..] <fmt> <interval> <wait> [cache=true/false] [dropRaw=true/false]")
-var errFmtAddRoute = errors.New("addRoute <type> <key> [prefix/sub/regex=,..]  <dest>  [<dest>[...]] where <dest> is <addr> [prefix/sub,regex,flush,reconn,pickle,spool=...]") // note flush and reconn are ints, pickle and spool are true/false. other options are strings
+var errFmtAddBlack = errors.New("addBlack <prefix|sub|regex|tag> <pattern>")
+var errFmtAddAgg = errors.New("addAgg <avg|count|countDistinct|delta|derive|first|last|max|median|min|range|rate|stdev|sum|percentiles|p<N>>[,...]|rollup [prefix/sub/regex/tag=,..] <fmt> <interval> <wait> [cache=true/false] [dropRaw=true/false] [groupBy=<tag>,..] [outTags=<tag>=<value>,..] [sketchAccuracy=<float>] [stateDir=<dir>] [checkpointInterval=<seconds>] [resolutions=<interval>[/<step>][:<suffix>],..] [storageSchemas=<file> storageAggregation=<file>] [latePolicy=drop/forward/reemit/route] [lateRoute=<routeKey>] [maxSeries=<int>] [overflowPolicy=drop/aggregate] [watermark=true/false] [idleTimeout=<seconds>]")
+var errFmtAddRoute = errors.New("addRoute <type> <key> [strategy=roundRobin/leastQueued] [downPeriod=<seconds> upPeriod=<seconds>] [prefix/sub/regex/tag=,..]  <dest>  [<dest>[...]] where <dest> is <addr> [prefix/sub,regex,tag,flush,reconn,pickle,internal,tags,tagsallow,tagsdeny,tls,tlsca,tlscert,tlskey,tlsservername,tlsminversion,spool=...]") // note flush and reconn are ints, pickle, internal, tags, tls and spool are true/false. tagsallow and tagsdeny are comma separated lists. other options are strings
 var errFmtAddRouteGrafanaNet = errors.New("addRoute grafanaNet key [prefix/sub/regex=,...]  addr apiKey schemasFile [spool=true/false sslverify=true/false blocking=true/false bufSize=int flushMaxNum=int flushMaxWait=int timeout=int concurrency=int orgId=int]")
 var errFmtAddRouteKafkaMdm = errors.New("addRoute kafkaMdm key [prefix/sub/regex=,...]  broker topic codec schemasFile partitionBy orgId [blocking=true/false bufSize=int flushMaxNum=int flushMaxWait=int timeout=int]")
 var errFmtAddRoutePubSub = errors.New("addRoute pubsub key [prefix/sub/regex=,...]  project topic [codec=gzip/none format=plain/pickle blocking=true/false bufSize=int flushMaxSize=int flushMaxWait=int]")
 var errFmtAddDest = errors.New("addDest <routeKey> <dest>") // not implemented yet
 var errFmtAddRewriter = errors.New("addRewriter <old> <new> <max>")
-var errFmtModDest = errors.New("modDest <routeKey> <dest> <addr/prefix/sub/regex=>") // one or more can be specified at once
-var errFmtModRoute = errors.New("modRoute <routeKey> <prefix/sub/regex=>")           // one or more can be specified at once
+var errFmtModDest = errors.New("modDest <routeKey> <dest> <addr/prefix/sub/regex/tag=>") // one or more can be specified at once
+var errFmtModRoute = errors.New("modRoute <routeKey> <prefix/sub/regex/tag=>")           // one or more can be specified at once
 var errOrgId0 = errors.New("orgId must be a number > 0")
 
 type Table interface {
@@ -165,6 +237,7 @@ type Table interface {
 	UpdateDestination(key string, index int, opts map[string]string) error
 	UpdateRoute(key string, opts map[string]string) error
 	GetIn() chan encoding.Datapoint
+	GetLateIn() chan aggregator.LatePoint
 	GetSpoolDir() string
 }
 
@@ -185,6 +258,10 @@ func Apply(table Table, cmd string) error {
 		return readAddRoute(s, table, route.NewSendFirstMatch)
 	case addRouteConsistentHashing:
 		return readAddRouteConsistentHashing(s, table)
+	case addRouteLoadBalance:
+		return readAddRouteLoadBalance(s, table)
+	case addRouteFailover:
+		return readAddRouteFailover(s, table)
 	case addRewriter:
 		return readAddRewriter(s, table)
 	case delRoute:
@@ -200,14 +277,21 @@ func Apply(table Table, cmd string) error {
 
 func readAddAgg(s *toki.Scanner, table Table) error {
 	t := s.Next()
-	if t.Token != sumFn && t.Token != avgFn && t.Token != minFn && t.Token != maxFn && t.Token != lastFn && t.Token != deltaFn && t.Token != deriveFn && t.Token != stdevFn {
-		return errors.New("invalid function. need avg/max/min/sum/last/delta/derive/stdev")
+	var fun string
+	switch t.Token {
+	case sumFn, avgFn, minFn, maxFn, lastFn, deltaFn, deriveFn, stdevFn, countFn, countDistinctFn, firstFn, medianFn, rangeFn, rateFn:
+		fun = string(t.Value[:len(t.Value)-1]) // strip trailing space
+	case word:
+		// comma separated list of functions, validated by the aggregator
+		fun = string(t.Value)
+	default:
+		return errors.New("invalid function. need avg/count/countDistinct/delta/derive/first/last/max/median/min/range/rate/stdev/sum/percentiles/p<N>, or a comma separated list of them, or rollup")
 	}
-	fun := string(t.Value[:len(t.Value)-1]) // strip trailing space
 
 	regex := ""
 	prefix := ""
 	sub := ""
+	tag := ""
 
 	t = s.Next()
 	// handle old syntax of a raw regex
@@ -215,7 +299,7 @@ func readAddAgg(s *toki.Scanner, table Table) error {
 		regex = string(t.Value)
 		t = s.Next()
 	}
-	// scan for prefix/sub/regex=, stop when we hit a bare word (outFmt)
+	// scan for prefix/sub/regex/tag=, stop when we hit a bare word (outFmt)
 	for ; t.Token != toki.EOF && t.Token != word; t = s.Next() {
 		switch t.Token {
 		case optPrefix:
@@ -233,6 +317,11 @@ func readAddAgg(s *toki.Scanner, table Table) error {
 				return errFmtAddAgg
 			}
 			regex = string(t.Value)
+		case optTag:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			tag = string(t.Value)
 		default:
 			return fmt.Errorf("unexpected token %d %q", t.Token, t.Value)
 		}
@@ -265,6 +354,20 @@ func readAddAgg(s *toki.Scanner, table Table) error {
 
 	cache := true
 	dropRaw := false
+	var groupBy []string
+	var outTags encoding.Tags
+	sketchAccuracy := 0.0
+	stateDir := ""
+	checkpointInterval := 0
+	var resolutions []string
+	storageSchemas := ""
+	storageAggregation := ""
+	latePolicy := ""
+	lateRoute := ""
+	maxSeries := 0
+	overflowPolicy := ""
+	watermark := false
+	idleTimeout := 0
 
 	t = s.Next()
 	for ; t.Token != toki.EOF; t = s.Next() {
@@ -289,12 +392,131 @@ func readAddAgg(s *toki.Scanner, table Table) error {
 			} else {
 				return errFmtAddAgg
 			}
+		case optGroupBy:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			groupBy = strings.Split(string(t.Value), ",")
+		case optOutTags:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			outTags = make(encoding.Tags)
+			for _, pair := range strings.Split(string(t.Value), ",") {
+				kv := strings.SplitN(pair, "=", 2)
+				if len(kv) != 2 || kv[0] == "" {
+					return errFmtAddAgg
+				}
+				outTags[kv[0]] = kv[1]
+			}
+		case optSketchAccuracy:
+			if t = s.Next(); t.Token != word && t.Token != num {
+				return errFmtAddAgg
+			}
+			sketchAccuracy, err = strconv.ParseFloat(strings.TrimSpace(string(t.Value)), 64)
+			if err != nil {
+				return err
+			}
+		case optStateDir:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			stateDir = string(t.Value)
+		case optCheckpointInterval:
+			if t = s.Next(); t.Token != num {
+				return errFmtAddAgg
+			}
+			checkpointInterval, err = strconv.Atoi(strings.TrimSpace(string(t.Value)))
+			if err != nil {
+				return err
+			}
+		case optResolutions:
+			if t = s.Next(); t.Token != word && t.Token != num {
+				return errFmtAddAgg
+			}
+			resolutions = strings.Split(strings.TrimSpace(string(t.Value)), ",")
+		case optStorageSchemas:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			storageSchemas = string(t.Value)
+		case optStorageAggregation:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			storageAggregation = string(t.Value)
+		case optLatePolicy:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			latePolicy = string(t.Value)
+		case optLateRoute:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			lateRoute = string(t.Value)
+		case optMaxSeries:
+			if t = s.Next(); t.Token != num {
+				return errFmtAddAgg
+			}
+			maxSeries, err = strconv.Atoi(strings.TrimSpace(string(t.Value)))
+			if err != nil {
+				return err
+			}
+		case optOverflowPolicy:
+			if t = s.Next(); t.Token != word {
+				return errFmtAddAgg
+			}
+			overflowPolicy = string(t.Value)
+		case optWatermark:
+			t = s.Next()
+			if t.Token == optTrue || t.Token == optFalse {
+				watermark, err = strconv.ParseBool(string(t.Value))
+				if err != nil {
+					return err
+				}
+			} else {
+				return errFmtAddAgg
+			}
+		case optIdleTimeout:
+			if t = s.Next(); t.Token != num {
+				return errFmtAddAgg
+			}
+			idleTimeout, err = strconv.Atoi(strings.TrimSpace(string(t.Value)))
+			if err != nil {
+				return err
+			}
 		default:
 			return fmt.Errorf("unexpected token %d %q", t.Token, t.Value)
 		}
 	}
 
-	agg, err := aggregator.New(fun, regex, prefix, sub, outFmt, cache, uint(interval), uint(wait), dropRaw, table.GetIn())
+	agg, err := aggregator.New(aggregator.Options{
+		Fun:                fun,
+		Regex:              regex,
+		Prefix:             prefix,
+		Sub:                sub,
+		Tag:                tag,
+		OutFmt:             outFmt,
+		Cache:              cache,
+		Interval:           uint(interval),
+		Wait:               uint(wait),
+		DropRaw:            dropRaw,
+		GroupBy:            groupBy,
+		OutTags:            outTags,
+		SketchAccuracy:     sketchAccuracy,
+		StateDir:           stateDir,
+		CheckpointInterval: uint(checkpointInterval),
+		Resolutions:        resolutions,
+		StorageSchemas:     storageSchemas,
+		StorageAggregation: storageAggregation,
+		LatePolicy:         latePolicy,
+		LateRoute:          lateRoute,
+		MaxSeries:          uint(maxSeries),
+		OverflowPolicy:     overflowPolicy,
+		Watermark:          watermark,
+		IdleTimeout:        uint(idleTimeout),
+	}, table.GetIn(), table.GetLateIn())
 	if err != nil {
 		return err
 	}
@@ -307,6 +529,7 @@ func readAddBlack(s *toki.Scanner, table Table) error {
 	prefix := ""
 	sub := ""
 	regex := ""
+	tag := ""
 	t := s.Next()
 	if t.Token != word {
 		return errFmtAddBlack
@@ -328,11 +551,16 @@ func readAddBlack(s *toki.Scanner, table Table) error {
 			return errFmtAddBlack
 		}
 		regex = string(t.Value)
+	case "tag":
+		if t = s.Next(); t.Token != word {
+			return errFmtAddBlack
+		}
+		tag = string(t.Value)
 	default:
 		return errFmtAddBlack
 	}
 
-	m, err := matcher.New(prefix, sub, regex)
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return err
 	}
@@ -340,14 +568,14 @@ func readAddBlack(s *toki.Scanner, table Table) error {
 	return nil
 }
 
-func readAddRoute(s *toki.Scanner, table Table, constructor func(key, prefix, sub, regex string, destinations []*destination.Destination) (route.Route, error)) error {
+func readAddRoute(s *toki.Scanner, table Table, constructor func(key, prefix, sub, regex, tag string, destinations []*destination.Destination) (route.Route, error)) error {
 	t := s.Next()
 	if t.Token != word {
 		return errFmtAddRoute
 	}
 	key := string(t.Value)
 
-	prefix, sub, regex, err := readRouteOpts(s)
+	prefix, sub, regex, tag, err := readRouteOpts(s)
 	if err != nil {
 		return err
 	}
@@ -360,7 +588,7 @@ func readAddRoute(s *toki.Scanner, table Table, constructor func(key, prefix, su
 		return fmt.Errorf("must get at least 1 destination for route '%s'", key)
 	}
 
-	route, err := constructor(key, prefix, sub, regex, destinations)
+	route, err := constructor(key, prefix, sub, regex, tag, destinations)
 	if err != nil {
 		return err
 	}
@@ -375,7 +603,7 @@ func readAddRouteConsistentHashing(s *toki.Scanner, table Table) error {
 	}
 	key := string(t.Value)
 
-	prefix, sub, regex, err := readRouteOpts(s)
+	prefix, sub, regex, tag, err := readRouteOpts(s)
 	if err != nil {
 		return err
 	}
@@ -388,7 +616,92 @@ func readAddRouteConsistentHashing(s *toki.Scanner, table Table) error {
 		return fmt.Errorf("must get at least 2 destination for route '%s'", key)
 	}
 
-	route, err := route.NewConsistentHashing(key, prefix, sub, regex, destinations, nil)
+	route, err := route.NewConsistentHashing(key, prefix, sub, regex, tag, destinations, nil)
+	if err != nil {
+		return err
+	}
+	table.AddRoute(route)
+	return nil
+}
+
+// readAddRouteLoadBalance reads a loadBalance route, which takes its strategy option before the other route options
+func readAddRouteLoadBalance(s *toki.Scanner, table Table) error {
+	t := s.Next()
+	if t.Token != word {
+		return errFmtAddRoute
+	}
+	key := string(t.Value)
+
+	var strategy string
+	if t = s.Peek(); t.Token == optStrategy {
+		s.Next()
+		if t = s.Next(); t.Token != word {
+			return errors.New("bad strategy option")
+		}
+		strategy = string(t.Value)
+	}
+
+	prefix, sub, regex, tag, err := readRouteOpts(s)
+	if err != nil {
+		return err
+	}
+
+	destinations, err := readDestinations(s, table, true, key)
+	if err != nil {
+		return err
+	}
+	if len(destinations) == 0 {
+		return fmt.Errorf("must get at least 1 destination for route '%s'", key)
+	}
+
+	route, err := route.NewLoadBalance(key, prefix, sub, regex, tag, strategy, destinations)
+	if err != nil {
+		return err
+	}
+	table.AddRoute(route)
+	return nil
+}
+
+// readAddRouteFailover reads a failover route, which takes its period options before the other route options
+func readAddRouteFailover(s *toki.Scanner, table Table) error {
+	t := s.Next()
+	if t.Token != word {
+		return errFmtAddRoute
+	}
+	key := string(t.Value)
+
+	var downPeriod, upPeriod int
+	for t = s.Peek(); t.Token == optDownPeriod || t.Token == optUpPeriod; t = s.Peek() {
+		s.Next()
+		opt := t.Token
+		if t = s.Next(); t.Token != num {
+			return errFmtAddRoute
+		}
+		period, err := strconv.Atoi(strings.TrimSpace(string(t.Value)))
+		if err != nil {
+			return err
+		}
+		if opt == optDownPeriod {
+			downPeriod = period
+		} else {
+			upPeriod = period
+		}
+	}
+
+	prefix, sub, regex, tag, err := readRouteOpts(s)
+	if err != nil {
+		return err
+	}
+
+	destinations, err := readDestinations(s, table, true, key)
+	if err != nil {
+		return err
+	}
+	if len(destinations) == 0 {
+		return fmt.Errorf("must get at least 1 destination for route '%s'", key)
+	}
+
+	route, err := route.NewFailover(key, prefix, sub, regex, tag, time.Duration(downPeriod)*time.Second, time.Duration(upPeriod)*time.Second, destinations)
 	if err != nil {
 		return err
 	}
@@ -476,6 +789,11 @@ func readModDest(s *toki.Scanner, table Table) error {
 				return errFmtModDest
 			}
 			opts["regex"] = string(t.Value)
+		case optTag:
+			if t = s.Next(); t.Token != word {
+				return errFmtModDest
+			}
+			opts["tag"] = string(t.Value)
 		default:
 			return errFmtModDest
 		}
@@ -515,6 +833,11 @@ func readModRoute(s *toki.Scanner, table Table) error {
 				return errFmtModDest
 			}
 			opts["regex"] = string(t.Value)
+		case optTag:
+			if t = s.Next(); t.Token != word {
+				return errFmtModDest
+			}
+			opts["tag"] = string(t.Value)
 		default:
 			return errFmtModDest
 		}
@@ -552,8 +875,10 @@ func readDestinations(s *toki.Scanner, table Table, allowMatcher bool, routeKey
 }
 
 func readDestination(s *toki.Scanner, table Table, allowMatcher bool, routeKey string) (dest *destination.Destination, err error) {
-	var prefix, sub, regex, addr, spoolDir string
-	var spool, pickle bool
+	var prefix, sub, regex, tag, addr, spoolDir string
+	var spool, pickle, internal, tags bool
+	var tagsAllow, tagsDeny []string
+	var tlsConfig destination.TLSConfig
 	flush := 1000
 	reconn := 10000
 	connBufSize := 30000
@@ -591,6 +916,11 @@ func readDestination(s *toki.Scanner, table Table, allowMatcher bool, routeKey s
 				return nil, errFmtAddRoute
 			}
 			regex = string(t.Value)
+		case optTag:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tag = string(t.Value)
 		case optFlush:
 			if t = s.Next(); t.Token != num {
 				return nil, errFmtAddRoute
@@ -615,6 +945,65 @@ func readDestination(s *toki.Scanner, table Table, allowMatcher bool, routeKey s
 			if err != nil {
 				return nil, fmt.Errorf("unrecognized pickle value '%s'", t)
 			}
+		case optInternal:
+			if t = s.Next(); t.Token != optTrue && t.Token != optFalse {
+				return nil, errFmtAddRoute
+			}
+			internal, err = strconv.ParseBool(string(t.Value))
+			if err != nil {
+				return nil, fmt.Errorf("unrecognized internal value '%s'", t)
+			}
+		case optTags:
+			if t = s.Next(); t.Token != optTrue && t.Token != optFalse {
+				return nil, errFmtAddRoute
+			}
+			tags, err = strconv.ParseBool(string(t.Value))
+			if err != nil {
+				return nil, fmt.Errorf("unrecognized tags value '%s'", t)
+			}
+		case optTagsAllow:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tagsAllow = strings.Split(string(t.Value), ",")
+		case optTagsDeny:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tagsDeny = strings.Split(string(t.Value), ",")
+		case optTLS:
+			if t = s.Next(); t.Token != optTrue && t.Token != optFalse {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.Enabled, err = strconv.ParseBool(string(t.Value))
+			if err != nil {
+				return nil, fmt.Errorf("unrecognized tls value '%s'", t)
+			}
+		case optTLSCA:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.CAFile = string(t.Value)
+		case optTLSCert:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.CertFile = string(t.Value)
+		case optTLSKey:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.KeyFile = string(t.Value)
+		case optTLSServerName:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.ServerName = string(t.Value)
+		case optTLSMinVersion:
+			if t = s.Next(); t.Token != word {
+				return nil, errFmtAddRoute
+			}
+			tlsConfig.MinVersion = string(t.Value)
 		case optSpool:
 			if t = s.Next(); t.Token != optTrue && t.Token != optFalse {
 				return nil, errFmtAddRoute
@@ -702,10 +1091,10 @@ func readDestination(s *toki.Scanner, table Table, allowMatcher bool, routeKey s
 
 	periodFlush := time.Duration(flush) * time.Millisecond
 	periodReConn := time.Duration(reconn) * time.Millisecond
-	if !allowMatcher && (prefix != "" || sub != "" || regex != "") {
-		return nil, fmt.Errorf("matching options (prefix, sub, and regex) not allowed for this route type")
+	if !allowMatcher && (prefix != "" || sub != "" || regex != "" || tag != "") {
+		return nil, fmt.Errorf("matching options (prefix, sub, regex and tag) not allowed for this route type")
 	}
-	return destination.New(routeKey, prefix, sub, regex, addr, spoolDir, spool, pickle, periodFlush, periodReConn, connBufSize, ioBufSize, spoolBufSize, spoolMaxBytesPerFile, spoolSyncEvery, spoolSyncPeriod, spoolSleep, unspoolSleep)
+	return destination.New(routeKey, prefix, sub, regex, tag, addr, spoolDir, spool, pickle, internal, tags, tagsAllow, tagsDeny, tlsConfig, periodFlush, periodReConn, connBufSize, ioBufSize, spoolBufSize, spoolMaxBytesPerFile, spoolSyncEvery, spoolSyncPeriod, spoolSleep, unspoolSleep)
 }
 
 func ParseDestinations(destinationConfigs []string, table Table, allowMatcher bool, routeKey string) (destinations []*destination.Destination, err error) {
@@ -722,33 +1111,38 @@ func ParseDestinations(destinationConfigs []string, table Table, allowMatcher bo
 	return destinations, nil
 }
 
-func readRouteOpts(s *toki.Scanner) (prefix, sub, regex string, err error) {
+func readRouteOpts(s *toki.Scanner) (prefix, sub, regex, tag string, err error) {
 	for {
 		t := s.Next()
 		switch t.Token {
 		case toki.EOF:
 			return
 		case toki.Error:
-			return "", "", "", errors.New("read the error token instead of one i recognize")
+			return "", "", "", "", errors.New("read the error token instead of one i recognize")
 		case optPrefix:
 			if t = s.Next(); t.Token != word {
-				return "", "", "", errors.New("bad prefix option")
+				return "", "", "", "", errors.New("bad prefix option")
 			}
 			prefix = string(t.Value)
 		case optSub:
 			if t = s.Next(); t.Token != word {
-				return "", "", "", errors.New("bad sub option")
+				return "", "", "", "", errors.New("bad sub option")
 			}
 			sub = string(t.Value)
 		case optRegex:
 			if t = s.Next(); t.Token != word {
-				return "", "", "", errors.New("bad regex option")
+				return "", "", "", "", errors.New("bad regex option")
 			}
 			regex = string(t.Value)
+		case optTag:
+			if t = s.Next(); t.Token != word {
+				return "", "", "", "", errors.New("bad tag option")
+			}
+			tag = string(t.Value)
 		case sep:
 			return
 		default:
-			return "", "", "", fmt.Errorf("unrecognized option '%s'", t.Value)
+			return "", "", "", "", fmt.Errorf("unrecognized option '%s'", t.Value)
 		}
 	}
 }
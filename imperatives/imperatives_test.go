@@ -27,6 +27,66 @@ func TestScanner(t *testing.T) {
 			`addAgg avg ^stats\.timers\.(app|proxy|static)[0-9]+\.requests\.(.*) stats.timers._avg_$1.requests.$2 5 10`,
 			[]toki.Token{addAgg, avgFn, word, word, num, num},
 		},
+		{
+			`addAgg count ^raw\.(.*) agg.$1.count 10 20`,
+			[]toki.Token{addAgg, countFn, word, word, num, num},
+		},
+		{
+			`addAgg countDistinct ^raw\.(.*) agg.$1.distinct 10 20`,
+			[]toki.Token{addAgg, countDistinctFn, word, word, num, num},
+		},
+		{
+			`addAgg rate ^raw\.(.*) agg.$1.rate 10 20`,
+			[]toki.Token{addAgg, rateFn, word, word, num, num},
+		},
+		{
+			`addAgg median ^raw\.(.*) agg.$1.median 10 20`,
+			[]toki.Token{addAgg, medianFn, word, word, num, num},
+		},
+		{
+			`addAgg range ^raw\.(.*) agg.$1.range 10 20`,
+			[]toki.Token{addAgg, rangeFn, word, word, num, num},
+		},
+		{
+			`addAgg first ^raw\.(.*) agg.$1.first 10 20`,
+			[]toki.Token{addAgg, firstFn, word, word, num, num},
+		},
+		{
+			`addAgg count,rate,countDistinct regex=^raw\.(.*) agg.$1 10 20`,
+			[]toki.Token{addAgg, word, optRegex, word, word, num, num},
+		},
+		{
+			`addAgg sum ^raw\.(.*) agg.$1 10 20 resolutions=60:.1m,300/60:.5m_sliding`,
+			[]toki.Token{addAgg, sumFn, word, word, num, num, optResolutions, word},
+		},
+		{
+			`addAgg rollup ^raw\.(.*) $1 60 120 storageSchemas=../examples/storage-schemas.conf storageAggregation=../examples/storage-aggregation.conf`,
+			[]toki.Token{addAgg, word, word, word, num, num, optStorageSchemas, word, optStorageAggregation, word},
+		},
+		{
+			`addAgg sum ^raw\.(.*) agg.$1 10 20 latePolicy=reemit`,
+			[]toki.Token{addAgg, sumFn, word, word, num, num, optLatePolicy, word},
+		},
+		{
+			`addAgg sum ^raw\.(.*) agg.$1 10 20 maxSeries=1000 overflowPolicy=aggregate`,
+			[]toki.Token{addAgg, sumFn, word, word, num, num, optMaxSeries, num, optOverflowPolicy, word},
+		},
+		{
+			`addAgg sum ^raw\.(.*) agg.$1 10 20 watermark=true idleTimeout=300`,
+			[]toki.Token{addAgg, sumFn, word, word, num, num, optWatermark, optTrue, optIdleTimeout, num},
+		},
+		{
+			`addAgg min,max,avg,p99 regex=^raw\.(.*) agg.$1 10 20`,
+			[]toki.Token{addAgg, word, optRegex, word, word, num, num},
+		},
+		{
+			`addAgg p50,p95,p99.9 regex=^raw\.(.*) agg.$1 10 20 sketchAccuracy=0.01`,
+			[]toki.Token{addAgg, word, optRegex, word, word, num, num, optSketchAccuracy, word},
+		},
+		{
+			`addAgg sum ^raw\.(.*) agg.$1 10 20 groupBy=dc,env outTags=aggregated_by=sum`,
+			[]toki.Token{addAgg, sumFn, word, word, num, num, optGroupBy, word, optOutTags, word},
+		},
 		{
 			"addRoute sendAllMatch carbon-default  127.0.0.1:2005 spool=true pickle=false",
 			[]toki.Token{addRouteSendAllMatch, word, sep, word, optSpool, optTrue, optPickle, optFalse},
@@ -39,6 +99,38 @@ func TestScanner(t *testing.T) {
 			"addRoute sendFirstMatch analytics regex=(Err/s|wait_time|logger)  graphite.prod:2003 prefix=prod. spool=true pickle=true  graphite.staging:2003 prefix=staging. spool=true pickle=true",
 			[]toki.Token{addRouteSendFirstMatch, word, optRegex, word, sep, word, optPrefix, word, optSpool, optTrue, optPickle, optTrue, sep, word, optPrefix, word, optSpool, optTrue, optPickle, optTrue},
 		},
+		{
+			"addRoute loadBalance graphite-pool strategy=leastQueued prefix=app.  127.0.0.1:2010  127.0.0.1:2011",
+			[]toki.Token{addRouteLoadBalance, word, optStrategy, word, optPrefix, word, sep, word, sep, word},
+		},
+		{
+			"addRoute failover graphite-ha downPeriod=5 upPeriod=120 prefix=app.  127.0.0.1:2012  127.0.0.1:2013",
+			[]toki.Token{addRouteFailover, word, optDownPeriod, num, optUpPeriod, num, optPrefix, word, sep, word, sep, word},
+		},
+		{
+			"addRoute sendAllMatch relay-tier  127.0.0.1:2007 internal=true",
+			[]toki.Token{addRouteSendAllMatch, word, sep, word, optInternal, optTrue},
+		},
+		{
+			"addRoute sendAllMatch graphite-tagged  127.0.0.1:2008 tags=true tagsallow=dc,env tagsdeny=env",
+			[]toki.Token{addRouteSendAllMatch, word, sep, word, optTags, optTrue, optTagsAllow, word, optTagsDeny, word},
+		},
+		{
+			"addRoute sendAllMatch graphite-tls  graphite.example.com:2003 tls=true tlsservername=graphite tlsminversion=1.3",
+			[]toki.Token{addRouteSendAllMatch, word, sep, word, optTLS, optTrue, optTLSServerName, word, optTLSMinVersion, word},
+		},
+		{
+			"addRoute sendAllMatch per-app tag=app:api,!canary  127.0.0.1:2009 tag=dc~/^(par|ams)$/",
+			[]toki.Token{addRouteSendAllMatch, word, optTag, word, sep, word, optTag, word},
+		},
+		{
+			"addBlack tag pool:test|env:dev",
+			[]toki.Token{addBlack, word, word},
+		},
+		{
+			"modRoute per-app tag=app:front",
+			[]toki.Token{modRoute, word, optTag, word},
+		},
 		//{ disabled cause tries to read the schemas.conf file
 		//	"addRoute grafanaNet grafanaNet  http://localhost:8081/metrics your-grafana.net-api-key /path/to/storage-schemas.conf",
 		//	[]toki.Token{addRouteGrafanaNet, word, sep, word, word},
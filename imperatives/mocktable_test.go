@@ -19,4 +19,5 @@ func (m *mockTable) DelRoute(key string) error
 func (m *mockTable) UpdateDestination(key string, index int, opts map[string]string) error { return nil }
 func (m *mockTable) UpdateRoute(key string, opts map[string]string) error                  { return nil }
 func (m *mockTable) GetIn() chan encoding.Datapoint                                        { return nil }
+func (m *mockTable) GetLateIn() chan aggregator.LatePoint                                  { return nil }
 func (m *mockTable) GetSpoolDir() string                                                   { return "fake-spool-dir" }
@@ -2,12 +2,33 @@ package input
 
 import (
 	"bufio"
+	"encoding/binary"
 	"fmt"
 	"io"
 
 	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/metrics"
 )
 
+// remoteAddrTag is the tag set by the inputs to the address of the client
+const remoteAddrTag = "appIpPortSrc"
+
+// instanceTag is the tag set by the inputs to the instance of the relay
+const instanceTag = "carbonRelayInstance"
+
+// reservedTags are the tags set by the inputs, which the messages can't set, see resetReservedTags
+var reservedTags = []string{instanceTag, remoteAddrTag, clientCertTag}
+
+// errTypeTooLarge is the error type of frames and requests exceeding their size limit, see encoding.ErrorType for the others
+const errTypeTooLarge = "too_large"
+
+// tooLargeError is the error of a frame or request exceeding its size limit
+type tooLargeError string
+
+func (e tooLargeError) Error() string {
+	return string(e)
+}
+
 type Input interface {
 	Name() string
 	Format() encoding.FormatName
@@ -20,6 +41,17 @@ type BaseInput struct {
 	Dispatcher Dispatcher
 	name       string
 	handler    encoding.FormatAdapter
+	im         *metrics.InputMetrics
+	// keep the reserved tags carried by the internal format frames, see resetReservedTags
+	trustTags bool
+}
+
+func newBaseInput(name string, handler encoding.FormatAdapter) BaseInput {
+	return BaseInput{
+		name:    name,
+		handler: handler,
+		im:      metrics.NewInputMetrics(name, nil),
+	}
 }
 
 func (b *BaseInput) Name() string {
@@ -33,6 +65,9 @@ func (b *BaseInput) Format() encoding.FormatName {
 }
 
 func (b *BaseInput) handleReader(r io.Reader, tags encoding.Tags) error {
+	if framed, ok := b.handler.(encoding.FramedFormatAdapter); ok {
+		return b.handleFramedReader(r, framed, tags)
+	}
 	scanner := bufio.NewScanner(r)
 	for scanner.Scan() {
 		b.handle(scanner.Bytes(), tags)
@@ -40,24 +75,105 @@ func (b *BaseInput) handleReader(r io.Reader, tags encoding.Tags) error {
 	return scanner.Err()
 }
 
+// handleFramedReader reads frames made of a 4 bytes big endian length followed by the payload.
+// a frame that fails to decode is skipped, but an oversized frame means we can't trust
+// the stream anymore so we bail out and let the caller close the connection
+func (b *BaseInput) handleFramedReader(r io.Reader, framed encoding.FramedFormatAdapter, tags encoding.Tags) error {
+	var header [4]byte
+	var frame []byte
+	for {
+		if _, err := io.ReadFull(r, header[:]); err != nil {
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		}
+		size := binary.BigEndian.Uint32(header[:])
+		if size > framed.MaxFrameSize() {
+			err := tooLargeError(fmt.Sprintf("frame of %d bytes exceeds max_frame_size of %d bytes", size, framed.MaxFrameSize()))
+			b.bad(tags[remoteAddrTag], "", nil, err)
+			return err
+		}
+		if uint32(cap(frame)) < size {
+			frame = make([]byte, size)
+		}
+		frame = frame[:size]
+		if _, err := io.ReadFull(r, frame); err != nil {
+			return err
+		}
+		b.handleFrame(frame, framed, tags)
+	}
+}
+
+func (b *BaseInput) handleFrame(frame []byte, framed encoding.FramedFormatAdapter, tags encoding.Tags) error {
+	remoteAddr := tags[remoteAddrTag]
+	dps, err := framed.LoadFrame(frame, tags)
+	if err != nil {
+		b.bad(remoteAddr, "", frame, err)
+		return fmt.Errorf("error while processing %s frame: %s", framed.KindS(), err)
+	}
+	for _, dp := range dps {
+		b.resetReservedTags(dp.Tags, tags)
+		b.Dispatcher.Dispatch(dp)
+	}
+	return nil
+}
+
 func (b *BaseInput) handle(msg []byte, tags encoding.Tags) error {
 	if len(msg) == 0 {
 		return nil
 	}
+	if framed, ok := b.handler.(encoding.FramedFormatAdapter); ok {
+		return b.handleFrame(msg, framed, tags)
+	}
+	remoteAddr := tags[remoteAddrTag]
 	d, err := b.handler.Load(msg, tags)
 	if err != nil {
+		b.bad(remoteAddr, d.Name, msg, err)
 		return fmt.Errorf("error while processing `%s`: %s", string(msg), err)
 	}
+	b.resetReservedTags(d.Tags, tags)
 	b.Dispatcher.Dispatch(d)
 	return nil
 }
 
+// resetReservedTags sets the reserved tags of a datapoint to the ones of the input, tags, removing the ones the input didn't set,
+// so that a client can't spoof them with the tags of its messages.
+// with trustTags, the internal format frames keep the ones set by the first relay, see encoding.InternalFormatAdapter,
+// except for the client certificate verified by this input, if any
+func (b *BaseInput) resetReservedTags(dpTags, tags encoding.Tags) {
+	trusted := b.trustTags && b.handler.Kind() == encoding.InternalFormat
+	for _, key := range reservedTags {
+		value, ok := tags[key]
+		switch {
+		case ok && (!trusted || key == clientCertTag):
+			dpTags[key] = value
+		case !trusted:
+			delete(dpTags, key)
+		}
+	}
+}
+
+// bad counts a message which failed to load, by error type, and hands it to the dispatcher
+// so it shows up in the bad metrics. metric is the name of the metric, if it could be parsed
+func (b *BaseInput) bad(remoteAddr, metric string, msg []byte, err error) {
+	errType := encoding.ErrorType(err)
+	if _, ok := err.(tooLargeError); ok {
+		errType = errTypeTooLarge
+	}
+	b.im.Invalid.WithLabelValues(errType).Inc()
+	b.Dispatcher.AddBad(b.name, remoteAddr, metric, msg, err)
+}
+
 type Dispatcher interface {
 	// Dispatch runs data validation and processing
 	// implementations must not reuse buf after returning
 	Dispatch(dp encoding.Datapoint)
 	// IncNumInvalid marks protocol-level decoding failures
 	// does not apply to carbon as the protocol is trivial and any parse failure
-	// is a message failure (handled in Dispatch)
+	// is a message failure (see AddBad)
 	IncNumInvalid()
+	// AddBad marks a message which failed to load, see BadMetrics.
+	// it was read by the named input from remoteAddr, if known. metric is its name, if it could be parsed
+	AddBad(input, remoteAddr, metric string, msg []byte, err error)
 }
@@ -0,0 +1,105 @@
+package input
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockDispatcher struct {
+	dps        []encoding.Datapoint
+	numInvalid int
+	bad        []badMessage
+}
+
+type badMessage struct {
+	input, remoteAddr, metric, msg string
+	err                            error
+}
+
+func (m *mockDispatcher) Dispatch(dp encoding.Datapoint) {
+	m.dps = append(m.dps, dp)
+}
+
+func (m *mockDispatcher) IncNumInvalid() {
+	m.numInvalid++
+}
+
+func (m *mockDispatcher) AddBad(input, remoteAddr, metric string, msg []byte, err error) {
+	m.numInvalid++
+	m.bad = append(m.bad, badMessage{input, remoteAddr, metric, string(msg), err})
+}
+
+func TestHandleFramedReader(t *testing.T) {
+	d := &mockDispatcher{}
+	b := newBaseInput("test", encoding.NewPickle(false, 0))
+	b.Dispatcher = d
+
+	var stream []byte
+	stream = encoding.AppendPickleFrame(stream, []encoding.Datapoint{
+		{Name: "a.b", Value: 1, Timestamp: 10},
+		{Name: "a.c", Value: 2, Timestamp: 10},
+	})
+	// a frame which can't be decoded is skipped
+	stream = append(stream, 0, 0, 0, 3, 'a', 'b', 'c')
+	stream = encoding.AppendPickleFrame(stream, []encoding.Datapoint{{Name: "a.d", Value: 3, Timestamp: 11}})
+
+	err := b.handleReader(bytes.NewReader(stream), encoding.Tags{})
+	assert.NoError(t, err)
+	assert.Equal(t, 1, d.numInvalid)
+	assert.Len(t, d.dps, 3)
+	assert.Equal(t, "a.d", d.dps[2].Name)
+}
+
+func TestHandleFramedReaderOversized(t *testing.T) {
+	d := &mockDispatcher{}
+	b := newBaseInput("test", encoding.NewPickle(false, 16))
+	b.Dispatcher = d
+
+	var header [4]byte
+	binary.BigEndian.PutUint32(header[:], 17)
+	stream := append(header[:], make([]byte, 17)...)
+
+	err := b.handleReader(bytes.NewReader(stream), encoding.Tags{"appIpPortSrc": "1.2.3.4:5"})
+	assert.Error(t, err)
+	assert.Equal(t, 1, d.numInvalid)
+	assert.Empty(t, d.dps)
+	if assert.Len(t, d.bad, 1) {
+		assert.Equal(t, "1.2.3.4:5", d.bad[0].remoteAddr)
+		assert.Equal(t, err, d.bad[0].err)
+	}
+}
+
+func TestHandleFramedReaderTruncated(t *testing.T) {
+	d := &mockDispatcher{}
+	b := newBaseInput("test", encoding.NewPickle(false, 0))
+	b.Dispatcher = d
+
+	stream := encoding.AppendPickleFrame(nil, []encoding.Datapoint{{Name: "a.b", Value: 1, Timestamp: 10}})
+	err := b.handleReader(bytes.NewReader(stream[:len(stream)-2]), encoding.Tags{})
+	assert.Error(t, err)
+	assert.Empty(t, d.dps)
+}
+
+func TestHandleBad(t *testing.T) {
+	d := &mockDispatcher{}
+	b := newBaseInput("test", encoding.NewPlain(false))
+	b.Dispatcher = d
+
+	stream := "a.b 1 10\na.c notanumber 10\nincomplete\na.é 1 10\na.d 2 10\n"
+	err := b.handleReader(strings.NewReader(stream), encoding.Tags{"appIpPortSrc": "1.2.3.4:5"})
+	assert.NoError(t, err)
+	assert.Len(t, d.dps, 2)
+	assert.Equal(t, 3, d.numInvalid)
+	if assert.Len(t, d.bad, 3) {
+		assert.Equal(t, badMessage{"test", "1.2.3.4:5", "a.c", "a.c notanumber 10", d.bad[0].err}, d.bad[0])
+		assert.Equal(t, encoding.ErrorTypeParse, encoding.ErrorType(d.bad[0].err))
+		assert.Equal(t, "", d.bad[1].metric)
+		assert.Equal(t, encoding.ErrorTypeParse, encoding.ErrorType(d.bad[1].err))
+		assert.Equal(t, encoding.ErrorTypeNonASCII, encoding.ErrorType(d.bad[2].err))
+	}
+}
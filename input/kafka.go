@@ -88,7 +88,7 @@ func NewKafka(brokers []string, topic string, consumerGroup string, kafkaConfig
 	}
 
 	return &Kafka{
-		BaseInput: BaseInput{handler: h, name: fmt.Sprintf("kafka[topic=%s;cg=%s;id=%s]", topic, consumerGroup, kafkaConfig.ClientID)},
+		BaseInput: newBaseInput(fmt.Sprintf("kafka[topic=%s;cg=%s;id=%s]", topic, consumerGroup, kafkaConfig.ClientID), h),
 		topic:     topic,
 		client:    client,
 		cg:        cg,
@@ -128,7 +128,7 @@ func (k *Kafka) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.C
 		k.logger.Debug("metric value:", zap.ByteString("messageValue", message.Value))
 		tags := k.getKafkaTags(message.Headers)
 		if err := k.handle(message.Value, tags); err != nil {
-			k.logger.Debug("invalid message from kafka", zap.ByteString("messageValue", message.Value))
+			k.logger.Debug("invalid message from kafka", zap.ByteString("messageValue", message.Value), zap.Error(err))
 		}
 		session.MarkMessage(message, "")
 	}
@@ -2,6 +2,7 @@ package input
 
 import (
 	"bytes"
+	"crypto/tls"
 	"net"
 	"sync"
 	"time"
@@ -27,6 +28,7 @@ type Listener struct {
 	HandleConn  func(l *Listener, c net.Conn)
 	logger      *zap.Logger
 	instance    string
+	tlsConfig   *tls.Config // nil unless the tcp workers serve TLS
 }
 
 const (
@@ -48,10 +50,11 @@ type udpWorker struct {
 	packetConn net.PacketConn
 }
 
-// NewListener creates a new listener.
-func NewListener(addr string, readTimeout time.Duration, TCPWorkerCount int, UDPWorkerCount int, handler encoding.FormatAdapter, instance string) *Listener {
-	return &Listener{
-		BaseInput:   BaseInput{handler: handler, name: addr},
+// NewListener creates a new listener. its tcp workers serve TLS if tlsConfig is set.
+// with trustTags, the internal format frames keep the provenance tags of the upstream relay
+func NewListener(addr string, readTimeout time.Duration, TCPWorkerCount int, UDPWorkerCount int, handler encoding.FormatAdapter, instance string, tlsConfig *tls.Config, trustTags bool) *Listener {
+	l := &Listener{
+		BaseInput:   newBaseInput(addr, handler),
 		kind:        handler.KindS(),
 		addr:        addr,
 		readTimeout: readTimeout,
@@ -61,7 +64,10 @@ func NewListener(addr string, readTimeout time.Duration, TCPWorkerCount int, UDP
 		tcpWorkers:  make([]tcpWorker, TCPWorkerCount),
 		logger:      zap.L().With(zap.String("localAddress", addr), zap.String("kind", handler.KindS())),
 		instance:    instance,
+		tlsConfig:   tlsConfig,
 	}
+	l.trustTags = trustTags
+	return l
 }
 
 // Name returns Handler's name.
@@ -186,6 +192,9 @@ func (w *tcpWorker) listen(l *Listener) error {
 	if err != nil {
 		return err
 	}
+	if l.tlsConfig != nil {
+		listener = tls.NewListener(listener, l.tlsConfig)
+	}
 	w.listener = listener
 	return nil
 }
@@ -207,13 +216,20 @@ func (w *tcpWorker) acceptTcpConn(l *Listener, conn net.Conn) {
 		}
 	}()
 
+	if tlsConn, ok := conn.(*tls.Conn); ok {
+		if err := l.handshake(tlsConn); err != nil {
+			l.logger.Warn("tls handshake failed. closing conn", zap.Stringer("remoteAddress", conn.RemoteAddr()), zap.Error(err))
+			conn.Close()
+			return
+		}
+	}
 	l.HandleConn(l, NewTimeoutConn(conn, l.readTimeout))
 	conn.Close()
 }
 func (l *Listener) getTags(applicationIp string) encoding.Tags {
 	tags := make(encoding.Tags)
-	tags["carbonRelayInstance"] = l.instance
-	tags["appIpPortSrc"] = applicationIp
+	tags[instanceTag] = l.instance
+	tags[remoteAddrTag] = applicationIp
 	return tags
 }
 
@@ -222,6 +238,9 @@ func handleConn(l *Listener, c net.Conn) {
 	handleConnLogger := l.logger.With(zap.Stringer("remoteAddress", c.RemoteAddr()))
 	l.logger.Debug("handleConn: new tcp connection")
 	tags := l.getTags(c.RemoteAddr().String())
+	if identity := clientIdentity(c); identity != "" {
+		tags[clientCertTag] = identity
+	}
 	err := l.handleReader(c, tags)
 	if err != nil {
 		handleConnLogger.Warn("handleConn returned an error. closing conn", zap.Error(err))
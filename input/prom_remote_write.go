@@ -0,0 +1,124 @@
+package input
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"go.uber.org/zap"
+)
+
+// DefaultPromMaxRequestSize bounds the decompressed size of a write request
+const DefaultPromMaxRequestSize = 32 << 20
+
+// PromRemoteWrite serves the prometheus remote write protocol over HTTP:
+// snappy compressed protobuf WriteRequests POSTed to path
+type PromRemoteWrite struct {
+	BaseInput
+	addr           string
+	path           string
+	maxRequestSize int
+	readTimeout    time.Duration
+	instance       string
+	server         *http.Server
+	logger         *zap.Logger
+}
+
+func NewPromRemoteWrite(addr, path string, readTimeout time.Duration, maxRequestSize int, handler encoding.PromWriteRequestAdapter, instance string) *PromRemoteWrite {
+	if maxRequestSize <= 0 {
+		maxRequestSize = DefaultPromMaxRequestSize
+	}
+	return &PromRemoteWrite{
+		BaseInput:      newBaseInput(fmt.Sprintf("prom_remote_write[%s%s]", addr, path), handler),
+		addr:           addr,
+		path:           path,
+		maxRequestSize: maxRequestSize,
+		readTimeout:    readTimeout,
+		instance:       instance,
+		logger:         zap.L().With(zap.String("localAddress", addr), zap.String("kind", handler.KindS())),
+	}
+}
+
+func (p *PromRemoteWrite) Start(d Dispatcher) error {
+	p.Dispatcher = d
+	mux := http.NewServeMux()
+	mux.Handle(p.path, p)
+	p.server = &http.Server{
+		Addr:        p.addr,
+		Handler:     mux,
+		ReadTimeout: p.readTimeout,
+	}
+	// listen outside of the goroutine so that startup can be interrupted
+	l, err := net.Listen("tcp", p.addr)
+	if err != nil {
+		return err
+	}
+	go func() {
+		p.logger.Info("listening", zap.String("path", p.path))
+		err := p.server.Serve(l)
+		if err != nil && err != http.ErrServerClosed {
+			p.logger.Error("prometheus remote write server stopped", zap.Error(err))
+		}
+	}()
+	return nil
+}
+
+func (p *PromRemoteWrite) Stop() error {
+	if p.server == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return p.server.Shutdown(ctx)
+}
+
+func (p *PromRemoteWrite) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	compressed, err := ioutil.ReadAll(io.LimitReader(r.Body, int64(p.maxRequestSize)+1))
+	if err != nil {
+		p.logger.Debug("can't read request body", zap.String("remoteAddress", r.RemoteAddr), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if len(compressed) > p.maxRequestSize {
+		p.bad(r.RemoteAddr, "", nil, tooLargeError(fmt.Sprintf("request exceeds max_request_size of %d bytes", p.maxRequestSize)))
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	size, err := snappy.DecodedLen(compressed)
+	if err == nil && size > p.maxRequestSize {
+		p.bad(r.RemoteAddr, "", nil, tooLargeError(fmt.Sprintf("request of %d bytes once decompressed exceeds max_request_size of %d bytes", size, p.maxRequestSize)))
+		http.Error(w, "request too large", http.StatusRequestEntityTooLarge)
+		return
+	}
+	var msg []byte
+	if err == nil {
+		msg, err = snappy.Decode(nil, compressed)
+	}
+	if err != nil {
+		p.bad(r.RemoteAddr, "", nil, err)
+		p.logger.Debug("invalid snappy payload", zap.String("remoteAddress", r.RemoteAddr), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	tags := encoding.Tags{
+		instanceTag:   p.instance,
+		remoteAddrTag: r.RemoteAddr,
+	}
+	if err := p.handle(msg, tags); err != nil {
+		p.logger.Debug("invalid write request", zap.String("remoteAddress", r.RemoteAddr), zap.Error(err))
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
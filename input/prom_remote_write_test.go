@@ -0,0 +1,64 @@
+package input
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/golang/snappy"
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPromRemoteWriteServeHTTP(t *testing.T) {
+	h, _ := encoding.NewPromWriteRequest("prometheus.{job}.{__name__}")
+	d := &mockDispatcher{}
+	p := NewPromRemoteWrite("127.0.0.1:0", "/write", 0, 1024, h, "test")
+	p.Dispatcher = d
+
+	body := snappy.Encode(nil, h.Dump(encoding.Datapoint{Name: "up", Value: 1, Timestamp: 10, Tags: encoding.Tags{"job": "node"}}))
+	req := httptest.NewRequest("POST", "/write", bytes.NewReader(body))
+	req.RemoteAddr = "10.0.0.1:1234"
+	w := httptest.NewRecorder()
+	p.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusNoContent, w.Code)
+	assert.Equal(t, []encoding.Datapoint{{
+		Name:      "prometheus.node.up",
+		Value:     1,
+		Timestamp: 10,
+		Tags:      encoding.Tags{"job": "node", "carbonRelayInstance": "test", "appIpPortSrc": "10.0.0.1:1234"},
+	}}, d.dps)
+}
+
+func TestPromRemoteWriteInvalidRequests(t *testing.T) {
+	h, _ := encoding.NewPromWriteRequest("")
+	big := snappy.Encode(nil, make([]byte, 4096))
+	cases := map[string]struct {
+		method string
+		body   []byte
+		code   int
+		bad    bool
+	}{
+		"method":   {"GET", nil, http.StatusMethodNotAllowed, false},
+		"snappy":   {"POST", []byte("not snappy"), http.StatusBadRequest, true},
+		"protobuf": {"POST", snappy.Encode(nil, []byte{0xff}), http.StatusBadRequest, true},
+		"tooLarge": {"POST", big, http.StatusRequestEntityTooLarge, true},
+	}
+	for test, c := range cases {
+		t.Run(test, func(t *testing.T) {
+			d := &mockDispatcher{}
+			p := NewPromRemoteWrite("127.0.0.1:0", "/write", 0, 1024, h, "test")
+			p.Dispatcher = d
+			w := httptest.NewRecorder()
+			p.ServeHTTP(w, httptest.NewRequest(c.method, "/write", bytes.NewReader(c.body)))
+			assert.Equal(t, c.code, w.Code)
+			assert.Empty(t, d.dps)
+			// the client shows up in the bad metrics, with the address httptest gives it
+			if c.bad && assert.Len(t, d.bad, 1) {
+				assert.Equal(t, "192.0.2.1:1234", d.bad[0].remoteAddr)
+			}
+		})
+	}
+}
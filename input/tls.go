@@ -0,0 +1,90 @@
+package input
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"time"
+)
+
+// clientCertTag is the tag set by the TLS listeners to the identity of the client certificate, see clientIdentity
+const clientCertTag = "appClientCert"
+
+// NewTLSConfig returns the TLS config of a listener serving the certificate of certFile and keyFile.
+// clients are authenticated with the CAs of clientCAFile, if set: when they present a certificate,
+// or always if requireClientCert is set
+func NewTLSConfig(certFile, keyFile, clientCAFile string, requireClientCert bool) (*tls.Config, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("tls needs a cert_file and a key_file")
+	}
+	cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+	if err != nil {
+		return nil, err
+	}
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tls.VersionTLS12,
+	}
+	if clientCAFile == "" {
+		if requireClientCert {
+			return nil, errors.New("require_client_cert needs a client_ca_file")
+		}
+		return config, nil
+	}
+	pem, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates found in client_ca_file '%s'", clientCAFile)
+	}
+	config.ClientAuth = tls.VerifyClientCertIfGiven
+	if requireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return config, nil
+}
+
+// handshake does the TLS handshake of conn, within the read timeout of the listener,
+// so that the client certificate is known before reading any data
+func (l *Listener) handshake(conn *tls.Conn) error {
+	if l.readTimeout > 0 {
+		conn.SetDeadline(time.Now().Add(l.readTimeout))
+		defer conn.SetDeadline(time.Time{})
+	}
+	return conn.Handshake()
+}
+
+// clientIdentity returns the common name of the client certificate of c, or its first subject alternative name
+// when it has none. it returns "" when c is not a TLS conn or the client didn't present a certificate
+func clientIdentity(c net.Conn) string {
+	if tc, ok := c.(TimeoutConn); ok {
+		c = tc.Conn
+	}
+	tlsConn, ok := c.(*tls.Conn)
+	if !ok {
+		return ""
+	}
+	certs := tlsConn.ConnectionState().PeerCertificates
+	if len(certs) == 0 {
+		return ""
+	}
+	cert := certs[0]
+	switch {
+	case cert.Subject.CommonName != "":
+		return cert.Subject.CommonName
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0]
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0]
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String()
+	case len(cert.IPAddresses) > 0:
+		return cert.IPAddresses[0].String()
+	}
+	return ""
+}
@@ -0,0 +1,178 @@
+package input
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/internal/testcert"
+	"github.com/stretchr/testify/assert"
+)
+
+// chanDispatcher hands the datapoints over a channel, as the listener dispatches from its own goroutines
+type chanDispatcher struct {
+	mockDispatcher
+	dps chan encoding.Datapoint
+}
+
+func (c *chanDispatcher) Dispatch(dp encoding.Datapoint) {
+	c.dps <- dp
+}
+
+func TestTLSListener(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testcert.Issue(t, "ca", 1, nil)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "relay.pem"), filepath.Join(dir, "relay.key")
+	testcert.Write(t, ca, caFile, "")
+	testcert.Write(t, testcert.Issue(t, "relay", 2, &ca), certFile, keyFile)
+
+	_, err = NewTLSConfig(certFile, keyFile, "", true)
+	assert.Error(t, err, "requiring client certificates needs a CA to verify them")
+	tlsConfig, err := NewTLSConfig(certFile, keyFile, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d := &chanDispatcher{dps: make(chan encoding.Datapoint, 2)}
+	l := NewListener("127.0.0.1:0", time.Second, 1, 0, encoding.NewPlain(false), "test", tlsConfig, false)
+	if err := l.Start(d); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+	addr := l.tcpWorkers[0].listener.Addr().String()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := testcert.Issue(t, "app1", 3, &ca)
+	conn, err := tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "relay", Certificates: []tls.Certificate{client}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("a.b 1 10\n"))
+	// the tags set by the listener can't be spoofed
+	conn.Write([]byte("c.d;appClientCert=admin;carbonRelayInstance=other 2 10\n"))
+	localAddr := conn.LocalAddr().String()
+	conn.Close()
+	for _, name := range []string{"a.b", "c.d"} {
+		select {
+		case dp := <-d.dps:
+			assert.Equal(t, name, dp.Name)
+			assert.Equal(t, "app1", dp.Tags[clientCertTag])
+			assert.Equal(t, "test", dp.Tags["carbonRelayInstance"])
+			assert.Equal(t, localAddr, dp.Tags[remoteAddrTag])
+		case <-time.After(5 * time.Second):
+			t.Fatal("no datapoint from the client with a certificate")
+		}
+	}
+
+	// clients without certificate are refused
+	conn, err = tls.Dial("tcp", addr, &tls.Config{RootCAs: roots, ServerName: "relay"})
+	if err == nil {
+		// with TLS 1.3, the client finishes its handshake before the server verifies it
+		conn.Write([]byte("a.b 2 10\n"))
+		conn.Close()
+	}
+	select {
+	case dp := <-d.dps:
+		t.Fatalf("unexpected datapoint from a client without certificate: %v", dp)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func TestListenerReservedTags(t *testing.T) {
+	d := &chanDispatcher{dps: make(chan encoding.Datapoint, 2)}
+	l := NewListener("127.0.0.1:0", time.Second, 1, 0, encoding.NewPlain(false), "test", nil, false)
+	if err := l.Start(d); err != nil {
+		t.Fatal(err)
+	}
+	defer l.Stop()
+
+	conn, err := net.Dial("tcp", l.tcpWorkers[0].listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.Write([]byte("a.b;appClientCert=admin;appIpPortSrc=192.0.2.1:1234;env=prod 1 10\n"))
+	conn.Write([]byte("c.d 2 10\n"))
+	localAddr := conn.LocalAddr().String()
+	conn.Close()
+	for _, name := range []string{"a.b", "c.d"} {
+		select {
+		case dp := <-d.dps:
+			assert.Equal(t, name, dp.Name)
+			_, ok := dp.Tags[clientCertTag]
+			assert.False(t, ok, "a client without certificate can't set %s", clientCertTag)
+			assert.Equal(t, localAddr, dp.Tags[remoteAddrTag])
+			if name == "a.b" {
+				assert.Equal(t, "prod", dp.Tags["env"])
+			} else {
+				assert.Len(t, dp.Tags, 2, "the tags of a message don't leak to the next ones")
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("no datapoint from the client")
+		}
+	}
+}
+
+func TestTLSListenerInternal(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	ca := testcert.Issue(t, "ca", 1, nil)
+	caFile, certFile, keyFile := filepath.Join(dir, "ca.pem"), filepath.Join(dir, "relay.pem"), filepath.Join(dir, "relay.key")
+	testcert.Write(t, ca, caFile, "")
+	testcert.Write(t, testcert.Issue(t, "relay", 2, &ca), certFile, keyFile)
+	tlsConfig, err := NewTLSConfig(certFile, keyFile, caFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Leaf)
+	client := testcert.Issue(t, "app1", 3, &ca)
+
+	tags := encoding.Tags{clientCertTag: "admin", instanceTag: "upstream", remoteAddrTag: "192.0.2.1:1234", "env": "prod"}
+	frame := encoding.AppendInternalFrame(nil, []encoding.Datapoint{{Name: "a.b", Value: 1, Timestamp: 10, Tags: tags}})
+	for _, trustTags := range []bool{false, true} {
+		d := &chanDispatcher{dps: make(chan encoding.Datapoint, 1)}
+		l := NewListener("127.0.0.1:0", time.Second, 1, 0, encoding.NewInternal(0), "test", tlsConfig, trustTags)
+		if err := l.Start(d); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := tls.Dial("tcp", l.tcpWorkers[0].listener.Addr().String(), &tls.Config{RootCAs: roots, ServerName: "relay", Certificates: []tls.Certificate{client}})
+		if err != nil {
+			t.Fatal(err)
+		}
+		conn.Write(frame)
+		localAddr := conn.LocalAddr().String()
+		conn.Close()
+		select {
+		case dp := <-d.dps:
+			// the certificate verified by the listener can't be spoofed, even by a trusted relay
+			assert.Equal(t, "app1", dp.Tags[clientCertTag], "trust_tags %t", trustTags)
+			assert.Equal(t, "prod", dp.Tags["env"])
+			if trustTags {
+				assert.Equal(t, "upstream", dp.Tags[instanceTag])
+				assert.Equal(t, "192.0.2.1:1234", dp.Tags[remoteAddrTag])
+			} else {
+				assert.Equal(t, "test", dp.Tags[instanceTag])
+				assert.Equal(t, localAddr, dp.Tags[remoteAddrTag])
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("no datapoint from the client, trust_tags %t", trustTags)
+		}
+		l.Stop()
+	}
+}
@@ -4,22 +4,31 @@ import (
 	"bytes"
 	"fmt"
 	"regexp"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
 )
 
 type Matcher struct {
 	Prefix string `json:"prefix,omitempty"`
 	Sub    string `json:"substring,omitempty"`
 	Regex  string `json:"regex,omitempty"`
+	Tag    string `json:"tag,omitempty"` // tag expression, see tag.go for the syntax
 	// internal representation for performance optimalization
 	prefix, substring []byte
 	regex             *regexp.Regexp // compiled version of Regex
+	tag               tagExpr        // compiled version of Tag
 }
 
 func New(prefix, sub, regex string) (*Matcher, error) {
+	return NewWithTag(prefix, sub, regex, "")
+}
+
+func NewWithTag(prefix, sub, regex, tag string) (*Matcher, error) {
 	match := new(Matcher)
 	match.Prefix = prefix
 	match.Sub = sub
 	match.Regex = regex
+	match.Tag = tag
 	err := match.updateInternals()
 	if err != nil {
 		return nil, err
@@ -28,7 +37,12 @@ func New(prefix, sub, regex string) (*Matcher, error) {
 }
 
 func (m *Matcher) String() string {
-	return fmt.Sprintf("<Matcher. prefix:%q, sub: %q, regex: %q>", m.Prefix, m.Sub, m.Regex)
+	return fmt.Sprintf("<Matcher. prefix:%q, sub: %q, regex: %q, tag: %q>", m.Prefix, m.Sub, m.Regex, m.Tag)
+}
+
+// Equal returns whether both matchers have the same settings
+func (m *Matcher) Equal(o *Matcher) bool {
+	return m.Prefix == o.Prefix && m.Sub == o.Sub && m.Regex == o.Regex && m.Tag == o.Tag
 }
 
 func (m *Matcher) updateInternals() error {
@@ -41,9 +55,15 @@ func (m *Matcher) updateInternals() error {
 		}
 		m.regex = regexObj
 	}
+	tag, err := parseTagExpr(m.Tag)
+	if err != nil {
+		return err
+	}
+	m.tag = tag
 	return nil
 }
 
+// MatchString only checks the name, use MatchDatapoint to take the tag expression into account
 func (m *Matcher) MatchString(s string) bool {
 	return m.Match([]byte(s))
 }
@@ -60,3 +80,13 @@ func (m *Matcher) Match(s []byte) bool {
 	}
 	return true
 }
+
+// MatchTags checks the tag expression only
+func (m *Matcher) MatchTags(tags encoding.Tags) bool {
+	return m.tag == nil || m.tag.match(tags)
+}
+
+// MatchDatapoint checks both the name and the tags of dp
+func (m *Matcher) MatchDatapoint(dp encoding.Datapoint) bool {
+	return m.MatchTags(dp.Tags) && m.MatchString(dp.Name)
+}
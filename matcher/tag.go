@@ -0,0 +1,219 @@
+package matcher
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// tag expressions select datapoints based on their tags. the syntax has no spaces
+// so that expressions can be used as-is in imperatives:
+//
+//   key:value        the tag key is set to value
+//   key~/regex/      the value of the tag key matches regex (anything but '/' is allowed in the regex, use '\/' for a slash)
+//   key              the tag key is present
+//   !expr            negation, e.g. !key means the tag key is absent
+//   expr,expr        both expressions are true (and)
+//   expr|expr        either expression is true (or). ',' binds tighter than '|'
+//   (expr)           grouping
+//
+// e.g. `app:api,(dc:par|dc:ams),!canary` or `pool~/^(front|back)[0-9]+$/`
+
+type tagExpr interface {
+	match(tags map[string]string) bool
+}
+
+type tagEquals struct{ key, value string }
+
+type tagRegex struct {
+	key   string
+	regex *regexp.Regexp
+}
+
+type tagPresent struct{ key string }
+
+type tagNot struct{ expr tagExpr }
+
+type tagAnd []tagExpr
+
+type tagOr []tagExpr
+
+func (e tagEquals) match(tags map[string]string) bool {
+	v, ok := tags[e.key]
+	return ok && v == e.value
+}
+
+func (e tagRegex) match(tags map[string]string) bool {
+	v, ok := tags[e.key]
+	return ok && e.regex.MatchString(v)
+}
+
+func (e tagPresent) match(tags map[string]string) bool {
+	_, ok := tags[e.key]
+	return ok
+}
+
+func (e tagNot) match(tags map[string]string) bool {
+	return !e.expr.match(tags)
+}
+
+func (e tagAnd) match(tags map[string]string) bool {
+	for _, sub := range e {
+		if !sub.match(tags) {
+			return false
+		}
+	}
+	return true
+}
+
+func (e tagOr) match(tags map[string]string) bool {
+	for _, sub := range e {
+		if sub.match(tags) {
+			return true
+		}
+	}
+	return false
+}
+
+type tagParser struct {
+	expr string
+	pos  int
+}
+
+// parseTagExpr compiles a tag expression, an empty expression compiles to nil
+func parseTagExpr(expr string) (tagExpr, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	p := &tagParser{expr: expr}
+	e, err := p.or()
+	if err != nil {
+		return nil, err
+	}
+	if p.pos != len(p.expr) {
+		return nil, p.errorf("unexpected %q", p.expr[p.pos])
+	}
+	return e, nil
+}
+
+func (p *tagParser) errorf(format string, args ...interface{}) error {
+	return fmt.Errorf("invalid tag expression %q at position %d: %s", p.expr, p.pos, fmt.Sprintf(format, args...))
+}
+
+func (p *tagParser) peek() byte {
+	if p.pos >= len(p.expr) {
+		return 0
+	}
+	return p.expr[p.pos]
+}
+
+func (p *tagParser) or() (tagExpr, error) {
+	var terms tagOr
+	for {
+		t, err := p.and()
+		if err != nil {
+			return nil, err
+		}
+		terms = append(terms, t)
+		if p.peek() != '|' {
+			break
+		}
+		p.pos++
+	}
+	if len(terms) == 1 {
+		return terms[0], nil
+	}
+	return terms, nil
+}
+
+func (p *tagParser) and() (tagExpr, error) {
+	var factors tagAnd
+	for {
+		f, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		factors = append(factors, f)
+		if p.peek() != ',' {
+			break
+		}
+		p.pos++
+	}
+	if len(factors) == 1 {
+		return factors[0], nil
+	}
+	return factors, nil
+}
+
+func (p *tagParser) factor() (tagExpr, error) {
+	switch p.peek() {
+	case '!':
+		p.pos++
+		e, err := p.factor()
+		if err != nil {
+			return nil, err
+		}
+		return tagNot{e}, nil
+	case '(':
+		p.pos++
+		e, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.peek() != ')' {
+			return nil, p.errorf("missing ')'")
+		}
+		p.pos++
+		return e, nil
+	}
+	return p.predicate()
+}
+
+func (p *tagParser) predicate() (tagExpr, error) {
+	key := p.until(":~,|()!")
+	if key == "" {
+		return nil, p.errorf("expected a tag key")
+	}
+	switch p.peek() {
+	case ':':
+		p.pos++
+		return tagEquals{key, p.until(",|()")}, nil
+	case '~':
+		p.pos++
+		if p.peek() != '/' {
+			return nil, p.errorf("regex must be enclosed in slashes")
+		}
+		p.pos++
+		var re strings.Builder
+		for {
+			if p.pos >= len(p.expr) {
+				return nil, p.errorf("unterminated regex")
+			}
+			c := p.expr[p.pos]
+			p.pos++
+			if c == '/' {
+				break
+			}
+			if c == '\\' && p.peek() == '/' {
+				c = '/'
+				p.pos++
+			}
+			re.WriteByte(c)
+		}
+		regex, err := regexp.Compile(re.String())
+		if err != nil {
+			return nil, p.errorf("%s", err)
+		}
+		return tagRegex{key, regex}, nil
+	}
+	return tagPresent{key}, nil
+}
+
+// until consumes and returns the input up to the first of the stop characters
+func (p *tagParser) until(stop string) string {
+	start := p.pos
+	for p.pos < len(p.expr) && strings.IndexByte(stop, p.expr[p.pos]) == -1 {
+		p.pos++
+	}
+	return p.expr[start:p.pos]
+}
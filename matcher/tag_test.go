@@ -0,0 +1,69 @@
+package matcher
+
+import (
+	"testing"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTagExpr(t *testing.T) {
+	tags := encoding.Tags{"app": "api", "dc": "par", "pool": "front12", "path": "a/b"}
+	cases := []struct {
+		expr string
+		exp  bool
+	}{
+		{"app:api", true},
+		{"app:web", false},
+		{"missing:api", false},
+		{"app", true},
+		{"missing", false},
+		{"!missing", true},
+		{"!app", false},
+		{"app:api,dc:par", true},
+		{"app:api,dc:ams", false},
+		{"app:web|dc:par", true},
+		{"app:web|dc:ams", false},
+		{"app:web,dc:par|app:api,dc:par", true},
+		{"app:api,(dc:ams|dc:par)", true},
+		{"app:api,!(dc:ams|dc:par)", false},
+		{`pool~/^(front|back)[0-9]+$/`, true},
+		{`pool~/^back/`, false},
+		{`missing~/.*/`, false},
+		{`path~/^a\/b$/`, true},
+		{"app:", false},
+	}
+	for _, c := range cases {
+		t.Run(c.expr, func(t *testing.T) {
+			m, err := NewWithTag("", "", "", c.expr)
+			assert.NoError(t, err)
+			assert.Equal(t, c.exp, m.MatchTags(tags))
+		})
+	}
+}
+
+func TestTagExprInvalid(t *testing.T) {
+	for _, expr := range []string{"(app:api", "app:api)", "app~api", "app~/api", "app~/(/", ",app", "app|", "!"} {
+		_, err := NewWithTag("", "", "", expr)
+		assert.Error(t, err, expr)
+	}
+}
+
+func TestMatchDatapoint(t *testing.T) {
+	m, err := NewWithTag("servers.", "", "", "app:api")
+	assert.NoError(t, err)
+	assert.True(t, m.MatchDatapoint(encoding.Datapoint{Name: "servers.cpu", Tags: encoding.Tags{"app": "api"}}))
+	assert.False(t, m.MatchDatapoint(encoding.Datapoint{Name: "servers.cpu", Tags: encoding.Tags{"app": "web"}}))
+	assert.False(t, m.MatchDatapoint(encoding.Datapoint{Name: "other.cpu", Tags: encoding.Tags{"app": "api"}}))
+
+	noTag, _ := New("servers.", "", "")
+	assert.True(t, noTag.MatchDatapoint(encoding.Datapoint{Name: "servers.cpu"}))
+}
+
+func BenchmarkMatchTag(b *testing.B) {
+	matcher, _ := NewWithTag("", "", "", "app:api,(dc:ams|dc:par),!canary")
+	tags := encoding.Tags{"app": "api", "dc": "par", "carbonRelayInstance": "relay1", "appIpPortSrc": "127.0.0.1:1234"}
+	for i := 0; i < b.N; i++ {
+		matcher.MatchTags(tags)
+	}
+}
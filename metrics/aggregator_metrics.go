@@ -33,6 +33,11 @@ func NewCacheMetrics(namespace string, labels prometheus.Labels) *CacheMetrics {
 type AggregatorMetrics struct {
 	Cache                   *CacheMetrics
 	Dropped                 prometheus.Counter
+	Late                    prometheus.Counter
+	Lateness                prometheus.Histogram
+	Series                  prometheus.Gauge
+	RejectedSeries          prometheus.Counter
+	BucketMemory            prometheus.Histogram
 	lowestTimestampCounter  prometheus.Gauge
 	highestTimestampCounter prometheus.Gauge
 	highTs                  uint32
@@ -56,6 +61,38 @@ func NewAggregatorMetrics(id string, labels prometheus.Labels) *AggregatorMetric
 		Help:        "Total number of metrics dropped because of their age",
 		ConstLabels: labels,
 	})
+	am.Late = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "late_metrics_total",
+		Help:        "Total number of metrics which came too late for their bucket, handled per the late policy",
+		ConstLabels: labels,
+	})
+	am.Lateness = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "lateness_seconds",
+		Help:        "Seconds between the start of the bucket of the metrics and their arrival, metrics are late from wait seconds",
+		ConstLabels: labels,
+		Buckets:     prometheus.ExponentialBuckets(1, 2, 14),
+	})
+	am.Series = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace:   namespace,
+		Name:        "series",
+		Help:        "Number of output series with aggregations in process",
+		ConstLabels: labels,
+	})
+	am.RejectedSeries = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "rejected_series_metrics_total",
+		Help:        "Total number of metrics of new series rejected because of max_series, dropped or aggregated into the overflow series",
+		ConstLabels: labels,
+	})
+	am.BucketMemory = promauto.NewHistogram(prometheus.HistogramOpts{
+		Namespace:   namespace,
+		Name:        "bucket_memory_bytes",
+		Help:        "Estimated memory of the buckets of the functions keeping their points (percentiles, stdev), when flushed",
+		ConstLabels: labels,
+		Buckets:     prometheus.ExponentialBuckets(64, 4, 10),
+	})
 	tsVec := promauto.NewGaugeVec(prometheus.GaugeOpts{
 		Namespace:   namespace,
 		Name:        "timestamp_value",
@@ -0,0 +1,30 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// FailoverMetrics are the metrics of the failover routes, on top of their RouteMetrics
+type FailoverMetrics struct {
+	Transitions *prometheus.CounterVec
+	Active      prometheus.Gauge
+}
+
+func NewFailoverMetrics(id string) *FailoverMetrics {
+	labels := prometheus.Labels{"id": id, "type": "Failover"}
+	fm := FailoverMetrics{}
+	fm.Transitions = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   routeNamespace,
+		Name:        "failover_transitions_total",
+		Help:        "total number of switches of the active destination of a failover route, by direction (failover or failback) and destination switched to",
+		ConstLabels: labels,
+	}, []string{"direction", "destination"})
+	fm.Active = promauto.NewGauge(prometheus.GaugeOpts{
+		Namespace:   routeNamespace,
+		Name:        "failover_active_destination",
+		Help:        "index of the destination a failover route sends to, -1 when it has none",
+		ConstLabels: labels,
+	})
+	return &fm
+}
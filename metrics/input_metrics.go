@@ -0,0 +1,31 @@
+package metrics
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const inputNamespace = "input"
+
+type InputMetrics struct {
+	Invalid *prometheus.CounterVec
+}
+
+func NewInputMetrics(input string, labels prometheus.Labels) *InputMetrics {
+	namespace := inputNamespace
+	im := InputMetrics{}
+
+	if labels == nil {
+		labels = prometheus.Labels{}
+	}
+	labels["input"] = input
+
+	im.Invalid = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace:   namespace,
+		Name:        "invalid_messages_total",
+		Help:        "Total number of messages which could not be loaded, by error `type`",
+		ConstLabels: labels,
+	}, []string{"type"})
+
+	return &im
+}
@@ -58,6 +58,7 @@ type BgMetadata struct {
 	storageAggregations []storage.StorageAggregation
 	storage             storage.BgMetadataStorageConnector
 	maxConcurrentWrites chan int
+	settings            cfg.BgMetadataRouteConfig
 }
 
 // NewBloomFilterConfig creates a new BloomFilterConfig
@@ -82,7 +83,7 @@ func NewBloomFilterConfig(n uint, p float64, shardingFactor int, cache string, c
 
 // NewBgMetadataRoute creates BgMetadata, starts sharding and filtering incoming metrics.
 // additionnalCfg should be nil or *cfg.BgMetadataESConfig if elasticsearch
-func NewBgMetadataRoute(key, prefix, sub, regex, aggregationCfg, schemasCfg string, bfCfg BloomFilterConfig, storageName string, additionnalCfg interface{}) (*BgMetadata, error) {
+func NewBgMetadataRoute(key, prefix, sub, regex, tag, aggregationCfg, schemasCfg string, bfCfg BloomFilterConfig, storageName string, additionnalCfg interface{}) (*BgMetadata, error) {
 	// to make value assignments easier
 	var err error
 
@@ -91,6 +92,20 @@ func NewBgMetadataRoute(key, prefix, sub, regex, aggregationCfg, schemasCfg stri
 		shards:            make([]shard, bfCfg.ShardingFactor),
 		bfCfg:             bfCfg,
 		metricDirectories: make(chan string),
+		settings: cfg.BgMetadataRouteConfig{
+			ShardingFactor:           bfCfg.ShardingFactor,
+			FilterSize:               bfCfg.N,
+			FaultTolerance:           bfCfg.P,
+			ClearInterval:            bfCfg.ClearInterval.String(),
+			ClearWait:                bfCfg.ClearWait.String(),
+			Cache:                    bfCfg.Cache,
+			StorageAggregationConfig: aggregationCfg,
+			StorageSchemasConfig:     schemasCfg,
+			Storage:                  storageName,
+		},
+	}
+	if esCfg, ok := additionnalCfg.(*cfg.BgMetadataESConfig); ok {
+		m.settings.ESConfig = esCfg
 	}
 
 	// load schema and aggregation configuration files
@@ -170,7 +185,7 @@ func NewBgMetadataRoute(key, prefix, sub, regex, aggregationCfg, schemasCfg stri
 	go m.createMetadataDirectories()
 
 	// matcher required to initialise route.Config for routing table, othewise it will panic
-	mt, err := matcher.New(prefix, sub, regex)
+	mt, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -369,5 +384,8 @@ func (m *BgMetadata) Dispatch(dp encoding.Datapoint) {
 }
 
 func (m *BgMetadata) Snapshot() Snapshot {
-	return makeSnapshot(&m.baseRoute)
+	snap := makeSnapshot(&m.baseRoute)
+	settings := m.settings
+	snap.BgMetadata = &settings
+	return snap
 }
@@ -39,7 +39,7 @@ func testBgMetadata(t *testing.T) *BgMetadata {
 		agg    = "../examples/storage-aggregation.conf"
 	)
 	bfc := testBloomFilterConfig()
-	m, _ := NewBgMetadataRoute(key, prefix, sub, regex, agg, sch, bfc, "", nil)
+	m, _ := NewBgMetadataRoute(key, prefix, sub, regex, "", agg, sch, bfc, "", nil)
 	m.ctx, m.cancel = context.WithCancel(context.Background())
 	return m
 }
@@ -18,8 +18,8 @@ type ConsistentHashing struct {
 	Mutator *RoutingMutator
 }
 
-func NewConsistentHashing(key, prefix, sub, regex string, destinations []*dest.Destination, routingMutator *RoutingMutator) (*ConsistentHashing, error) {
-	m, err := matcher.New(prefix, sub, regex)
+func NewConsistentHashing(key, prefix, sub, regex, tag string, destinations []*dest.Destination, routingMutator *RoutingMutator) (*ConsistentHashing, error) {
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -29,7 +29,7 @@ func NewConsistentHashing(key, prefix, sub, regex string, destinations []*dest.D
 		ring,
 		routingMutator,
 	}
-	r.config.Store(baseConfig{*m, destinations})
+	r.config.Store(baseConfig{*m, nil})
 	for _, dest := range destinations {
 		r.Add(dest)
 	}
@@ -53,6 +53,33 @@ func (cs *ConsistentHashing) DelDestination(index int) error {
 	return nil
 }
 
+// ReplaceDestinations swaps the matcher and the destinations, the ring is rebuilt from the new destinations
+func (cs *ConsistentHashing) ReplaceDestinations(m matcher.Matcher, dests []*dest.Destination) {
+	cs.baseRoute.ReplaceDestinations(m, dests)
+	cs.resetRing()
+}
+
+// UpdateDestination updates the destination at index, the ring is rebuilt as its key may change
+func (cs *ConsistentHashing) UpdateDestination(index int, opts map[string]string) error {
+	err := cs.baseRoute.UpdateDestination(index, opts)
+	if err != nil {
+		return err
+	}
+	cs.resetRing()
+	return nil
+}
+
+// resetRing rebuilds the ring from the current destinations
+func (cs *ConsistentHashing) resetRing() {
+	ring := hashring.New(nil)
+	for _, d := range cs.GetDestinations() {
+		ring = ring.AddNode(d.Key)
+	}
+	cs.Lock()
+	defer cs.Unlock()
+	cs.Ring = ring
+}
+
 func (cs *ConsistentHashing) GetDestinationForNameString(name string) (*dest.Destination, error) {
 	var ok bool
 	var dName string
@@ -104,5 +131,9 @@ func (cs *ConsistentHashing) Dispatch(dp encoding.Datapoint) {
 }
 
 func (route *ConsistentHashing) Snapshot() Snapshot {
-	return makeSnapshot(&route.baseRoute)
+	snap := makeSnapshot(&route.baseRoute)
+	if route.Mutator != nil {
+		snap.RoutingMutations, snap.CacheSize = route.Mutator.Settings()
+	}
+	return snap
 }
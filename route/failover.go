@@ -0,0 +1,210 @@
+package route
+
+import (
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/metrics"
+
+	dest "github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+)
+
+// the defaults of the periods of the failover routes, used when they're 0
+const (
+	DefaultFailoverDownPeriod = 10 * time.Second
+	DefaultFailoverUpPeriod   = 60 * time.Second
+)
+
+// how often the failover routes check whether their destinations are online
+var failoverCheckInterval = time.Second
+
+// Failover sends the metrics to a single active destination, which starts as the first online one.
+// when the active destination has been offline for downPeriod, it fails over to the first destination which is online.
+// when a destination before the active one has been back online for upPeriod, it fails back to it
+type Failover struct {
+	baseRoute
+	downPeriod time.Duration
+	upPeriod   time.Duration
+	fm         *metrics.FailoverMetrics
+
+	active    atomic.Value // *dest.Destination, only set by update
+	lockState sync.Mutex   // serializes the updates, and guards states
+	states    map[*dest.Destination]failoverState
+	shutdown  chan struct{}
+}
+
+// failoverState is whether a destination is online, and since when, as seen by the checks of the route
+type failoverState struct {
+	online bool
+	since  time.Time
+}
+
+// NewFailover creates a failover route, with the default periods for the ones which are 0.
+// We will automatically run the route and the given destinations
+func NewFailover(key, prefix, sub, regex, tag string, downPeriod, upPeriod time.Duration, destinations []*dest.Destination) (Route, error) {
+	if downPeriod < 0 || upPeriod < 0 {
+		return nil, errors.New("failover periods can't be negative")
+	}
+	if downPeriod == 0 {
+		downPeriod = DefaultFailoverDownPeriod
+	}
+	if upPeriod == 0 {
+		upPeriod = DefaultFailoverUpPeriod
+	}
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
+	if err != nil {
+		return nil, err
+	}
+	r := &Failover{
+		baseRoute:  *newBaseRoute(key, "Failover"),
+		downPeriod: downPeriod,
+		upPeriod:   upPeriod,
+		fm:         metrics.NewFailoverMetrics(key),
+		states:     make(map[*dest.Destination]failoverState),
+		shutdown:   make(chan struct{}),
+	}
+	r.config.Store(baseConfig{*m, destinations})
+	r.run()
+	go r.check()
+	return r, nil
+}
+
+func (route *Failover) Dispatch(d encoding.Datapoint) {
+	conf := route.config.Load().(Config)
+
+	dests := conf.Dests()
+	dest := route.getActive()
+	if dest == nil || indexOf(dests, dest) < 0 {
+		// no destination was chosen yet, or the active one was removed: don't wait for the next check
+		if len(dests) == 0 {
+			return
+		}
+		route.lockState.Lock()
+		route.update(dests, time.Now())
+		route.lockState.Unlock()
+		dest = route.getActive()
+	}
+	if dest == nil || !dest.MatchDatapoint(d) {
+		return
+	}
+	// dest should handle this as quickly as it can
+	route.logger.Debug("route sending to dest", zap.String("destinationKey", dest.Key), zap.Stringer("datapoint", d))
+	dest.In <- d
+	route.rm.OutMetrics.Inc()
+}
+
+// getActive returns the active destination, nil if none was chosen yet
+func (route *Failover) getActive() *dest.Destination {
+	active, _ := route.active.Load().(*dest.Destination)
+	return active
+}
+
+func (route *Failover) check() {
+	ticker := time.NewTicker(failoverCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-route.shutdown:
+			return
+		case now := <-ticker.C:
+			conf := route.config.Load().(Config)
+			route.lockState.Lock()
+			route.update(conf.Dests(), now)
+			route.lockState.Unlock()
+		}
+	}
+}
+
+// update records whether dests are online at now, and switches the active destination if needed,
+// e.g. when it was removed from the route.
+// it must be called with lockState held
+func (route *Failover) update(dests []*dest.Destination, now time.Time) {
+	defer func() {
+		route.fm.Active.Set(float64(indexOf(dests, route.getActive())))
+	}()
+	states := make(map[*dest.Destination]failoverState, len(dests))
+	for _, d := range dests {
+		online := d.IsOnline()
+		state, ok := route.states[d]
+		if !ok || state.online != online {
+			state = failoverState{online, now}
+		}
+		states[d] = state
+	}
+	route.states = states
+
+	active := route.getActive()
+	activeIndex := indexOf(dests, active)
+	if activeIndex < 0 {
+		// no destination was chosen yet, or the active one was removed: take the first online, or the first
+		active = nil
+		if len(dests) > 0 {
+			active = dests[firstOnline(dests, states)]
+		}
+		route.active.Store(active)
+		return
+	}
+	for i := 0; i < activeIndex; i++ {
+		if state := states[dests[i]]; state.online && now.Sub(state.since) >= route.upPeriod {
+			route.transition(dests, i, "failback")
+			return
+		}
+	}
+	if state := states[active]; state.online || now.Sub(state.since) < route.downPeriod {
+		return
+	}
+	if i := firstOnline(dests, states); states[dests[i]].online {
+		route.transition(dests, i, "failover")
+	}
+}
+
+// transition switches the active destination to dests[index], for the given direction: failover or failback
+func (route *Failover) transition(dests []*dest.Destination, index int, direction string) {
+	route.logger.Warn("failover route switching destination",
+		zap.String("direction", direction),
+		zap.String("from", route.getActive().Key),
+		zap.String("to", dests[index].Key))
+	route.fm.Transitions.WithLabelValues(direction, dests[index].Key).Inc()
+	route.active.Store(dests[index])
+}
+
+// firstOnline returns the index of the first destination which is online, or 0 when none is
+func firstOnline(dests []*dest.Destination, states map[*dest.Destination]failoverState) int {
+	for i, d := range dests {
+		if states[d].online {
+			return i
+		}
+	}
+	return 0
+}
+
+func indexOf(dests []*dest.Destination, d *dest.Destination) int {
+	for i := range dests {
+		if dests[i] == d {
+			return i
+		}
+	}
+	return -1
+}
+
+func (route *Failover) Shutdown() error {
+	select {
+	case <-route.shutdown:
+	default:
+		close(route.shutdown)
+	}
+	return route.baseRoute.Shutdown()
+}
+
+func (route *Failover) Snapshot() Snapshot {
+	snap := makeSnapshot(&route.baseRoute)
+	snap.DownPeriod = route.downPeriod
+	snap.UpPeriod = route.upPeriod
+	return snap
+}
@@ -0,0 +1,100 @@
+package route
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/graphite-ng/carbon-relay-ng/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+// testFailover returns a failover route which doesn't run its destinations nor its checks,
+// so that the test sets whether the destinations are online, and the time of the updates
+func testFailover(t *testing.T, key string, dests []*destination.Destination) *Failover {
+	for _, d := range dests {
+		d.In = make(chan encoding.Datapoint, 10)
+	}
+	r := &Failover{
+		baseRoute:  *newBaseRoute(key, "Failover"),
+		downPeriod: 10 * time.Second,
+		upPeriod:   time.Minute,
+		fm:         metrics.NewFailoverMetrics(key),
+		states:     make(map[*destination.Destination]failoverState),
+	}
+	r.config.Store(baseConfig{matcher.Matcher{}, dests})
+	return r
+}
+
+func TestFailoverTransitions(t *testing.T) {
+	primary, secondary, tertiary := testDestination(t, "127.0.0.1:1"), testDestination(t, "127.0.0.1:2"), testDestination(t, "127.0.0.1:3")
+	dests := []*destination.Destination{primary, secondary, tertiary}
+	r := testFailover(t, "test_failover", dests)
+	start := time.Now()
+	at := func(d time.Duration) {
+		r.lockState.Lock()
+		r.update(dests, start.Add(d))
+		r.lockState.Unlock()
+	}
+
+	// the route starts on the first online destination
+	secondary.Online, tertiary.Online = true, true
+	at(0)
+	assert.True(t, secondary == r.getActive())
+
+	// the primary must stay up for upPeriod before failing back to it
+	primary.Online = true
+	at(time.Second)
+	at(50 * time.Second)
+	primary.Online = false
+	at(55 * time.Second)
+	primary.Online = true
+	at(56 * time.Second)
+	at(115 * time.Second)
+	assert.True(t, secondary == r.getActive(), "a flapping primary must not be failed back to")
+	at(116 * time.Second)
+	assert.True(t, primary == r.getActive())
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.fm.Transitions.WithLabelValues("failback", primary.Key)))
+	assert.Equal(t, 0.0, testutil.ToFloat64(r.fm.Active))
+
+	r.Dispatch(encoding.Datapoint{Name: "a.b", Value: 1, Timestamp: 10})
+	assert.Equal(t, 1, len(primary.In))
+
+	// the primary must stay down for downPeriod before failing over, to the first online destination
+	primary.Online, secondary.Online = false, false
+	at(120 * time.Second)
+	at(129 * time.Second)
+	assert.True(t, primary == r.getActive())
+	at(130 * time.Second)
+	assert.True(t, tertiary == r.getActive())
+	assert.Equal(t, 1.0, testutil.ToFloat64(r.fm.Transitions.WithLabelValues("failover", tertiary.Key)))
+	assert.Equal(t, 2.0, testutil.ToFloat64(r.fm.Active))
+
+	// with no destination online, the route stays on the active one
+	tertiary.Online = false
+	at(140 * time.Second)
+	at(200 * time.Second)
+	assert.True(t, tertiary == r.getActive())
+
+	// when the active destination is removed, the route takes the first online one right away
+	secondary.Online = true
+	r.config.Store(baseConfig{matcher.Matcher{}, dests[:2]})
+	r.Dispatch(encoding.Datapoint{Name: "a.b", Value: 2, Timestamp: 10})
+	assert.True(t, secondary == r.getActive())
+	assert.Equal(t, 1, len(secondary.In))
+	assert.Equal(t, 0, len(tertiary.In))
+}
+
+func TestNewFailover(t *testing.T) {
+	_, err := NewFailover("test_failover_negative", "", "", "", "", -time.Second, 0, nil)
+	assert.Error(t, err)
+	r, err := NewFailover("test_failover_default", "", "", "", "", 0, 0, nil)
+	if assert.NoError(t, err) {
+		defer r.Shutdown()
+		assert.Equal(t, DefaultFailoverDownPeriod, r.Snapshot().DownPeriod)
+		assert.Equal(t, DefaultFailoverUpPeriod, r.Snapshot().UpPeriod)
+	}
+}
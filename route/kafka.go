@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
 	"github.com/graphite-ng/carbon-relay-ng/encoding"
 	"github.com/graphite-ng/carbon-relay-ng/matcher"
 	"github.com/graphite-ng/carbon-relay-ng/metrics"
@@ -13,12 +14,13 @@ import (
 
 type Kafka struct {
 	baseRoute
-	router *RoutingMutator
-	Writer *kafka.Writer
-	ctx    context.Context
+	router   *RoutingMutator
+	Writer   *kafka.Writer
+	ctx      context.Context
+	settings cfg.KafkaRouteConfig
 }
 
-func NewKafkaRoute(key, prefix, sub, regex string, config kafka.WriterConfig, routingMutator *RoutingMutator) (*Kafka, error) {
+func NewKafkaRoute(key, prefix, sub, regex, tag string, config kafka.WriterConfig, routingMutator *RoutingMutator) (*Kafka, error) {
 	if err := config.Validate(); err != nil {
 		return nil, fmt.Errorf("invalid config: %s", err)
 	}
@@ -27,6 +29,7 @@ func NewKafkaRoute(key, prefix, sub, regex string, config kafka.WriterConfig, ro
 		router:    routingMutator,
 		Writer:    kafka.NewWriter(config),
 		ctx:       context.TODO(),
+		settings:  kafkaRouteConfig(config),
 	}
 	if err := metrics.RegisterKafkaMetrics(key, k.Writer); err != nil {
 		return nil, fmt.Errorf("can't register kafka metrics: %s", err)
@@ -35,7 +38,7 @@ func NewKafkaRoute(key, prefix, sub, regex string, config kafka.WriterConfig, ro
 	k.logger = k.logger.With(zap.String("kafka_topic", config.Topic))
 
 	// Don't remember why it's required
-	m, err := matcher.New(prefix, sub, regex)
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -76,5 +79,32 @@ func getKafkaHeader(tags map[string]string) []kafka.Header {
 }
 
 func (k *Kafka) Snapshot() Snapshot {
-	return makeSnapshot(&k.baseRoute)
+	snap := makeSnapshot(&k.baseRoute)
+	settings := k.settings
+	snap.Kafka = &settings
+	if k.router != nil {
+		snap.RoutingMutations, snap.CacheSize = k.router.Settings()
+	}
+	return snap
+}
+
+// kafkaRouteConfig returns the route settings which result in config
+func kafkaRouteConfig(config kafka.WriterConfig) cfg.KafkaRouteConfig {
+	codec := ""
+	if config.CompressionCodec != nil {
+		codec = config.CompressionCodec.Name()
+	}
+	_, hashBalance := config.Balancer.(*kafka.Hash)
+	return cfg.KafkaRouteConfig{
+		Brokers:       config.Brokers,
+		Topic:         config.Topic,
+		Codec:         codec,
+		BatchSize:     config.BatchSize,
+		BatchBytes:    config.BatchBytes,
+		BatchTimeout:  config.BatchTimeout,
+		RequiredAcks:  config.RequiredAcks,
+		Synchronous:   !config.Async,
+		HashBalance:   hashBalance,
+		QueueCapacity: config.QueueCapacity,
+	}
 }
@@ -0,0 +1,98 @@
+package route
+
+import (
+	"fmt"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+
+	dest "github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+)
+
+// load balancing strategies: how a loadBalance route picks the destination of a metric
+const (
+	RoundRobin  = "roundRobin"  // each destination in turn, the default
+	LeastQueued = "leastQueued" // the destination with the fewest metrics waiting to be sent
+)
+
+// LoadBalance sends each metric to one of its destinations which are online and match it, per its strategy.
+// when none of them is online, the metric goes to one of the matching destinations anyway, to be spooled or dropped there
+type LoadBalance struct {
+	baseRoute
+	strategy string
+	next     uint64 // round robin counter, also used to break the ties of leastQueued
+}
+
+// NewLoadBalance creates a loadBalance route with the given strategy, roundRobin if empty.
+// We will automatically run the route and the given destinations
+func NewLoadBalance(key, prefix, sub, regex, tag, strategy string, destinations []*dest.Destination) (Route, error) {
+	switch strategy {
+	case "":
+		strategy = RoundRobin
+	case RoundRobin, LeastQueued:
+	default:
+		return nil, fmt.Errorf("unknown load balancing strategy '%s', need %s/%s", strategy, RoundRobin, LeastQueued)
+	}
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
+	if err != nil {
+		return nil, err
+	}
+	r := &LoadBalance{baseRoute: *newBaseRoute(key, "LoadBalance"), strategy: strategy}
+	r.config.Store(baseConfig{*m, destinations})
+	r.run()
+	return r, nil
+}
+
+func (route *LoadBalance) Dispatch(d encoding.Datapoint) {
+	conf := route.config.Load().(Config)
+
+	dest := route.pick(conf.Dests(), d, true)
+	if dest == nil {
+		dest = route.pick(conf.Dests(), d, false)
+	}
+	if dest == nil {
+		return
+	}
+	// dest should handle this as quickly as it can
+	route.logger.Debug("route sending to dest", zap.String("destinationKey", dest.Key), zap.Stringer("datapoint", d))
+	dest.In <- d
+	route.rm.OutMetrics.Inc()
+}
+
+// pick returns the destination for d among the ones which match it, and are online if online is set.
+// the candidates are taken in turn from the round robin counter, so that the ties of leastQueued are spread as well
+func (route *LoadBalance) pick(dests []*dest.Destination, d encoding.Datapoint, online bool) *dest.Destination {
+	var buf [16]*dest.Destination
+	candidates := buf[:0]
+	for _, candidate := range dests {
+		if (online && !candidate.IsOnline()) || !candidate.MatchDatapoint(d) {
+			continue
+		}
+		candidates = append(candidates, candidate)
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint64(&route.next, 1) % uint64(len(candidates)))
+	picked := candidates[start]
+	if route.strategy == RoundRobin {
+		return picked
+	}
+	pickedQueued := picked.Queued()
+	for i := 1; i < len(candidates); i++ {
+		candidate := candidates[(start+i)%len(candidates)]
+		if queued := candidate.Queued(); queued < pickedQueued {
+			picked, pickedQueued = candidate, queued
+		}
+	}
+	return picked
+}
+
+func (route *LoadBalance) Snapshot() Snapshot {
+	snap := makeSnapshot(&route.baseRoute)
+	snap.Strategy = route.strategy
+	return snap
+}
@@ -0,0 +1,112 @@
+package route
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/stretchr/testify/assert"
+)
+
+// testLoadBalance returns a loadBalance route which doesn't run its destinations,
+// so that the metrics it dispatches stay in their inputs
+func testLoadBalance(t *testing.T, key, strategy string, dests []*destination.Destination) *LoadBalance {
+	for _, d := range dests {
+		if d.In == nil {
+			d.In = make(chan encoding.Datapoint, 10)
+		}
+	}
+	r := &LoadBalance{baseRoute: *newBaseRoute(key, "LoadBalance"), strategy: strategy}
+	r.config.Store(baseConfig{matcher.Matcher{}, dests})
+	return r
+}
+
+func TestNewLoadBalance(t *testing.T) {
+	_, err := NewLoadBalance("test_lb_unknown", "", "", "", "", "random", nil)
+	assert.Error(t, err)
+	r, err := NewLoadBalance("test_lb_default", "", "", "", "", "", nil)
+	if assert.NoError(t, err) {
+		assert.Equal(t, RoundRobin, r.Snapshot().Strategy)
+	}
+}
+
+func TestLoadBalanceRoundRobin(t *testing.T) {
+	dests := []*destination.Destination{testDestination(t, "127.0.0.1:1"), testDestination(t, "127.0.0.1:2"), testDestination(t, "127.0.0.1:3")}
+	r := testLoadBalance(t, "test_lb_rr", RoundRobin, dests)
+
+	// with no destination online, the metrics are spread over all of them anyway
+	for i := 0; i < 6; i++ {
+		r.Dispatch(encoding.Datapoint{Name: "a.b", Value: float64(i), Timestamp: 10})
+	}
+	for _, d := range dests {
+		assert.Equal(t, 2, d.Queued())
+	}
+}
+
+func TestLoadBalanceLeastQueued(t *testing.T) {
+	dests := []*destination.Destination{testDestination(t, "127.0.0.1:1"), testDestination(t, "127.0.0.1:2"), testDestination(t, "127.0.0.1:3")}
+	r := testLoadBalance(t, "test_lb_lq", LeastQueued, dests)
+	for i := 0; i < 3; i++ {
+		dests[0].In <- encoding.Datapoint{Name: "queued", Timestamp: 10}
+	}
+	dests[1].In <- encoding.Datapoint{Name: "queued", Timestamp: 10}
+
+	// the metrics go to the emptiest destinations, until they're level
+	for i := 0; i < 5; i++ {
+		r.Dispatch(encoding.Datapoint{Name: "a.b", Value: float64(i), Timestamp: 10})
+	}
+	assert.Equal(t, []int{3, 3, 3}, []int{dests[0].Queued(), dests[1].Queued(), dests[2].Queued()})
+}
+
+func TestLoadBalanceSkipsOffline(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			defer conn.Close()
+		}
+	}()
+
+	online := testDestination(t, ln.Addr().String())
+	online.Run()
+	defer online.Shutdown()
+	select {
+	case <-online.WaitOnline():
+	case <-time.After(5 * time.Second):
+		t.Fatal("destination didn't come online")
+	}
+	offline := testDestination(t, "127.0.0.1:1")
+
+	for _, strategy := range []string{RoundRobin, LeastQueued} {
+		r := testLoadBalance(t, "test_lb_online_"+strategy, strategy, []*destination.Destination{offline, online})
+		for i := 0; i < 4; i++ {
+			assert.True(t, online == r.pick(r.GetDestinations(), encoding.Datapoint{Name: "a.b", Timestamp: 10}, true), strategy)
+		}
+	}
+
+	// the share of an offline destination is split over the online ones
+	other := testDestination(t, ln.Addr().String())
+	other.Run()
+	defer other.Shutdown()
+	select {
+	case <-other.WaitOnline():
+	case <-time.After(5 * time.Second):
+		t.Fatal("destination didn't come online")
+	}
+	r := testLoadBalance(t, "test_lb_split", RoundRobin, []*destination.Destination{offline, online, other})
+	picks := make(map[*destination.Destination]int)
+	for i := 0; i < 6; i++ {
+		picks[r.pick(r.GetDestinations(), encoding.Datapoint{Name: "a.b", Timestamp: 10}, true)]++
+	}
+	assert.Equal(t, map[*destination.Destination]int{online: 3, other: 3}, picks)
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"sync"
 	"sync/atomic"
+	"time"
 
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
 	"github.com/graphite-ng/carbon-relay-ng/encoding"
 	"go.uber.org/zap"
 
@@ -41,6 +43,7 @@ type Route interface {
 	Dispatch(encoding.Datapoint)
 	Match(s []byte) bool
 	MatchString(s string) bool
+	MatchDatapoint(dp encoding.Datapoint) bool
 	Snapshot() Snapshot
 	Key() string
 	Type() string
@@ -48,7 +51,9 @@ type Route interface {
 	Shutdown() error
 	GetDestination(index int) (*dest.Destination, error)
 	GetDestinations() []*dest.Destination
+	Add(dest *dest.Destination)
 	DelDestination(index int) error
+	ReplaceDestinations(m matcher.Matcher, dests []*dest.Destination)
 	UpdateDestination(index int, opts map[string]string) error
 	Update(opts map[string]string) error
 }
@@ -59,6 +64,15 @@ type Snapshot struct {
 	Type    string              `json:"type"`
 	Key     string              `json:"key"`
 	Addr    string              `json:"addr,omitempty"`
+
+	// settings of specific route types
+	RoutingMutations map[string]string          `json:"routingMutations,omitempty"` // consistentHashing and kafka
+	CacheSize        int                        `json:"cacheSize,omitempty"`        // consistentHashing and kafka
+	Strategy         string                     `json:"strategy,omitempty"`         // loadBalance
+	DownPeriod       time.Duration              `json:"downPeriod,omitempty"`       // failover
+	UpPeriod         time.Duration              `json:"upPeriod,omitempty"`         // failover
+	Kafka            *cfg.KafkaRouteConfig      `json:"kafka,omitempty"`
+	BgMetadata       *cfg.BgMetadataRouteConfig `json:"bgMetadata,omitempty"`
 }
 
 type baseRoute struct {
@@ -94,8 +108,8 @@ type SendFirstMatch struct {
 
 // NewSendAllMatch creates a sendAllMatch route.
 // We will automatically run the route and the given destinations
-func NewSendAllMatch(key, prefix, sub, regex string, destinations []*dest.Destination) (Route, error) {
-	m, err := matcher.New(prefix, sub, regex)
+func NewSendAllMatch(key, prefix, sub, regex, tag string, destinations []*dest.Destination) (Route, error) {
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -107,8 +121,8 @@ func NewSendAllMatch(key, prefix, sub, regex string, destinations []*dest.Destin
 
 // NewSendFirstMatch creates a sendFirstMatch route.
 // We will automatically run the route and the given destinations
-func NewSendFirstMatch(key, prefix, sub, regex string, destinations []*dest.Destination) (Route, error) {
-	m, err := matcher.New(prefix, sub, regex)
+func NewSendFirstMatch(key, prefix, sub, regex, tag string, destinations []*dest.Destination) (Route, error) {
+	m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 	if err != nil {
 		return nil, err
 	}
@@ -128,7 +142,7 @@ func (route *SendAllMatch) Dispatch(d encoding.Datapoint) {
 	conf := route.config.Load().(Config)
 
 	for _, dest := range conf.Dests() {
-		if dest.MatchString(d.Name) {
+		if dest.MatchDatapoint(d) {
 			// dest should handle this as quickly as it can
 			route.logger.Debug("route sending to dest", zap.String("destinationKey", dest.Key), zap.Stringer("datapoint", d))
 			dest.In <- d
@@ -141,7 +155,7 @@ func (route *SendFirstMatch) Dispatch(d encoding.Datapoint) {
 	conf := route.config.Load().(Config)
 
 	for _, dest := range conf.Dests() {
-		if dest.MatchString(d.Name) {
+		if dest.MatchDatapoint(d) {
 			// dest should handle this as quickly as it can
 			zap.L().Debug("route %s sending to dest %s: %v", zap.String("destinationKey", dest.Key), zap.Stringer("datapoint", d))
 			dest.In <- d
@@ -169,6 +183,11 @@ func (route *baseRoute) Match(s []byte) bool {
 	return conf.Matcher().Match(s)
 }
 
+func (route *baseRoute) MatchDatapoint(dp encoding.Datapoint) bool {
+	conf := route.config.Load().(Config)
+	return conf.Matcher().MatchDatapoint(dp)
+}
+
 func (route *baseRoute) Flush() error {
 	conf := route.config.Load().(Config)
 
@@ -255,7 +274,7 @@ func (route *baseRoute) addDestination(dest *dest.Destination, extendConfig base
 	route.Lock()
 	defer route.Unlock()
 	conf := route.config.Load().(Config)
-	go dest.Run()
+	dest.Run()
 	newDests := append(conf.Dests(), dest)
 	newConf := extendConfig(baseConfig{*conf.Matcher(), newDests})
 	route.destMap[dest.Key] = dest
@@ -286,6 +305,50 @@ func (route *baseRoute) DelDestination(index int) error {
 	return route.delDestination(index, baseConfigExtender)
 }
 
+// replaceDestinations swaps the matcher and all the destinations.
+// destinations which are not running yet are started, the ones which are left out are shut down first,
+// so that a replacement with the same key (thus the same spool) only starts once the previous one is gone
+func (route *baseRoute) replaceDestinations(m matcher.Matcher, dests []*dest.Destination, extendConfig baseCfgExtender) {
+	route.Lock()
+	defer route.Unlock()
+	route.swapDestinations(m, dests, extendConfig)
+}
+
+// swapDestinations implements replaceDestinations, the lock must be held
+func (route *baseRoute) swapDestinations(m matcher.Matcher, dests []*dest.Destination, extendConfig baseCfgExtender) {
+	conf := route.config.Load().(Config)
+	wanted := make(map[*dest.Destination]struct{}, len(dests))
+	for _, d := range dests {
+		wanted[d] = struct{}{}
+	}
+	var running []*dest.Destination
+	for _, d := range conf.Dests() {
+		if _, ok := wanted[d]; ok {
+			running = append(running, d)
+		}
+	}
+	route.config.Store(extendConfig(baseConfig{m, running}))
+	for _, d := range conf.Dests() {
+		if _, ok := wanted[d]; !ok {
+			d.Shutdown()
+		}
+	}
+
+	destMap := make(map[string]*dest.Destination, len(dests))
+	for _, d := range dests {
+		destMap[d.Key] = d
+		if d.In == nil {
+			d.Run()
+		}
+	}
+	route.destMap = destMap
+	route.config.Store(extendConfig(baseConfig{m, dests}))
+}
+
+func (route *baseRoute) ReplaceDestinations(m matcher.Matcher, dests []*dest.Destination) {
+	route.replaceDestinations(m, dests, baseConfigExtender)
+}
+
 func (route *baseRoute) GetDestinations() []*dest.Destination {
 	conf := route.config.Load().(Config)
 	return conf.Dests()
@@ -320,10 +383,14 @@ func (route *baseRoute) update(opts map[string]string, extendConfig baseCfgExten
 	prefix := match.Prefix
 	sub := match.Sub
 	regex := match.Regex
+	tag := match.Tag
 	updateMatcher := false
 
 	for name, val := range opts {
 		switch name {
+		case "tag":
+			tag = val
+			updateMatcher = true
 		case "prefix":
 			prefix = val
 			updateMatcher = true
@@ -338,7 +405,7 @@ func (route *baseRoute) update(opts map[string]string, extendConfig baseCfgExten
 		}
 	}
 	if updateMatcher {
-		match, err := matcher.New(prefix, sub, regex)
+		match, err := matcher.NewWithTag(prefix, sub, regex, tag)
 		if err != nil {
 			return err
 		}
@@ -359,6 +426,17 @@ func (route *baseRoute) updateDestination(index int, opts map[string]string, ext
 	if index >= len(conf.Dests()) {
 		return fmt.Errorf("Invalid index %d", index)
 	}
+	if dest.NeedsCopy(opts) {
+		d, err := conf.Dests()[index].Copy(opts)
+		if err != nil {
+			return err
+		}
+		dests := make([]*dest.Destination, len(conf.Dests()))
+		copy(dests, conf.Dests())
+		dests[index] = d
+		route.swapDestinations(*conf.Matcher(), dests, extendConfig)
+		return nil
+	}
 	err := conf.Dests()[index].Update(opts)
 	if err != nil {
 		return err
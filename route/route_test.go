@@ -2,14 +2,16 @@ package route
 
 import (
 	"testing"
+	"time"
 
 	"github.com/graphite-ng/carbon-relay-ng/destination"
 	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/stretchr/testify/assert"
 )
 
 // just sending into route, no matching or sending to dest
 func BenchmarkRouteDispatchMetric(b *testing.B) {
-	route, err := NewSendAllMatch("", "", "", "", make([]*destination.Destination, 0))
+	route, err := NewSendAllMatch("", "", "", "", "", make([]*destination.Destination, 0))
 	if err != nil {
 		b.Fatal(err)
 	}
@@ -20,3 +22,52 @@ func BenchmarkRouteDispatchMetric(b *testing.B) {
 		route.Dispatch(dp)
 	}
 }
+
+func testDestination(t *testing.T, addr string) *destination.Destination {
+	d, err := destination.New("test_route", "", "", "", "", addr, "", false, false, false, false, nil, nil, destination.TLSConfig{}, time.Second, time.Second, 10, 10, 10, 10, 10, time.Second, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestUpdateDestination(t *testing.T) {
+	d := testDestination(t, "127.0.0.1:1")
+	r, err := NewSendAllMatch("test_route", "", "", "", "", []*destination.Destination{d})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown()
+
+	assert.NoError(t, r.UpdateDestination(0, map[string]string{"prefix": "foo"}))
+	assert.True(t, d == r.GetDestinations()[0], "matcher updates must keep the destination")
+	assert.Equal(t, "foo", d.GetMatcher().Prefix)
+
+	assert.Error(t, r.UpdateDestination(0, map[string]string{"flush": "-1"}))
+	assert.Error(t, r.UpdateDestination(0, map[string]string{"pickle": "maybe"}))
+	assert.True(t, d == r.GetDestinations()[0], "failed updates must keep the destination")
+
+	assert.NoError(t, r.UpdateDestination(0, map[string]string{"pickle": "true", "flush": "50", "addr": "127.0.0.1:2"}))
+	dests := r.GetDestinations()
+	if assert.Len(t, dests, 1) {
+		assert.False(t, d == dests[0], "destination must be replaced")
+		assert.True(t, dests[0].Pickle)
+		assert.Equal(t, "127.0.0.1:2", dests[0].Addr)
+		assert.Equal(t, "foo", dests[0].GetMatcher().Prefix)
+		assert.NotNil(t, dests[0].In, "replacement must be running")
+	}
+}
+
+func TestConsistentHashingUpdateDestination(t *testing.T) {
+	r, err := NewConsistentHashing("test_route", "", "", "", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Shutdown()
+	r.Add(testDestination(t, "127.0.0.1:1"))
+
+	assert.NoError(t, r.UpdateDestination(0, map[string]string{"addr": "127.0.0.1:2", "spool": "false"}))
+	node, ok := r.Ring.GetNode("foo")
+	assert.True(t, ok)
+	assert.Equal(t, r.GetDestinations()[0].Key, node)
+}
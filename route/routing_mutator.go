@@ -29,6 +29,10 @@ type RoutingMutator struct {
 	Table []*Mutator
 	cache *fastcache.Cache
 	pool  *sync.Pool
+
+	// settings it was created with
+	mutations map[string]string
+	cacheSize int
 }
 
 func NewRoutingMutator(table map[string]string, cacheSize int) (*RoutingMutator, error) {
@@ -55,9 +59,15 @@ func NewRoutingMutator(table map[string]string, cacheSize int) (*RoutingMutator,
 		sync.RWMutex{}, mutators, cache, &sync.Pool{New: func() interface{} {
 			return make([]byte, 0, 100)
 		}},
+		table, cacheSize,
 	}, nil
 }
 
+// Settings returns the mutations and the cache size rm was created with
+func (rm *RoutingMutator) Settings() (map[string]string, int) {
+	return rm.mutations, rm.cacheSize
+}
+
 func (rm *RoutingMutator) HandleString(key string) (string, bool) {
 	routingKey, ok := rm.HandleBuf([]byte(key))
 	if routingKey == nil || !ok {
@@ -140,7 +140,7 @@ func NewBgMetadataElasticSearchConnectorWithDefaults(cfg *cfg.BgMetadataESConfig
 	es, err := CreateElasticSearchClient(cfg.StorageServer, cfg.Username, cfg.Password)
 
 	if err != nil {
-		log.Fatalf("Could not create ElasticSearch connector: %w", err)
+		log.Fatalf("Could not create ElasticSearch connector: %s", err)
 	}
 
 	return NewBgMetadataElasticSearchConnector(es, prometheus.DefaultRegisterer, cfg.BulkSize, cfg.MaxRetry)
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"regexp"
+	"strings"
 
 	"gopkg.in/ini.v1"
 )
@@ -21,18 +22,29 @@ func NewStorageAggregations(storageAggregationConf string) ([]StorageAggregation
 		return aggr, err
 	}
 	for _, section := range cfg.Sections()[1:] { // first element is empty default value
-		pattern := section.KeysHash()["PATTERN"]
+		keys := keysHash(section)
+		pattern := keys["pattern"]
 		patternRegex, _ := regexp.Compile(pattern)
 		aggr = append(
 			aggr,
 			StorageAggregation{
 				ID:                section.Name(),
 				pattern:           pattern,
-				xFilesFactor:      section.KeysHash()["XFILESFACTOR"],
-				aggregationMethod: section.KeysHash()["AGGREGATIONMETHOD"],
+				xFilesFactor:      keys["xfilesfactor"],
+				aggregationMethod: keys["aggregationmethod"],
 				patternRegex:      patternRegex,
 			},
 		)
 	}
 	return aggr, nil
 }
+
+// keysHash returns the keys of the section, lower cased: keys are case insensitive, like in carbon, e.g. xFilesFactor or XFILESFACTOR.
+// we don't load the files case insensitively, as that would merge the usual [default] section into the default section of the ini file
+func keysHash(section *ini.Section) map[string]string {
+	keys := make(map[string]string)
+	for k, v := range section.KeysHash() {
+		keys[strings.ToLower(k)] = v
+	}
+	return keys
+}
@@ -7,6 +7,13 @@ import (
 	"time"
 )
 
+// defaults for the metrics which match no storage aggregation or storage schema
+const (
+	defaultAggregator   = "average"
+	defaultXFilesFactor = "0.5"
+	defaultRetention    = "60s:8d,1h:30d,1d:2y"
+)
+
 type MetricMetadata struct {
 	aggregator         string
 	carbonXfilesfactor string
@@ -45,11 +52,6 @@ func (s *stage) String() string {
 }
 
 func NewMetricMetadata(name string, schemas []StorageSchema, aggregations []StorageAggregation) MetricMetadata {
-	const (
-		defaultAggregator   = "average"
-		defaultXFilesFactor = "0.5"
-		defaultRetention    = "60s:8d,1h:30d,1d:2y"
-	)
 	aggr, schema := matchMetric(name, aggregations, schemas)
 	mm := MetricMetadata{
 		aggregator:         aggr.aggregationMethod,
@@ -91,7 +93,9 @@ func stagesFromGroups(stageGroups string) ([]stage, error) {
 	stages := []stage{}
 	for _, rs := range rawStages {
 		stageData := strings.Split(rs, ":")
-		// handle errors
+		if len(stageData) != 2 {
+			return stages, fmt.Errorf("invalid stage '%s', expected <precision>:<duration>", rs)
+		}
 		newStage, err := NewStage(stageData[0], stageData[1])
 		if err != nil {
 			return stages, fmt.Errorf("Failed to create new stage")
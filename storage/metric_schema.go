@@ -21,12 +21,13 @@ func NewStorageSchemas(storageSchemasConf string) ([]StorageSchema, error) {
 		return schemas, err
 	}
 	for _, section := range cfg.Sections()[1:] { // first element is empty default value
-		pattern, ok := section.KeysHash()["PATTERN"]
+		keys := keysHash(section)
+		pattern, ok := keys["pattern"]
 
 		if ok != true {
 			return schemas, fmt.Errorf("no pattern found in section %s in file %s", section.Name(), storageSchemasConf)
 		}
-		retentions, ok := section.KeysHash()["RETENTIONS"]
+		retentions, ok := keys["retentions"]
 
 		if ok != true {
 			return schemas, fmt.Errorf("no retention found in section %s in file %s", section.Name(), storageSchemasConf)
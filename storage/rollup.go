@@ -0,0 +1,134 @@
+package storage
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// RollupRule is how carbon writes a metric to the first stage of its retentions:
+// points are aggregated with AggregationMethod into buckets of Precision,
+// and buckets with less than XFilesFactor of their points known are dropped.
+type RollupRule struct {
+	Precision         time.Duration
+	AggregationMethod string
+	XFilesFactor      float64
+}
+
+// RollupRules looks up the RollupRule of metrics from storage-schemas.conf and storage-aggregation.conf
+type RollupRules struct {
+	schemas      []StorageSchema
+	aggregations []StorageAggregation
+	precisions   []time.Duration // precision of the first stage of each schema
+	xFilesFactor []float64       // xFilesFactor of each aggregation
+	defaults     RollupRule      // for the metrics which match no schema or no aggregation
+}
+
+// NewRollupRules loads the given storage-schemas.conf and storage-aggregation.conf files, and validates all their sections
+func NewRollupRules(storageSchemasConf, storageAggregationConf string) (*RollupRules, error) {
+	schemas, err := NewStorageSchemas(storageSchemasConf)
+	if err != nil {
+		return nil, err
+	}
+	aggregations, err := NewStorageAggregations(storageAggregationConf)
+	if err != nil {
+		return nil, err
+	}
+	r := &RollupRules{
+		schemas:      schemas,
+		aggregations: aggregations,
+		precisions:   make([]time.Duration, len(schemas)),
+		xFilesFactor: make([]float64, len(aggregations)),
+	}
+	for i, sc := range schemas {
+		if sc.patternRegex == nil {
+			return nil, fmt.Errorf("invalid pattern %q in section %s of %s", sc.pattern, sc.ID, storageSchemasConf)
+		}
+		r.precisions[i], err = firstPrecision(sc.retentions)
+		if err != nil {
+			return nil, fmt.Errorf("invalid retentions %q in section %s of %s: %s", sc.retentions, sc.ID, storageSchemasConf, err)
+		}
+	}
+	for i, ag := range aggregations {
+		if ag.patternRegex == nil {
+			return nil, fmt.Errorf("invalid pattern %q in section %s of %s", ag.pattern, ag.ID, storageAggregationConf)
+		}
+		r.xFilesFactor[i], err = parseXFilesFactor(ag.xFilesFactor)
+		if err != nil {
+			return nil, fmt.Errorf("invalid xFilesFactor %q in section %s of %s: %s", ag.xFilesFactor, ag.ID, storageAggregationConf, err)
+		}
+	}
+	r.defaults.AggregationMethod = defaultAggregator
+	r.defaults.XFilesFactor, _ = parseXFilesFactor(defaultXFilesFactor)
+	r.defaults.Precision, _ = firstPrecision(defaultRetention)
+	return r, nil
+}
+
+func firstPrecision(retentions string) (time.Duration, error) {
+	stages, err := stagesFromGroups(retentions)
+	if err != nil {
+		return 0, err
+	}
+	if stages[0].precision < time.Second {
+		return 0, fmt.Errorf("precision must be at least a second")
+	}
+	return stages[0].precision, nil
+}
+
+func parseXFilesFactor(xff string) (float64, error) {
+	if xff == "" {
+		xff = defaultXFilesFactor
+	}
+	f, err := strconv.ParseFloat(xff, 64)
+	if err != nil {
+		return 0, err
+	}
+	if f < 0 || f > 1 {
+		return 0, fmt.Errorf("must be between 0 and 1")
+	}
+	return f, nil
+}
+
+// Match returns the rule of the metric, from the first schema and the first aggregation matching its name,
+// like carbon does
+func (r *RollupRules) Match(name string) RollupRule {
+	rule := r.defaults
+	for i, sc := range r.schemas {
+		if sc.patternRegex.MatchString(name) {
+			rule.Precision = r.precisions[i]
+			break
+		}
+	}
+	for i, ag := range r.aggregations {
+		if ag.patternRegex.MatchString(name) {
+			if ag.aggregationMethod != "" {
+				rule.AggregationMethod = ag.aggregationMethod
+			}
+			rule.XFilesFactor = r.xFilesFactor[i]
+			break
+		}
+	}
+	return rule
+}
+
+// Each calls fn with every rule a metric may get, stopping at the first error, which it returns
+func (r *RollupRules) Each(fn func(RollupRule) error) error {
+	precisions := append([]time.Duration{r.defaults.Precision}, r.precisions...)
+	rules := []RollupRule{r.defaults}
+	for i, ag := range r.aggregations {
+		rule := RollupRule{AggregationMethod: ag.aggregationMethod, XFilesFactor: r.xFilesFactor[i]}
+		if rule.AggregationMethod == "" {
+			rule.AggregationMethod = defaultAggregator
+		}
+		rules = append(rules, rule)
+	}
+	for _, precision := range precisions {
+		for _, rule := range rules {
+			rule.Precision = precision
+			if err := fn(rule); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeConf(t *testing.T, dir, name, content string) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRollupRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// the usual lower and camel case keys of carbon
+	schemas := writeConf(t, dir, "storage-schemas.conf", `
+[carbon]
+pattern = ^carbon\.
+retentions = 60s:90d
+
+[default]
+pattern = .*
+retentions = 10s:1d,1m:30d
+`)
+	aggregations := writeConf(t, dir, "storage-aggregation.conf", `
+[max]
+pattern = \.max$
+xFilesFactor = 0.1
+aggregationMethod = max
+
+[unset]
+pattern = \.count$
+`)
+	rules, err := NewRollupRules(schemas, aggregations)
+	assert.Nil(t, err)
+
+	cases := []struct {
+		name string
+		exp  RollupRule
+	}{
+		{"carbon.agents.a.max", RollupRule{time.Minute, "max", 0.1}},
+		{"carbon.agents.a.avg", RollupRule{time.Minute, "average", 0.5}},
+		{"app.requests.max", RollupRule{10 * time.Second, "max", 0.1}},
+		{"app.requests.count", RollupRule{10 * time.Second, "average", 0.5}},
+	}
+	for _, c := range cases {
+		assert.Equal(t, c.exp, rules.Match(c.name), c.name)
+	}
+
+	var all []RollupRule
+	assert.Nil(t, rules.Each(func(rule RollupRule) error {
+		all = append(all, rule)
+		return nil
+	}))
+	// the defaults, and the 2 aggregations, for each of the default and the 2 schema precisions
+	assert.Len(t, all, 9)
+	assert.Contains(t, all, RollupRule{time.Minute, "average", 0.5})
+	assert.Contains(t, all, RollupRule{10 * time.Second, "max", 0.1})
+}
+
+func TestRollupRulesInvalid(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-storage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	schemas := writeConf(t, dir, "storage-schemas.conf", "[default]\npattern = .*\nretentions = 60s:1d\n")
+	aggregations := writeConf(t, dir, "storage-aggregation.conf", "[default]\npattern = .*\nxFilesFactor = 0.5\n")
+	cases := []struct {
+		schemas      string
+		aggregations string
+	}{
+		{"[default]\npattern = .*\nretentions = 60s\n", ""},
+		{"[default]\npattern = .*\nretentions = 500ms:1d\n", ""},
+		{"[default]\npattern = (\nretentions = 60s:1d\n", ""},
+		{"", "[default]\npattern = .*\nxFilesFactor = 2\n"},
+		{"", "[default]\npattern = .*\nxFilesFactor = foo\n"},
+		{"", "[default]\npattern = (\n"},
+	}
+	for _, c := range cases {
+		s, a := schemas, aggregations
+		if c.schemas != "" {
+			s = writeConf(t, dir, "invalid-schemas.conf", c.schemas)
+		}
+		if c.aggregations != "" {
+			a = writeConf(t, dir, "invalid-aggregation.conf", c.aggregations)
+		}
+		_, err := NewRollupRules(s, a)
+		assert.NotNil(t, err, c)
+	}
+	_, err = NewRollupRules(filepath.Join(dir, "missing.conf"), aggregations)
+	assert.NotNil(t, err)
+}
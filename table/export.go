@@ -0,0 +1,228 @@
+package table
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"go.uber.org/zap"
+
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
+	"github.com/graphite-ng/carbon-relay-ng/imperatives"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/graphite-ng/carbon-relay-ng/route"
+)
+
+// routeConfigTypes maps the route types to the type used in the config file
+var routeConfigTypes = map[string]string{
+	"SendAllMatch":      "sendAllMatch",
+	"SendFirstMatch":    "sendFirstMatch",
+	"ConsistentHashing": "consistentHashing",
+	"LoadBalance":       "loadBalance",
+	"Failover":          "failover",
+	"kafka":             "kafka",
+	"bg_metadata":       "bg_metadata",
+}
+
+// Export returns the configuration the table was loaded from, with its blacklist, rewriters,
+// aggregators and routes replaced by the ones of the running table, so that the changes made
+// at runtime survive a restart. Init commands are left out, as what they did is part of the table already.
+func (table *Table) Export() (cfg.Config, error) {
+	table.Lock()
+	config := table.fileConfig
+	rules := table.fileRules
+	table.Unlock()
+	snap := table.Snapshot()
+
+	config.Init.Cmds = nil
+
+	config.BlackList = make([]string, 0, len(snap.Blacklist))
+	for i, m := range snap.Blacklist {
+		entry, err := blacklistEntry(m)
+		if err != nil {
+			return cfg.Config{}, fmt.Errorf("can't export blacklist entry #%d: %s", i+1, err)
+		}
+		config.BlackList = append(config.BlackList, entry)
+	}
+
+	config.Rewriter = make([]cfg.Rewriter, 0, len(snap.Rewriters))
+	for _, rw := range snap.Rewriters {
+		config.Rewriter = append(config.Rewriter, cfg.Rewriter{Old: rw.Old, New: rw.New, Not: rw.Not, Max: rw.Max})
+	}
+
+	// the aggregators of the aggregation rules file are left out, as the file is still in the config
+	config.Aggregation = make([]cfg.Aggregation, 0, len(snap.Aggregators))
+	fromRules := make([]bool, len(rules))
+	for _, agg := range snap.Aggregators {
+		if i := findAggregation(agg, rules, fromRules); i != -1 {
+			fromRules[i] = true
+			continue
+		}
+		config.Aggregation = append(config.Aggregation, cfg.Aggregation{
+			Function: cfg.Functions(strings.Split(agg.Fun, ",")),
+			Regex:    agg.Regex,
+			Prefix:   agg.Prefix,
+			Substr:   agg.Sub,
+			Tag:      agg.Tag,
+			Format:   agg.OutFmt,
+			Cache:    agg.Cache,
+			Interval: int(agg.Interval),
+			Wait:     int(agg.Wait),
+			DropRaw:  agg.DropRaw,
+			GroupBy:  agg.GroupBy,
+			OutTags:  agg.OutTags,
+
+			Resolutions: agg.Resolutions,
+
+			Storage_schemas:      agg.StorageSchemas,
+			Storage_aggregations: agg.StorageAggregation,
+
+			Late_policy: agg.LatePolicy,
+			Late_route:  agg.LateRoute,
+
+			Max_series:      int(agg.MaxSeries),
+			Overflow_policy: agg.OverflowPolicy,
+
+			Watermark:    agg.Watermark,
+			Idle_timeout: int(agg.IdleTimeout),
+
+			Sketch_accuracy:     agg.SketchAccuracy,
+			State_dir:           agg.StateDir,
+			Checkpoint_interval: int(agg.CheckpointInterval),
+		})
+	}
+
+	config.Route = make([]cfg.Route, 0, len(snap.Routes))
+	for _, r := range snap.Routes {
+		routeConfig, err := table.routeConfig(r)
+		if err != nil {
+			return cfg.Config{}, fmt.Errorf("can't export route '%s': %s", r.Key, err)
+		}
+		config.Route = append(config.Route, routeConfig)
+	}
+	return config, nil
+}
+
+// ExportTOML returns the exported configuration as a TOML document, see Export
+func (table *Table) ExportTOML() ([]byte, error) {
+	config, err := table.Export()
+	if err != nil {
+		return nil, err
+	}
+	// the encoder quotes map keys without escaping their backslashes, which are common in the routing mutations regexes
+	routes := make([]cfg.Route, len(config.Route))
+	for i, routeConfig := range config.Route {
+		if routeConfig.RoutingMutations != nil {
+			mutations := make(map[string]string, len(routeConfig.RoutingMutations))
+			for k, v := range routeConfig.RoutingMutations {
+				mutations[strings.Replace(k, `\`, `\\`, -1)] = v
+			}
+			routeConfig.RoutingMutations = mutations
+		}
+		routes[i] = routeConfig
+	}
+	config.Route = routes
+
+	var buf bytes.Buffer
+	err = toml.NewEncoder(&buf).Encode(config)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// AutoSave writes the exported configuration to the auto_save_file of the config, if set.
+// The file is replaced atomically, failures are only logged as the running table is fine
+func (table *Table) AutoSave() {
+	table.Lock()
+	path := table.fileConfig.Auto_save_file
+	table.Unlock()
+	if path == "" {
+		return
+	}
+	data, err := table.ExportTOML()
+	if err != nil {
+		table.logger.Error("can't export the table to auto save it", zap.Error(err))
+		return
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		table.logger.Error("can't auto save the table", zap.Error(err))
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		table.logger.Error("can't auto save the table", zap.Error(err))
+		return
+	}
+	table.logger.Info("table saved", zap.String("file", path))
+}
+
+// blacklistEntry returns m in the syntax of the blacklist of the config file
+func blacklistEntry(m *matcher.Matcher) (string, error) {
+	var entries []string
+	for _, e := range []struct{ method, val string }{{"prefix", m.Prefix}, {"sub", m.Sub}, {"regex", m.Regex}, {"tag", m.Tag}} {
+		if e.val != "" {
+			entries = append(entries, e.method+" "+e.val)
+		}
+	}
+	if len(entries) != 1 {
+		return "", fmt.Errorf("a blacklist entry must have exactly one of prefix, sub, regex or tag")
+	}
+	return entries[0], nil
+}
+
+// routeConfig returns the config of the route of snap.
+// its destinations are checked to be read back into the same settings, as not every value fits the destination syntax
+func (table *Table) routeConfig(snap route.Snapshot) (cfg.Route, error) {
+	routeType, ok := routeConfigTypes[snap.Type]
+	if !ok {
+		return cfg.Route{}, fmt.Errorf("unsupported route type %s", snap.Type)
+	}
+	routeConfig := cfg.Route{
+		Key:              snap.Key,
+		Type:             routeType,
+		Prefix:           snap.Matcher.Prefix,
+		Substr:           snap.Matcher.Sub,
+		Regex:            snap.Matcher.Regex,
+		Tag:              snap.Matcher.Tag,
+		RoutingMutations: snap.RoutingMutations,
+		CacheSize:        snap.CacheSize,
+		Strategy:         snap.Strategy,
+		DownPeriod:       int(snap.DownPeriod / time.Second),
+		UpPeriod:         int(snap.UpPeriod / time.Second),
+		Kafka:            snap.Kafka,
+		BgMetadata:       snap.BgMetadata,
+	}
+	for _, d := range snap.Dests {
+		routeConfig.Destinations = append(routeConfig.Destinations, d.ConfigString())
+	}
+	if !isCarbonRoute(routeType) {
+		return routeConfig, nil
+	}
+	dests, err := imperatives.ParseDestinations(routeConfig.Destinations, table, true, snap.Key)
+	if err != nil {
+		return cfg.Route{}, err
+	}
+	if len(dests) != len(snap.Dests) {
+		return cfg.Route{}, fmt.Errorf("destinations can't be written in the config syntax")
+	}
+	for i, d := range dests {
+		if !d.Equivalent(snap.Dests[i]) {
+			return cfg.Route{}, fmt.Errorf("destination %q can't be written in the config syntax", routeConfig.Destinations[i])
+		}
+	}
+	return routeConfig, nil
+}
@@ -0,0 +1,211 @@
+package table
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
+	"github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/stretchr/testify/assert"
+)
+
+const exportConfig = `
+instance = "test"
+bad_metrics_max_age = "24h"
+aggregation_rules_file = "../examples/aggregation-rules.conf"
+blacklist = ['prefix foo', 'regex ^bar\.', 'tag env:dev']
+
+[init]
+cmds = ['addBlack sub baz']
+
+[[rewriter]]
+old = '/^old\.(.*)/'
+new = 'new.${1}'
+not = ''
+max = -1
+
+[[aggregation]]
+function = ['sum', 'p99']
+regex = '^raw\.(.*)'
+tag = 'dc:paris'
+format = 'sum.$1'
+cache = true
+interval = 10
+wait = 20
+dropRaw = true
+groupBy = ['dc']
+outTags = { aggregated_by = 'sum' }
+resolutions = ['60:.1m', '300/60']
+late_policy = 'route'
+late_route = 'first'
+max_series = 1000
+overflow_policy = 'aggregate'
+watermark = true
+idle_timeout = 300
+
+[[aggregation]]
+function = 'rollup'
+regex = '^raw\.(.*)'
+format = '$1'
+interval = 10
+wait = 120
+storage_schemas = "../examples/storage-schemas.conf"
+storage_aggregations = "../examples/storage-aggregation.conf"
+
+[[route]]
+key = 'all'
+type = 'sendAllMatch'
+prefix = 'a.'
+tag = 'env:prod|env:staging'
+destinations = ['127.0.0.1:1 prefix=a.b flush=10 reconn=20 tags=true tagsallow=dc,env spool=true spoolsleep=100', '127.0.0.1:2 tag=dc:paris pickle=true connbuf=10 iobuf=20']
+
+[[route]]
+key = 'first'
+type = 'sendFirstMatch'
+destinations = ['127.0.0.1:3 internal=true', '127.0.0.1:7 tls=true tlsservername=graphite tlsminversion=1.3']
+
+[[route]]
+key = 'ch'
+type = 'consistentHashing'
+routing_mutations = { '^(a\.b)\..*' = '${1}' }
+cache_size = 1024
+destinations = ['127.0.0.1:4:a', '127.0.0.1:5:b']
+
+[[route]]
+key = 'meta'
+type = 'bg_metadata'
+[route.bg_metadata]
+sharding_factor = 2
+filter_size = 1000
+fault_tolerance = 0.01
+clear_interval = "1h"
+storage_aggregations = "../examples/storage-aggregation.conf"
+storage_schemas = "../examples/storage-schemas.conf"
+
+[[route]]
+key = 'lb'
+type = 'loadBalance'
+strategy = 'leastQueued'
+destinations = ['127.0.0.1:8', '127.0.0.1:9']
+
+[[route]]
+key = 'ha'
+type = 'failover'
+down_period = 5
+up_period = 120
+destinations = ['127.0.0.1:10', '127.0.0.1:11']
+`
+
+func TestExportRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-export")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config, meta := decodeConfig(t, exportConfig)
+	config.Spool_dir = dir
+	config.Auto_save_file = filepath.Join(dir, "saved.toml")
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// runtime changes must be part of the export
+	m, _ := matcher.New("", "", "runtime")
+	table.AddBlacklist(m)
+	assert.NoError(t, table.AddDestination("first", testDestination(t, table, "first", "x.", "127.0.0.1:6")))
+	assert.NoError(t, table.UpdateDestination("all", 1, map[string]string{"spool": "true", "reconn": "50"}))
+	assert.NoError(t, table.UpdateRoute("first", map[string]string{"regex": "^x"}))
+
+	exported, err := table.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, exported.Init.Cmds, "init commands are part of the table already")
+	assert.Equal(t, []string{"sub baz", "prefix foo", "regex ^bar\\.", "tag env:dev", "regex runtime"}, exported.BlackList)
+
+	table.AutoSave()
+	// the new table uses the same spools
+	table.Shutdown()
+
+	newConfig := cfg.NewConfig()
+	meta, err = toml.DecodeFile(config.Auto_save_file, &newConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Empty(t, meta.Undecoded())
+	assert.Equal(t, "test", newConfig.Instance)
+
+	table2, err := InitFromConfig(newConfig, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table2.Shutdown()
+	exported2, err := table2.Export()
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, exported, exported2)
+
+	assert.Equal(t, "../examples/aggregation-rules.conf", exported2.Aggregation_rules_file)
+	assert.Len(t, table2.Snapshot().Aggregators, 5)
+	if assert.Len(t, exported2.Aggregation, 2, "the aggregations of the rules file must not be exported") {
+		assert.Equal(t, cfg.Functions{"sum", "p99"}, exported2.Aggregation[0].Function)
+		assert.Equal(t, []string{"dc"}, exported2.Aggregation[0].GroupBy)
+		assert.Equal(t, map[string]string{"aggregated_by": "sum"}, exported2.Aggregation[0].OutTags)
+		assert.Equal(t, "route", exported2.Aggregation[0].Late_policy)
+		assert.Equal(t, "first", exported2.Aggregation[0].Late_route)
+		assert.Equal(t, 1000, exported2.Aggregation[0].Max_series)
+		assert.Equal(t, "aggregate", exported2.Aggregation[0].Overflow_policy)
+		assert.True(t, exported2.Aggregation[0].Watermark)
+		assert.Equal(t, 300, exported2.Aggregation[0].Idle_timeout)
+		assert.Equal(t, "../examples/storage-schemas.conf", exported2.Aggregation[1].Storage_schemas)
+		assert.Equal(t, "../examples/storage-aggregation.conf", exported2.Aggregation[1].Storage_aggregations)
+	}
+
+	routes := table2.Snapshot().Routes
+	if assert.Len(t, routes, 6) {
+		dests := routes[0].Dests
+		if assert.Len(t, dests, 2) {
+			assert.Equal(t, "a.b", dests[0].Matcher.Prefix)
+			assert.Equal(t, []string{"dc", "env"}, dests[0].TagsAllow)
+			assert.True(t, dests[1].Spool)
+			assert.True(t, dests[1].Pickle)
+		}
+		assert.Equal(t, "^x", routes[1].Matcher.Regex)
+		if assert.Len(t, routes[1].Dests, 3) {
+			assert.Equal(t, destination.TLSConfig{Enabled: true, ServerName: "graphite", MinVersion: "1.3"}, routes[1].Dests[1].TLS)
+		}
+		assert.Equal(t, map[string]string{`^(a\.b)\..*`: "${1}"}, routes[2].RoutingMutations)
+		assert.Equal(t, "127.0.0.1:4", routes[2].Dests[0].Addr)
+		assert.Equal(t, "a", routes[2].Dests[0].Instance)
+		if assert.NotNil(t, routes[3].BgMetadata) {
+			assert.Equal(t, 2, routes[3].BgMetadata.ShardingFactor)
+		}
+		assert.Equal(t, "LoadBalance", routes[4].Type)
+		assert.Equal(t, "leastQueued", routes[4].Strategy)
+		assert.Len(t, routes[4].Dests, 2)
+		assert.Equal(t, "Failover", routes[5].Type)
+		assert.Equal(t, 5*time.Second, routes[5].DownPeriod)
+		assert.Equal(t, 120*time.Second, routes[5].UpPeriod)
+	}
+}
+
+func TestExportUnsupportedDestination(t *testing.T) {
+	config, meta := decodeConfig(t, addDestinationConfig)
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+
+	assert.NoError(t, table.AddDestination("all", testDestination(t, table, "all", "has space", "127.0.0.1:3")))
+	_, err = table.Export()
+	assert.Error(t, err)
+}
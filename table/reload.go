@@ -0,0 +1,353 @@
+package table
+
+import (
+	"fmt"
+	"reflect"
+
+	"go.uber.org/zap"
+
+	"github.com/graphite-ng/carbon-relay-ng/aggregator"
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
+	"github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/graphite-ng/carbon-relay-ng/rewriter"
+	"github.com/graphite-ng/carbon-relay-ng/route"
+)
+
+// routeUpdate is a carbon route whose matcher and destinations are set once the new table config is in place
+type routeUpdate struct {
+	route   route.Route
+	matcher matcher.Matcher
+	dests   []*destination.Destination
+}
+
+// Reload applies config to the running table, e.g. after the configuration file changed.
+// The blacklist, rewriters, aggregators and routes of config are compared with the ones of the
+// configuration the table was loaded from and only what changed is created, updated or shut down:
+//   - routes are identified by their key. a carbon route of which only the matcher or the destinations
+//     changed is updated in place, and destinations with unchanged settings keep their connection and spool.
+//   - unchanged aggregators keep running, with their pending aggregations. the aggregation rules file is read again,
+//     and its rules are handled like the aggregations of config.
+//   - entries added at runtime (init commands, admin interfaces) are kept, except routes whose key is now in config.
+//
+// Everything that can fail is done before the new table config is swapped in, so on error the table is unchanged.
+// Init commands are only applied on start.
+func (table *Table) Reload(config cfg.Config) error {
+	table.Lock()
+	defer table.Unlock()
+
+	old := table.fileConfig
+	conf := table.config.Load().(TableConfig)
+
+	if !reflect.DeepEqual(old.Init.Cmds, config.Init.Cmds) {
+		table.logger.Warn("init commands changed. they are only applied on start, restart to apply them")
+	}
+
+	// the old config was applied already, so it is valid
+	oldBlacklist, _ := table.blacklistFromConfig(old)
+	blacklist, err := table.blacklistFromConfig(config)
+	if err != nil {
+		return err
+	}
+	oldRewriters, _ := table.rewritersFromConfig(old)
+	rewriters, err := table.rewritersFromConfig(config)
+	if err != nil {
+		return err
+	}
+
+	rules, err := aggregationRules(config)
+	if err != nil {
+		return err
+	}
+	oldAggs := append(append([]cfg.Aggregation{}, old.Aggregation...), table.fileRules...)
+	newAggs := append(append([]cfg.Aggregation{}, config.Aggregation...), rules...)
+	aggregators, createdAggs, staleAggs, err := table.reloadAggregators(conf.aggregators, oldAggs, newAggs)
+	if err != nil {
+		return err
+	}
+
+	routes, updates, staleRoutes, err := table.reloadRoutes(conf.routes, old.Route, config.Route)
+	if err != nil {
+		for _, agg := range createdAggs {
+			agg.Shutdown()
+		}
+		return err
+	}
+
+	newConf := TableConfig{
+		rewriters:   append(withoutRewriters(conf.rewriters, oldRewriters), rewriters...),
+		aggregators: aggregators,
+		blacklist:   append(withoutMatchers(conf.blacklist, oldBlacklist), blacklist...),
+		routes:      routes,
+	}
+	table.config.Store(newConf)
+	table.fileConfig = config
+	table.fileRules = rules
+
+	// the stale aggregators save their checkpoint before the ones replacing them
+	// (which may use the same checkpoint file) resume it
+	for _, agg := range staleAggs {
+		agg.Shutdown()
+	}
+	for _, agg := range createdAggs {
+		agg.Start()
+	}
+
+	// shut down the routes which are gone first, so that the destinations replacing
+	// theirs (which may use the same spool) only start afterwards
+	reused := make(map[*destination.Destination]struct{})
+	for _, u := range updates {
+		for _, d := range u.dests {
+			reused[d] = struct{}{}
+		}
+	}
+	for _, r := range staleRoutes {
+		var kept []*destination.Destination
+		for _, d := range r.GetDestinations() {
+			if _, ok := reused[d]; ok {
+				kept = append(kept, d)
+			}
+		}
+		if len(kept) > 0 {
+			// the route is out of the table, only the destinations which aren't reused need to stop
+			r.ReplaceDestinations(r.Snapshot().Matcher, kept)
+			continue
+		}
+		table.logger.Info("shutting down route", zap.String("key", r.Key()))
+		err := r.Shutdown()
+		if err != nil {
+			table.logger.Warn("failed to shut down route", zap.String("key", r.Key()), zap.Error(err))
+		}
+	}
+
+	for _, u := range updates {
+		u.route.ReplaceDestinations(u.matcher, u.dests)
+	}
+
+	table.logger.Info("table reloaded",
+		zap.Int("aggregatorsStarted", len(createdAggs)),
+		zap.Int("aggregatorsStopped", len(staleAggs)),
+		zap.Int("routesUpdated", len(updates)),
+		zap.Int("routesStopped", len(staleRoutes)),
+	)
+	return nil
+}
+
+// reloadAggregators returns the aggregators of the new table config: the ones added at runtime, followed by
+// the ones of newConfigs, which are reused from the running ones when unchanged.
+// it also returns the aggregators it created, which are paused: to start after the swap, or shut down if the reload fails,
+// and the ones to shut down after the swap
+func (table *Table) reloadAggregators(current []*aggregator.Aggregator, oldConfigs, newConfigs []cfg.Aggregation) (aggs, created, stale []*aggregator.Aggregator, err error) {
+	var fromConfig []*aggregator.Aggregator
+	claimed := make([]bool, len(oldConfigs))
+	for _, agg := range current {
+		i := findAggregation(agg, oldConfigs, claimed)
+		if i == -1 {
+			aggs = append(aggs, agg)
+			continue
+		}
+		claimed[i] = true
+		fromConfig = append(fromConfig, agg)
+	}
+
+	reused := make([]bool, len(fromConfig))
+	for i, aggConfig := range newConfigs {
+		if j := findAggregator(aggConfig, fromConfig, reused); j != -1 {
+			reused[j] = true
+			aggs = append(aggs, fromConfig[j])
+			continue
+		}
+		agg, err := aggregator.NewPaused(aggregatorOptions(aggConfig), table.In, table.LateIn)
+		if err != nil {
+			for _, agg := range created {
+				agg.Shutdown()
+			}
+			table.logger.Error("could not add aggregation", zap.Error(err))
+			return nil, nil, nil, fmt.Errorf("could not add aggregation #%d", i+1)
+		}
+		created = append(created, agg)
+		aggs = append(aggs, agg)
+	}
+
+	for i, agg := range fromConfig {
+		if !reused[i] {
+			stale = append(stale, agg)
+		}
+	}
+	return aggs, created, stale, nil
+}
+
+// reloadRoutes returns the routes of the new table config: the running routes keep their position,
+// the ones removed from the config are left out, and the ones added to the config are appended.
+// the returned updates must be applied once the new table config is in place, as well as shutting down the stale routes.
+// on error, all the routes it created are shut down
+func (table *Table) reloadRoutes(current []route.Route, oldConfigs, newConfigs []cfg.Route) (routes []route.Route, updates []routeUpdate, stale []route.Route, err error) {
+	oldByKey := make(map[string]cfg.Route, len(oldConfigs))
+	for _, routeConfig := range oldConfigs {
+		oldByKey[routeConfig.Key] = routeConfig
+	}
+	currentByKey := make(map[string]route.Route, len(current))
+	for _, r := range current {
+		if _, ok := currentByKey[r.Key()]; !ok {
+			currentByKey[r.Key()] = r
+		}
+	}
+
+	var created []route.Route
+	fail := func(err error) ([]route.Route, []routeUpdate, []route.Route, error) {
+		for _, r := range created {
+			r.Shutdown()
+		}
+		return nil, nil, nil, err
+	}
+
+	replacements := make(map[string]route.Route, len(newConfigs))
+	for _, routeConfig := range newConfigs {
+		if _, ok := replacements[routeConfig.Key]; ok {
+			return fail(fmt.Errorf("duplicate route key '%s'", routeConfig.Key))
+		}
+		cur := currentByKey[routeConfig.Key]
+		oldConfig, inOld := oldByKey[routeConfig.Key]
+		if cur != nil && inOld && reflect.DeepEqual(oldConfig, routeConfig) {
+			replacements[routeConfig.Key] = cur
+			continue
+		}
+
+		dests, err := table.routeDestinations(routeConfig)
+		if err != nil {
+			return fail(err)
+		}
+		r := cur
+		if cur == nil || !inOld || !isCarbonRoute(routeConfig.Type) || !sameRouteSettings(oldConfig, routeConfig) {
+			r, err = table.newRoute(routeConfig, nil)
+			if err != nil {
+				return fail(err)
+			}
+			created = append(created, r)
+			if cur != nil {
+				stale = append(stale, cur)
+			}
+		}
+		replacements[routeConfig.Key] = r
+
+		if isCarbonRoute(routeConfig.Type) {
+			m, err := matcher.NewWithTag(routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag)
+			if err != nil {
+				return fail(fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err))
+			}
+			if cur != nil {
+				dests = reuseDestinations(dests, cur.GetDestinations())
+			}
+			updates = append(updates, routeUpdate{r, *m, dests})
+		}
+		table.logger.Info("route changed", zap.String("routeKey", routeConfig.Key), zap.Bool("rebuilt", r != cur))
+	}
+
+	placed := make(map[string]bool, len(replacements))
+	for _, r := range current {
+		replacement, ok := replacements[r.Key()]
+		if !ok {
+			if _, inOld := oldByKey[r.Key()]; inOld {
+				stale = append(stale, r)
+			} else {
+				routes = append(routes, r)
+			}
+			continue
+		}
+		if !placed[r.Key()] {
+			routes = append(routes, replacement)
+			placed[r.Key()] = true
+			continue
+		}
+		// another route with the key of a route which is already placed
+		stale = append(stale, r)
+	}
+	for _, routeConfig := range newConfigs {
+		if !placed[routeConfig.Key] {
+			routes = append(routes, replacements[routeConfig.Key])
+		}
+	}
+	return routes, updates, stale, nil
+}
+
+// sameRouteSettings returns whether both route configs only differ by their matcher and destinations
+func sameRouteSettings(a, b cfg.Route) bool {
+	a.Prefix, a.Substr, a.Regex, a.Tag, a.Destinations = "", "", "", "", nil
+	b.Prefix, b.Substr, b.Regex, b.Tag, b.Destinations = "", "", "", "", nil
+	return reflect.DeepEqual(a, b)
+}
+
+// reuseDestinations replaces the destinations of dests by an equivalent running one, when there is one
+func reuseDestinations(dests, running []*destination.Destination) []*destination.Destination {
+	reused := make([]bool, len(running))
+	out := make([]*destination.Destination, len(dests))
+	for i, d := range dests {
+		out[i] = d
+		for j, r := range running {
+			if !reused[j] && r.Equivalent(d) {
+				reused[j] = true
+				out[i] = r
+				break
+			}
+		}
+	}
+	return out
+}
+
+func aggregationEquivalent(agg *aggregator.Aggregator, aggConfig cfg.Aggregation) bool {
+	return agg.Equivalent(aggregatorOptions(aggConfig))
+}
+
+// findAggregation returns the index of the first unclaimed config agg was created from, or -1
+func findAggregation(agg *aggregator.Aggregator, configs []cfg.Aggregation, claimed []bool) int {
+	for i, aggConfig := range configs {
+		if !claimed[i] && aggregationEquivalent(agg, aggConfig) {
+			return i
+		}
+	}
+	return -1
+}
+
+// findAggregator returns the index of the first unclaimed aggregator created from aggConfig, or -1
+func findAggregator(aggConfig cfg.Aggregation, aggs []*aggregator.Aggregator, claimed []bool) int {
+	for i, agg := range aggs {
+		if !claimed[i] && aggregationEquivalent(agg, aggConfig) {
+			return i
+		}
+	}
+	return -1
+}
+
+// withoutMatchers returns the matchers of list which are not in remove. every entry of remove is only removed once
+func withoutMatchers(list, remove []*matcher.Matcher) []*matcher.Matcher {
+	removed := make([]bool, len(remove))
+	out := make([]*matcher.Matcher, 0, len(list))
+outer:
+	for _, m := range list {
+		for i, r := range remove {
+			if !removed[i] && m.Equal(r) {
+				removed[i] = true
+				continue outer
+			}
+		}
+		out = append(out, m)
+	}
+	return out
+}
+
+// withoutRewriters returns the rewriters of list which are not in remove. every entry of remove is only removed once
+func withoutRewriters(list, remove []rewriter.RW) []rewriter.RW {
+	removed := make([]bool, len(remove))
+	out := make([]rewriter.RW, 0, len(list))
+outer:
+	for _, rw := range list {
+		for i, r := range remove {
+			if !removed[i] && rw.Old == r.Old && rw.New == r.New && rw.Not == r.Not && rw.Max == r.Max {
+				removed[i] = true
+				continue outer
+			}
+		}
+		out = append(out, rw)
+	}
+	return out
+}
@@ -0,0 +1,272 @@
+package table
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/BurntSushi/toml"
+	"github.com/graphite-ng/carbon-relay-ng/cfg"
+	"github.com/graphite-ng/carbon-relay-ng/encoding"
+	"github.com/graphite-ng/carbon-relay-ng/matcher"
+	"github.com/stretchr/testify/assert"
+)
+
+const reloadConfigBefore = `
+bad_metrics_max_age = "24h"
+blacklist = ['prefix foo', 'sub bar']
+
+[[rewriter]]
+old = 'old'
+new = 'new'
+not = ''
+max = -1
+
+[[aggregation]]
+function = 'sum'
+regex = '^raw\.(.*)'
+format = 'sum.$1'
+interval = 10
+wait = 20
+
+[[aggregation]]
+function = 'avg'
+regex = '^raw\.(.*)'
+format = 'avg.$1'
+interval = 10
+wait = 20
+
+[[route]]
+key = 'updated'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:1 flush=10', '127.0.0.1:2']
+
+[[route]]
+key = 'unchanged'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:3']
+
+[[route]]
+key = 'removed'
+type = 'sendFirstMatch'
+destinations = ['127.0.0.1:4']
+
+[[route]]
+key = 'rebuilt'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:5', '127.0.0.1:6']
+`
+
+const reloadConfigAfter = `
+bad_metrics_max_age = "24h"
+blacklist = ['prefix foo', 'tag env:dev']
+
+[[aggregation]]
+function = 'sum'
+regex = '^raw\.(.*)'
+format = 'sum.$1'
+interval = 10
+wait = 20
+
+[[aggregation]]
+function = 'max'
+regex = '^raw\.(.*)'
+format = 'max.$1'
+interval = 10
+wait = 20
+
+[[route]]
+key = 'updated'
+type = 'sendAllMatch'
+prefix = 'foo'
+destinations = ['127.0.0.1:1 flush=10', '127.0.0.1:7']
+
+[[route]]
+key = 'unchanged'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:3']
+
+[[route]]
+key = 'rebuilt'
+type = 'sendFirstMatch'
+destinations = ['127.0.0.1:5', '127.0.0.1:8']
+
+[[route]]
+key = 'added'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:9']
+`
+
+func decodeConfig(t *testing.T, data string) (cfg.Config, toml.MetaData) {
+	config := cfg.NewConfig()
+	meta, err := toml.Decode(data, &config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return config, meta
+}
+
+func TestReload(t *testing.T) {
+	config, meta := decodeConfig(t, reloadConfigBefore)
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+	runtimeBlack, _ := matcher.New("runtime", "", "")
+	table.AddBlacklist(runtimeBlack)
+
+	before := table.config.Load().(TableConfig)
+	updated := table.GetRoute("updated")
+	keptDest := updated.GetDestinations()[0]
+	unchanged := table.GetRoute("unchanged")
+	rebuilt := table.GetRoute("rebuilt")
+	rebuiltDest := rebuilt.GetDestinations()[0]
+
+	config, _ = decodeConfig(t, reloadConfigAfter)
+	assert.NoError(t, table.Reload(config))
+	after := table.config.Load().(TableConfig)
+
+	assert.Len(t, after.rewriters, 0)
+	if assert.Len(t, after.blacklist, 3) {
+		assert.Equal(t, runtimeBlack, after.blacklist[0])
+		assert.Equal(t, "foo", after.blacklist[1].Prefix)
+		assert.Equal(t, "env:dev", after.blacklist[2].Tag)
+	}
+
+	if assert.Len(t, after.aggregators, 2) {
+		assert.Equal(t, before.aggregators[0], after.aggregators[0], "unchanged aggregator must be kept")
+		assert.Equal(t, "max", after.aggregators[1].Fun)
+	}
+
+	var keys []string
+	for _, r := range after.routes {
+		keys = append(keys, r.Key())
+	}
+	assert.Equal(t, []string{"updated", "unchanged", "rebuilt", "added"}, keys)
+
+	assert.True(t, updated == table.GetRoute("updated"), "updated route must be updated in place")
+	assert.Equal(t, "foo", updated.Snapshot().Matcher.Prefix)
+	dests := updated.GetDestinations()
+	if assert.Len(t, dests, 2) {
+		assert.True(t, keptDest == dests[0], "unchanged destination must be kept")
+		assert.Equal(t, "127.0.0.1:7", dests[1].Addr)
+		assert.NotNil(t, dests[1].In, "new destination must be running")
+	}
+
+	assert.True(t, unchanged == table.GetRoute("unchanged"), "unchanged route must be kept")
+
+	newRebuilt := table.GetRoute("rebuilt")
+	assert.False(t, rebuilt == newRebuilt, "route which changed type must be rebuilt")
+	assert.Equal(t, "SendFirstMatch", newRebuilt.Type())
+	dests = newRebuilt.GetDestinations()
+	if assert.Len(t, dests, 2) {
+		assert.True(t, rebuiltDest == dests[0], "unchanged destination must move to the rebuilt route")
+	}
+}
+
+func TestReloadError(t *testing.T) {
+	config, meta := decodeConfig(t, reloadConfigBefore)
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+	before := table.config.Load().(TableConfig)
+
+	config, _ = decodeConfig(t, reloadConfigAfter)
+	config.Route[3].Regex = "("
+	assert.Error(t, table.Reload(config))
+
+	after := table.config.Load().(TableConfig)
+	assert.Equal(t, before.aggregators, after.aggregators)
+	assert.Equal(t, before.routes, after.routes)
+	assert.Len(t, after.blacklist, 2)
+	assert.Len(t, after.rewriters, 1)
+}
+
+func TestReloadAggregationRules(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	rulesFile := filepath.Join(dir, "aggregation-rules.conf")
+	writeRules := func(rules string) {
+		if err := ioutil.WriteFile(rulesFile, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	writeRules("<app>.all.requests (60) = sum <app>.*.requests\n<app>.all.errors (60) = sum <app>.*.errors\n")
+	config, meta := decodeConfig(t, reloadConfigBefore)
+	config.Aggregation_rules_file = rulesFile
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+	before := table.config.Load().(TableConfig)
+	if !assert.Len(t, before.aggregators, 4) {
+		return
+	}
+
+	writeRules("<app>.all.requests (60) = sum <app>.*.requests\n<app>.max.latency (60) = max <app>.*.latency\n")
+	assert.NoError(t, table.Reload(config))
+	after := table.config.Load().(TableConfig)
+	if assert.Len(t, after.aggregators, 4) {
+		assert.True(t, before.aggregators[2] == after.aggregators[2], "unchanged rule must keep its aggregator")
+		assert.Equal(t, "max", after.aggregators[3].Fun)
+		assert.Equal(t, "${app}.max.latency", after.aggregators[3].OutFmt)
+	}
+
+	writeRules("<app>.all.requests (60) = stdev <app>.*.requests\n")
+	assert.Error(t, table.Reload(config), "invalid rules must fail the reload")
+	assert.Equal(t, after.aggregators, table.config.Load().(TableConfig).aggregators)
+}
+
+func TestReloadCheckpoint(t *testing.T) {
+	dir, err := ioutil.TempDir("", "carbon-relay-ng-reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	config, meta := decodeConfig(t, reloadConfigBefore)
+	config.Aggregation = config.Aggregation[:1]
+	config.Aggregation[0].State_dir = dir
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+	agg := table.config.Load().(TableConfig).aggregators[0]
+	agg.AddMaybe(encoding.Datapoint{Name: "raw.a", Value: 1, Timestamp: uint64(time.Now().Unix())})
+	checkpoints := func() []string {
+		files, err := filepath.Glob(filepath.Join(dir, "*.gob"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return files
+	}
+
+	// the wait isn't part of the name of the checkpoint, so the replacing aggregator resumes the same file
+	config, _ = decodeConfig(t, reloadConfigBefore)
+	config.Aggregation = config.Aggregation[:1]
+	config.Aggregation[0].State_dir = dir
+	config.Aggregation[0].Wait = 30
+	config.Route[0].Regex = "("
+	assert.Error(t, table.Reload(config))
+	assert.Len(t, checkpoints(), 0, "an aggregator created by a failed reload must not save a checkpoint")
+
+	config.Route[0].Regex = ""
+	assert.NoError(t, table.Reload(config))
+	aggs := table.config.Load().(TableConfig).aggregators
+	if assert.Len(t, aggs, 1) {
+		assert.False(t, agg == aggs[0])
+		assert.Equal(t, 1, aggs[0].Snapshot().Series, "the replacing aggregator must resume the aggregations of the stale one")
+	}
+	assert.Len(t, checkpoints(), 0, "the checkpoint must be removed once resumed")
+}
@@ -19,6 +19,7 @@ import (
 	"github.com/graphite-ng/carbon-relay-ng/aggregator"
 	"github.com/graphite-ng/carbon-relay-ng/badmetrics"
 	"github.com/graphite-ng/carbon-relay-ng/cfg"
+	"github.com/graphite-ng/carbon-relay-ng/destination"
 	"github.com/graphite-ng/carbon-relay-ng/imperatives"
 	"github.com/graphite-ng/carbon-relay-ng/matcher"
 	"github.com/graphite-ng/carbon-relay-ng/metrics"
@@ -38,9 +39,14 @@ type Table struct {
 	config     atomic.Value // for reading and writing
 	SpoolDir   string
 	In         chan encoding.Datapoint `json:"-"` // channel api to trade in some performance for encapsulation, for aggregators
+	// late points of the aggregators with the route late policy, see DispatchLate
+	LateIn     chan aggregator.LatePoint `json:"-"`
 	bad        *badmetrics.BadMetrics
 	tm         *metrics.TableMetrics
 	logger     *zap.Logger
+	fileConfig cfg.Config // config the table was initialized or last reloaded from, see Reload
+	// aggregations of the aggregation rules file of fileConfig
+	fileRules []cfg.Aggregation
 }
 
 type TableSnapshot struct {
@@ -57,9 +63,12 @@ func New(config cfg.Config) *Table {
 		atomic.Value{},
 		config.Spool_dir,
 		make(chan encoding.Datapoint),
+		make(chan aggregator.LatePoint),
 		nil,
 		metrics.NewTableMetrics(),
 		zap.L(),
+		config,
+		nil,
 	}
 
 	t.config.Store(TableConfig{
@@ -74,6 +83,11 @@ func New(config cfg.Config) *Table {
 			t.DispatchAggregate(dp)
 		}
 	}()
+	go func() {
+		for lp := range t.LateIn {
+			t.DispatchLate(lp)
+		}
+	}()
 	return t
 }
 
@@ -81,6 +95,10 @@ func (table *Table) GetIn() chan encoding.Datapoint {
 	return table.In
 }
 
+func (table *Table) GetLateIn() chan aggregator.LatePoint {
+	return table.LateIn
+}
+
 func (table *Table) GetSpoolDir() string {
 	return table.SpoolDir
 }
@@ -109,7 +127,7 @@ func (table *Table) Dispatch(dp encoding.Datapoint) {
 	conf := table.config.Load().(TableConfig)
 
 	for _, matcher := range conf.blacklist {
-		if matcher.MatchString(dp.Name) {
+		if matcher.MatchDatapoint(dp) {
 			table.tm.Unrouted.WithLabelValues(metrics.TableErrorTypeBlacklist).Inc()
 			tableLogger.Debug("table dropped, matched blacklist entry", zap.Stringer("matcher", matcher))
 			return
@@ -135,7 +153,7 @@ func (table *Table) Dispatch(dp encoding.Datapoint) {
 	routed := false
 
 	for _, route := range conf.routes {
-		if route.MatchString(dp.Name) {
+		if route.MatchDatapoint(dp) {
 			routed = true
 			tableLogger.Debug("table sending to route")
 			route.Dispatch(dp)
@@ -159,7 +177,7 @@ func (table *Table) DispatchAggregate(dp encoding.Datapoint) {
 	tableLogger.Debug("table received aggregate packet")
 
 	for _, route := range conf.routes {
-		if route.MatchString(dp.Name) {
+		if route.MatchDatapoint(dp) {
 			routed = true
 			tableLogger.Debug("table sending to route")
 			route.Dispatch(dp)
@@ -173,9 +191,30 @@ func (table *Table) DispatchAggregate(dp encoding.Datapoint) {
 
 }
 
+// DispatchLate dispatches a point which came too late for its aggregator to the route of its key, whatever the matcher of the route
+func (table *Table) DispatchLate(lp aggregator.LatePoint) {
+	conf := table.config.Load().(TableConfig)
+	for _, route := range conf.routes {
+		if route.Key() == lp.Route {
+			route.Dispatch(lp.Datapoint)
+			return
+		}
+	}
+	table.tm.Unrouted.WithLabelValues(metrics.TableErrorTypeUnroutable).Inc()
+	table.logger.Debug("unrouteable late point, no such route", zap.String("routeKey", lp.Route), zap.Stringer("datapoint", lp.Datapoint))
+}
+
 func (table *Table) IncNumInvalid() {
 	table.tm.In.Inc()
-	table.tm.Unrouted.WithLabelValues(metrics.TableErrorTypeInvalid)
+	table.tm.Unrouted.WithLabelValues(metrics.TableErrorTypeInvalid).Inc()
+}
+
+// AddBad counts a message which an input failed to load as invalid, and records it in the bad metrics
+func (table *Table) AddBad(input, remoteAddr, metric string, msg []byte, err error) {
+	table.IncNumInvalid()
+	if table.bad != nil {
+		table.bad.Add(input, remoteAddr, metric, msg, err)
+	}
 }
 
 // to view the state of the table/route at any point in time
@@ -316,6 +355,32 @@ func (table *Table) DelDestination(key string, index int) error {
 	return route.DelDestination(index)
 }
 
+// AddDestination adds dest to the carbon route with the given key and runs it.
+// consistent hashing routes don't support destination matchers
+func (table *Table) AddDestination(key string, dest *destination.Destination) error {
+	r := table.GetRoute(key)
+	if r == nil {
+		return fmt.Errorf("Invalid route for %v", key)
+	}
+	switch r.(type) {
+	case *route.SendAllMatch, *route.SendFirstMatch, *route.LoadBalance, *route.Failover:
+	case *route.ConsistentHashing:
+		m := dest.GetMatcher()
+		if m.Prefix != "" || m.Sub != "" || m.Regex != "" || m.Tag != "" {
+			return fmt.Errorf("route %v doesn't support destination matchers", key)
+		}
+	default:
+		return fmt.Errorf("route %v of type %v doesn't support adding destinations", key, r.Type())
+	}
+	for _, d := range r.GetDestinations() {
+		if d.Key == dest.Key {
+			return fmt.Errorf("route %v already has destination %v", key, dest.Key)
+		}
+	}
+	r.Add(dest)
+	return nil
+}
+
 func (table *Table) DelRewriter(id int) error {
 	table.Lock()
 	defer table.Unlock()
@@ -568,15 +633,29 @@ func (table *Table) InitCmd(config cfg.Config) error {
 }
 
 func (table *Table) InitBlacklist(config cfg.Config) error {
+	blacklist, err := table.blacklistFromConfig(config)
+	if err != nil {
+		return err
+	}
+	for _, m := range blacklist {
+		table.AddBlacklist(m)
+	}
+
+	return nil
+}
+
+func (table *Table) blacklistFromConfig(config cfg.Config) ([]*matcher.Matcher, error) {
+	blacklist := make([]*matcher.Matcher, 0, len(config.BlackList))
 	for i, entry := range config.BlackList {
 		parts := strings.SplitN(entry, " ", 2)
 		if len(parts) < 2 {
-			return fmt.Errorf("invalid blacklist cmd #%d", i+1)
+			return nil, fmt.Errorf("invalid blacklist cmd #%d", i+1)
 		}
 
 		prefix := ""
 		sub := ""
 		regex := ""
+		tag := ""
 
 		switch parts[0] {
 		case "prefix":
@@ -585,25 +664,27 @@ func (table *Table) InitBlacklist(config cfg.Config) error {
 			sub = parts[1]
 		case "regex":
 			regex = parts[1]
+		case "tag":
+			tag = parts[1]
 		default:
-			return fmt.Errorf("invalid blacklist method for cmd #%d: %s", i+1, parts[1])
+			return nil, fmt.Errorf("invalid blacklist method for cmd #%d: %s", i+1, parts[1])
 		}
 
-		m, err := matcher.New(prefix, sub, regex)
+		m, err := matcher.NewWithTag(prefix, sub, regex, tag)
 		if err != nil {
 			table.logger.Error("could not apply blacklist cmd", zap.Error(err))
-			return fmt.Errorf("could not apply blacklist cmd #%d", i+1)
+			return nil, fmt.Errorf("could not apply blacklist cmd #%d", i+1)
 		}
 
-		table.AddBlacklist(m)
+		blacklist = append(blacklist, m)
 	}
 
-	return nil
+	return blacklist, nil
 }
 
 func (table *Table) InitAggregation(config cfg.Config) error {
 	for i, aggConfig := range config.Aggregation {
-		agg, err := aggregator.New(aggConfig.Function, aggConfig.Regex, aggConfig.Prefix, aggConfig.Substr, aggConfig.Format, aggConfig.Cache, uint(aggConfig.Interval), uint(aggConfig.Wait), aggConfig.DropRaw, table.In)
+		agg, err := table.newAggregator(aggConfig)
 		if err != nil {
 			table.logger.Error("could not add aggregation", zap.Error(err))
 			return fmt.Errorf("could not add aggregation #%d", i+1)
@@ -612,216 +693,319 @@ func (table *Table) InitAggregation(config cfg.Config) error {
 		table.AddAggregator(agg)
 	}
 
+	rules, err := aggregationRules(config)
+	if err != nil {
+		return err
+	}
+	for i, aggConfig := range rules {
+		agg, err := table.newAggregator(aggConfig)
+		if err != nil {
+			table.logger.Error("could not add aggregation rule", zap.Error(err))
+			return fmt.Errorf("could not add aggregation rule #%d of %s", i+1, config.Aggregation_rules_file)
+		}
+
+		table.AddAggregator(agg)
+	}
+	table.fileRules = rules
+
 	return nil
 }
 
+// aggregationRules returns the aggregations of the aggregation rules file of config, if any
+func aggregationRules(config cfg.Config) ([]cfg.Aggregation, error) {
+	if config.Aggregation_rules_file == "" {
+		return nil, nil
+	}
+	rules, err := cfg.ReadAggregationRules(config.Aggregation_rules_file)
+	if err != nil {
+		return nil, fmt.Errorf("could not read aggregation rules: %s", err)
+	}
+	return rules, nil
+}
+
+func (table *Table) newAggregator(aggConfig cfg.Aggregation) (*aggregator.Aggregator, error) {
+	return aggregator.New(aggregatorOptions(aggConfig), table.In, table.LateIn)
+}
+
+// aggregatorOptions returns the options of the aggregator of aggConfig
+func aggregatorOptions(aggConfig cfg.Aggregation) aggregator.Options {
+	return aggregator.Options{
+		Fun:                aggConfig.Function.String(),
+		Regex:              aggConfig.Regex,
+		Prefix:             aggConfig.Prefix,
+		Sub:                aggConfig.Substr,
+		Tag:                aggConfig.Tag,
+		OutFmt:             aggConfig.Format,
+		Cache:              aggConfig.Cache,
+		Interval:           uint(aggConfig.Interval),
+		Wait:               uint(aggConfig.Wait),
+		DropRaw:            aggConfig.DropRaw,
+		GroupBy:            aggConfig.GroupBy,
+		OutTags:            aggConfig.OutTags,
+		SketchAccuracy:     aggConfig.Sketch_accuracy,
+		StateDir:           aggConfig.State_dir,
+		CheckpointInterval: uint(aggConfig.Checkpoint_interval),
+		Resolutions:        aggConfig.Resolutions,
+		StorageSchemas:     aggConfig.Storage_schemas,
+		StorageAggregation: aggConfig.Storage_aggregations,
+		LatePolicy:         aggConfig.Late_policy,
+		LateRoute:          aggConfig.Late_route,
+		MaxSeries:          uint(aggConfig.Max_series),
+		OverflowPolicy:     aggConfig.Overflow_policy,
+		Watermark:          aggConfig.Watermark,
+		IdleTimeout:        uint(aggConfig.Idle_timeout),
+	}
+}
+
 func (table *Table) InitRewrite(config cfg.Config) error {
+	rewriters, err := table.rewritersFromConfig(config)
+	if err != nil {
+		return err
+	}
+	for _, rw := range rewriters {
+		table.AddRewriter(rw)
+	}
+
+	return nil
+}
+
+func (table *Table) rewritersFromConfig(config cfg.Config) ([]rewriter.RW, error) {
+	rewriters := make([]rewriter.RW, 0, len(config.Rewriter))
 	for i, rewriterConfig := range config.Rewriter {
 		rw, err := rewriter.New(rewriterConfig.Old, rewriterConfig.New, rewriterConfig.Not, rewriterConfig.Max)
 		if err != nil {
 			table.logger.Error("could not add rewriter", zap.Error(err))
-			return fmt.Errorf("could not add rewriter #%d", i+1)
+			return nil, fmt.Errorf("could not add rewriter #%d", i+1)
 		}
 
-		table.AddRewriter(rw)
+		rewriters = append(rewriters, rw)
 	}
 
-	return nil
+	return rewriters, nil
 }
 
 func (table *Table) InitRoutes(config cfg.Config, meta toml.MetaData) error {
 	for _, routeConfig := range config.Route {
-		routeConfigLogger := table.logger.With(zap.String("routeKey", routeConfig.Key))
-		switch routeConfig.Type {
-		case "sendAllMatch":
-			destinations, err := imperatives.ParseDestinations(routeConfig.Destinations, table, true, routeConfig.Key)
-			if err != nil {
-				routeConfigLogger.Error("could not parse destinations for route", zap.Error(err))
-				return fmt.Errorf("could not parse destinations for route '%s'", routeConfig.Key)
-			}
-			if len(destinations) == 0 {
-				routeConfigLogger.Error("must get at least 1 destination for route")
-				return fmt.Errorf("must get at least 1 destination for route '%s'", routeConfig.Key)
-			}
+		destinations, err := table.routeDestinations(routeConfig)
+		if err != nil {
+			return err
+		}
+		route, err := table.newRoute(routeConfig, destinations)
+		if err != nil {
+			return err
+		}
+		table.AddRoute(route)
+	}
 
-			route, err := route.NewSendAllMatch(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, destinations)
-			if err != nil {
-				routeConfigLogger.Error("error adding route", zap.Error(err))
-				return fmt.Errorf("error adding route '%s'", routeConfig.Key)
-			}
-			table.AddRoute(route)
-		case "sendFirstMatch":
-			destinations, err := imperatives.ParseDestinations(routeConfig.Destinations, table, true, routeConfig.Key)
-			if err != nil {
-				routeConfigLogger.Error("could not parse destinations for route", zap.Error(err))
-				return fmt.Errorf("could not parse destinations for route '%s'", routeConfig.Key)
-			}
-			if len(destinations) == 0 {
-				return fmt.Errorf("must get at least 1 destination for route '%s'", routeConfig.Key)
-			}
+	return nil
+}
 
-			route, err := route.NewSendFirstMatch(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, destinations)
-			if err != nil {
-				routeConfigLogger.Error("error adding route", zap.Error(err))
-				return fmt.Errorf("error adding route '%s'", routeConfig.Key)
-			}
-			table.AddRoute(route)
-		case "consistentHashing":
-			destinations, err := imperatives.ParseDestinations(routeConfig.Destinations, table, false, routeConfig.Key)
-			if err != nil {
-				routeConfigLogger.Error("could not parse destinations for route", zap.Error(err))
-				return fmt.Errorf("could not parse destinations for route '%s'", routeConfig.Key)
-			}
-			if len(destinations) < 2 {
-				return fmt.Errorf("must get at least 2 destination for route '%s'", routeConfig.Key)
-			}
-			routingMutator, err := route.NewRoutingMutator(routeConfig.RoutingMutations, routeConfig.CacheSize)
-			if err != nil {
-				routeConfigLogger.Error("can't create the routing mutator", zap.Error(err))
-				return fmt.Errorf("can't create the routing mutator: %s", err)
-			}
+// isCarbonRoute tells whether the route type is made of carbon destinations
+func isCarbonRoute(routeType string) bool {
+	return routeType == "sendAllMatch" || routeType == "sendFirstMatch" || routeType == "consistentHashing" || routeType == "loadBalance" || routeType == "failover"
+}
 
-			route, err := route.NewConsistentHashing(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, destinations, routingMutator)
-			if err != nil {
-				routeConfigLogger.Error("error adding route", zap.Error(err))
-				return fmt.Errorf("error adding route '%s'", routeConfig.Key)
-			}
-			table.AddRoute(route)
-		case "kafka":
-			kafkaCfg := routeConfig.Kafka
-			if kafkaCfg == nil {
-				return fmt.Errorf("error adding route '%s': kafka config is not specified", routeConfig.Key)
-			}
-			var codec kafka.CompressionCodec
-			switch codecStr := kafkaCfg.Codec; codecStr {
-			case "plain":
-				fallthrough
-			case "":
-				codec = nil
-			case "gzip":
-				codec = gzip.NewCompressionCodec()
-			case "snappy":
-				codec = snappy.NewCompressionCodec()
-			default:
-				return fmt.Errorf("error adding route '%s': unknown codec `%s`", routeConfig.Key, codecStr)
-			}
+// routeDestinations parses the destinations of a carbon route. They are not running yet
+func (table *Table) routeDestinations(routeConfig cfg.Route) ([]*destination.Destination, error) {
+	if !isCarbonRoute(routeConfig.Type) {
+		return nil, nil
+	}
+	routeConfigLogger := table.logger.With(zap.String("routeKey", routeConfig.Key))
+	destinations, err := imperatives.ParseDestinations(routeConfig.Destinations, table, routeConfig.Type != "consistentHashing", routeConfig.Key)
+	if err != nil {
+		routeConfigLogger.Error("could not parse destinations for route", zap.Error(err))
+		return nil, fmt.Errorf("could not parse destinations for route '%s'", routeConfig.Key)
+	}
+	if routeConfig.Type == "consistentHashing" {
+		if len(destinations) < 2 {
+			return nil, fmt.Errorf("must get at least 2 destination for route '%s'", routeConfig.Key)
+		}
+	} else if len(destinations) == 0 {
+		routeConfigLogger.Error("must get at least 1 destination for route")
+		return nil, fmt.Errorf("must get at least 1 destination for route '%s'", routeConfig.Key)
+	}
+	return destinations, nil
+}
 
-			if kafkaCfg.Brokers == nil || len(kafkaCfg.Brokers) == 0 {
-				return fmt.Errorf("error adding route '%s': brokers must be specified", routeConfig.Key)
-			}
+// newRoute creates and runs the route described by routeConfig.
+// destinations are only used by carbon routes, see routeDestinations
+func (table *Table) newRoute(routeConfig cfg.Route, destinations []*destination.Destination) (route.Route, error) {
+	routeConfigLogger := table.logger.With(zap.String("routeKey", routeConfig.Key))
+	switch routeConfig.Type {
+	case "sendAllMatch":
+		route, err := route.NewSendAllMatch(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, destinations)
+		if err != nil {
+			routeConfigLogger.Error("error adding route", zap.Error(err))
+			return nil, fmt.Errorf("error adding route '%s'", routeConfig.Key)
+		}
+		return route, nil
+	case "sendFirstMatch":
+		route, err := route.NewSendFirstMatch(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, destinations)
+		if err != nil {
+			routeConfigLogger.Error("error adding route", zap.Error(err))
+			return nil, fmt.Errorf("error adding route '%s'", routeConfig.Key)
+		}
+		return route, nil
+	case "loadBalance":
+		route, err := route.NewLoadBalance(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, routeConfig.Strategy, destinations)
+		if err != nil {
+			routeConfigLogger.Error("error adding route", zap.Error(err))
+			return nil, fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
+		}
+		return route, nil
+	case "failover":
+		route, err := route.NewFailover(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag,
+			time.Duration(routeConfig.DownPeriod)*time.Second, time.Duration(routeConfig.UpPeriod)*time.Second, destinations)
+		if err != nil {
+			routeConfigLogger.Error("error adding route", zap.Error(err))
+			return nil, fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
+		}
+		return route, nil
+	case "consistentHashing":
+		routingMutator, err := route.NewRoutingMutator(routeConfig.RoutingMutations, routeConfig.CacheSize)
+		if err != nil {
+			routeConfigLogger.Error("can't create the routing mutator", zap.Error(err))
+			return nil, fmt.Errorf("can't create the routing mutator: %s", err)
+		}
 
-			if kafkaCfg.Topic == "" {
-				return fmt.Errorf("error adding route '%s': topic must be set", routeConfig.Key)
-			}
+		route, err := route.NewConsistentHashing(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, destinations, routingMutator)
+		if err != nil {
+			routeConfigLogger.Error("error adding route", zap.Error(err))
+			return nil, fmt.Errorf("error adding route '%s'", routeConfig.Key)
+		}
+		return route, nil
+	case "kafka":
+		kafkaCfg := routeConfig.Kafka
+		if kafkaCfg == nil {
+			return nil, fmt.Errorf("error adding route '%s': kafka config is not specified", routeConfig.Key)
+		}
+		var codec kafka.CompressionCodec
+		switch codecStr := kafkaCfg.Codec; codecStr {
+		case "plain":
+			fallthrough
+		case "":
+			codec = nil
+		case "gzip":
+			codec = gzip.NewCompressionCodec()
+		case "snappy":
+			codec = snappy.NewCompressionCodec()
+		default:
+			return nil, fmt.Errorf("error adding route '%s': unknown codec `%s`", routeConfig.Key, codecStr)
+		}
 
-			var balancer kafka.Balancer
-			if kafkaCfg.HashBalance {
-				balancer = &kafka.Hash{}
-			}
+		if kafkaCfg.Brokers == nil || len(kafkaCfg.Brokers) == 0 {
+			return nil, fmt.Errorf("error adding route '%s': brokers must be specified", routeConfig.Key)
+		}
 
-			writerConfig := kafka.WriterConfig{
-				Brokers:          kafkaCfg.Brokers,
-				Topic:            kafkaCfg.Topic,
-				Balancer:         balancer,
-				CompressionCodec: codec,
-				BatchSize:        kafkaCfg.BatchSize,
-				BatchBytes:       kafkaCfg.BatchBytes,
-				BatchTimeout:     kafkaCfg.BatchTimeout,
-				RequiredAcks:     kafkaCfg.RequiredAcks,
-				Async:            !kafkaCfg.Synchronous,
-				QueueCapacity:    kafkaCfg.QueueCapacity,
-			}
+		if kafkaCfg.Topic == "" {
+			return nil, fmt.Errorf("error adding route '%s': topic must be set", routeConfig.Key)
+		}
 
-			routingMutator, err := route.NewRoutingMutator(routeConfig.RoutingMutations, routeConfig.CacheSize)
-			if err != nil {
-				routeConfigLogger.Error("can't create the routing mutator", zap.Error(err))
-				return fmt.Errorf("can't create the routing mutator: %s", err)
-			}
+		var balancer kafka.Balancer
+		if kafkaCfg.HashBalance {
+			balancer = &kafka.Hash{}
+		}
 
-			route, err := route.NewKafkaRoute(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, writerConfig, routingMutator)
-			if err != nil {
-				return fmt.Errorf("Failed to create route: %s", err)
-			}
-			table.AddRoute(route)
-		case "bg_metadata":
-			bgMetadataCfg := routeConfig.BgMetadata
-			if bgMetadataCfg == nil {
-				return fmt.Errorf("error adding route '%s': bg_metadata config is not specified", routeConfig.Key)
-			}
-			if bgMetadataCfg.ShardingFactor == 0 {
-				return fmt.Errorf("error adding route '%s': sharding factor must be specified", routeConfig.Key)
-			}
-			if bgMetadataCfg.FilterSize == 0 {
-				return fmt.Errorf("error adding route '%s': filter size must be specified", routeConfig.Key)
-			}
-			if bgMetadataCfg.FaultTolerance == 0 {
-				return fmt.Errorf("error adding route '%s': fault tolerance percentage must be specified", routeConfig.Key)
-			}
-			if bgMetadataCfg.FaultTolerance <= 0 || bgMetadataCfg.FaultTolerance >= 1 {
-				return fmt.Errorf("error adding route '%s': fault tolerance value must be between 0 and 1", routeConfig.Key)
-			}
+		writerConfig := kafka.WriterConfig{
+			Brokers:          kafkaCfg.Brokers,
+			Topic:            kafkaCfg.Topic,
+			Balancer:         balancer,
+			CompressionCodec: codec,
+			BatchSize:        kafkaCfg.BatchSize,
+			BatchBytes:       kafkaCfg.BatchBytes,
+			BatchTimeout:     kafkaCfg.BatchTimeout,
+			RequiredAcks:     kafkaCfg.RequiredAcks,
+			Async:            !kafkaCfg.Synchronous,
+			QueueCapacity:    kafkaCfg.QueueCapacity,
+		}
 
-			if bgMetadataCfg.ClearInterval == "" {
-				return fmt.Errorf("error adding route '%s': clear interval value must be specified", routeConfig.Key)
-			}
+		routingMutator, err := route.NewRoutingMutator(routeConfig.RoutingMutations, routeConfig.CacheSize)
+		if err != nil {
+			routeConfigLogger.Error("can't create the routing mutator", zap.Error(err))
+			return nil, fmt.Errorf("can't create the routing mutator: %s", err)
+		}
+
+		route, err := route.NewKafkaRoute(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, writerConfig, routingMutator)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to create route: %s", err)
+		}
+		return route, nil
+	case "bg_metadata":
+		bgMetadataCfg := routeConfig.BgMetadata
+		if bgMetadataCfg == nil {
+			return nil, fmt.Errorf("error adding route '%s': bg_metadata config is not specified", routeConfig.Key)
+		}
+		if bgMetadataCfg.ShardingFactor == 0 {
+			return nil, fmt.Errorf("error adding route '%s': sharding factor must be specified", routeConfig.Key)
+		}
+		if bgMetadataCfg.FilterSize == 0 {
+			return nil, fmt.Errorf("error adding route '%s': filter size must be specified", routeConfig.Key)
+		}
+		if bgMetadataCfg.FaultTolerance == 0 {
+			return nil, fmt.Errorf("error adding route '%s': fault tolerance percentage must be specified", routeConfig.Key)
+		}
+		if bgMetadataCfg.FaultTolerance <= 0 || bgMetadataCfg.FaultTolerance >= 1 {
+			return nil, fmt.Errorf("error adding route '%s': fault tolerance value must be between 0 and 1", routeConfig.Key)
+		}
+
+		if bgMetadataCfg.ClearInterval == "" {
+			return nil, fmt.Errorf("error adding route '%s': clear interval value must be specified", routeConfig.Key)
+		}
 
-			clearInterval, err := time.ParseDuration(bgMetadataCfg.ClearInterval)
+		clearInterval, err := time.ParseDuration(bgMetadataCfg.ClearInterval)
+		if err != nil {
+			return nil, fmt.Errorf("error adding route '%s': could not parse clear_interval", routeConfig.Key)
+		}
+
+		// clearWait is not required, so it's only parsed if it's defined
+		// if undefined, it's set to clearInterval/ShardingFactor as default
+		var clearWait time.Duration
+		if bgMetadataCfg.ClearWait != "" {
+			clearWait, err = time.ParseDuration(bgMetadataCfg.ClearWait)
 			if err != nil {
-				return fmt.Errorf("error adding route '%s': could not parse clear_interval", routeConfig.Key)
+				return nil, fmt.Errorf("error adding route '%s': could not parse clear_wait", routeConfig.Key)
 			}
+		}
 
-			// clearWait is not required, so it's only parsed if it's defined
-			// if undefined, it's set to clearInterval/ShardingFactor as default
-			var clearWait time.Duration
-			if bgMetadataCfg.ClearWait != "" {
-				clearWait, err = time.ParseDuration(bgMetadataCfg.ClearWait)
-				if err != nil {
-					return fmt.Errorf("error adding route '%s': could not parse clear_wait", routeConfig.Key)
-				}
-			}
+		if clearWait > clearInterval/time.Duration(bgMetadataCfg.ShardingFactor) {
+			return nil, fmt.Errorf("error adding route '%s': clear wait value must be less than clear_interval / sharding_factor", routeConfig.Key)
+		}
+		var additionnalCfg interface{} = nil
+		if bgMetadataCfg.Storage != "cassandra" && bgMetadataCfg.Storage != "elasticsearch" && bgMetadataCfg.Storage != "" {
+			return nil, fmt.Errorf("error adding route '%s': storage value must be 'cassandra', 'elasticsearch' or ''", routeConfig.Key)
+		}
 
-			if clearWait > clearInterval/time.Duration(bgMetadataCfg.ShardingFactor) {
-				return fmt.Errorf("error adding route '%s': clear wait value must be less than clear_interval / sharding_factor", routeConfig.Key)
+		if bgMetadataCfg.Storage == "elasticsearch" {
+			if bgMetadataCfg.ESConfig == nil {
+				return nil, fmt.Errorf("error adding route '%s': ElasticSearch configuration is needed", routeConfig.Key)
 			}
-			var additionnalCfg interface{} = nil
-			if bgMetadataCfg.Storage != "cassandra" && bgMetadataCfg.Storage != "elasticsearch" && bgMetadataCfg.Storage != "" {
-				return fmt.Errorf("error adding route '%s': storage value must be 'cassandra', 'elasticsearch' or ''", routeConfig.Key)
+			if bgMetadataCfg.ESConfig.StorageServer == "" {
+				return nil, fmt.Errorf("error adding route '%s': undefined storage server", routeConfig.Key)
 			}
-
-			if bgMetadataCfg.Storage == "elasticsearch" {
-				if bgMetadataCfg.ESConfig == nil {
-					return fmt.Errorf("error adding route '%s': ElasticSearch configuration is needed", routeConfig.Key)
-				}
-				if bgMetadataCfg.ESConfig.StorageServer == "" {
-					return fmt.Errorf("error adding route '%s': undefined storage server", routeConfig.Key)
-				}
-				if bgMetadataCfg.ESConfig.BulkSize == 0 {
-					return fmt.Errorf("error adding route '%s': elasticsearch bulk size must be > 0 (not %d)", routeConfig.Key, bgMetadataCfg.ESConfig.BulkSize)
-				}
-
-				additionnalCfg = bgMetadataCfg.ESConfig
+			if bgMetadataCfg.ESConfig.BulkSize == 0 {
+				return nil, fmt.Errorf("error adding route '%s': elasticsearch bulk size must be > 0 (not %d)", routeConfig.Key, bgMetadataCfg.ESConfig.BulkSize)
 			}
 
-			bloomFilterConfig, err := route.NewBloomFilterConfig(
-				bgMetadataCfg.FilterSize,
-				bgMetadataCfg.FaultTolerance,
-				bgMetadataCfg.ShardingFactor,
-				bgMetadataCfg.Cache,
-				clearInterval,
-				clearWait,
-			)
-			if err != nil {
-				return fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
-			}
-			route, err := route.NewBgMetadataRoute(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, bgMetadataCfg.StorageAggregationConfig, bgMetadataCfg.StorageSchemasConfig, bloomFilterConfig, bgMetadataCfg.Storage, additionnalCfg)
-			if err != nil {
-				return fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
-			}
-			table.AddRoute(route)
-		default:
-			return fmt.Errorf("unrecognized route type '%s'", routeConfig.Type)
+			additionnalCfg = bgMetadataCfg.ESConfig
 		}
-	}
 
-	return nil
+		bloomFilterConfig, err := route.NewBloomFilterConfig(
+			bgMetadataCfg.FilterSize,
+			bgMetadataCfg.FaultTolerance,
+			bgMetadataCfg.ShardingFactor,
+			bgMetadataCfg.Cache,
+			clearInterval,
+			clearWait,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
+		}
+		route, err := route.NewBgMetadataRoute(routeConfig.Key, routeConfig.Prefix, routeConfig.Substr, routeConfig.Regex, routeConfig.Tag, bgMetadataCfg.StorageAggregationConfig, bgMetadataCfg.StorageSchemasConfig, bloomFilterConfig, bgMetadataCfg.Storage, additionnalCfg)
+		if err != nil {
+			return nil, fmt.Errorf("error adding route '%s': %s", routeConfig.Key, err)
+		}
+		return route, nil
+	default:
+		return nil, fmt.Errorf("unrecognized route type '%s'", routeConfig.Type)
+	}
 }
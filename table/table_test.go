@@ -0,0 +1,53 @@
+package table
+
+import (
+	"testing"
+	"time"
+
+	"github.com/graphite-ng/carbon-relay-ng/destination"
+	"github.com/stretchr/testify/assert"
+)
+
+const addDestinationConfig = `
+bad_metrics_max_age = "24h"
+[[route]]
+key = 'all'
+type = 'sendAllMatch'
+destinations = ['127.0.0.1:1']
+
+[[route]]
+key = 'ch'
+type = 'consistentHashing'
+destinations = ['127.0.0.1:2', '127.0.0.1:4']
+`
+
+func testDestination(t *testing.T, table *Table, routeKey, prefix, addr string) *destination.Destination {
+	d, err := destination.New(routeKey, prefix, "", "", "", addr, table.SpoolDir, false, false, false, false, nil, nil, destination.TLSConfig{}, time.Second, time.Second, 10, 10, 10, 10, 10, time.Second, time.Millisecond, time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return d
+}
+
+func TestAddDestination(t *testing.T) {
+	config, meta := decodeConfig(t, addDestinationConfig)
+	table, err := InitFromConfig(config, meta)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Shutdown()
+
+	assert.Error(t, table.AddDestination("unknown", testDestination(t, table, "unknown", "", "127.0.0.1:3")))
+	assert.Error(t, table.AddDestination("all", testDestination(t, table, "all", "", "127.0.0.1:1")), "duplicate destination must be rejected")
+	assert.Error(t, table.AddDestination("ch", testDestination(t, table, "ch", "foo", "127.0.0.1:3")), "consistent hashing destinations can't have a matcher")
+
+	assert.NoError(t, table.AddDestination("all", testDestination(t, table, "all", "foo", "127.0.0.1:3")))
+	dests := table.GetRoute("all").GetDestinations()
+	if assert.Len(t, dests, 2) {
+		assert.Equal(t, "127.0.0.1:3", dests[1].Addr)
+		assert.NotNil(t, dests[1].In, "added destination must be running")
+	}
+
+	assert.NoError(t, table.AddDestination("ch", testDestination(t, table, "ch", "", "127.0.0.1:3")))
+	assert.Len(t, table.GetRoute("ch").GetDestinations(), 3)
+}
@@ -2,6 +2,7 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
 	"net/http/pprof"
@@ -45,7 +46,12 @@ func (fn handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	// check for errors
 	if err != nil {
 		//log.Printf("ERROR: %v", err.Error)
-		http.Error(w, fmt.Sprintf(`{"error":"%s"}`, err.Message+": "+err.Error.Error()), err.Code)
+		msg := err.Message
+		if err.Error != nil {
+			msg += ": " + err.Error.Error()
+		}
+		body, _ := json.Marshal(map[string]string{"error": msg})
+		http.Error(w, string(body), err.Code)
 		return
 	}
 	if response == nil {
@@ -66,10 +72,33 @@ func (fn handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	w.Write(bytes)
 }
 
+// saving wraps a handler which changes the table, to auto save the table after it succeeded
+func saving(fn handler) handler {
+	return func(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+		response, err := fn(w, r)
+		if err == nil {
+			table.AutoSave()
+		}
+		return response, err
+	}
+}
+
 func showConfig(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	return config, nil
 }
 
+// exportConfig serves the config file which recreates the current table, see Table.Export
+func exportConfig(w http.ResponseWriter, r *http.Request) {
+	data, err := table.ExportTOML()
+	if err != nil {
+		body, _ := json.Marshal(map[string]string{"error": "Could not export config: " + err.Error()})
+		http.Error(w, string(body), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/toml")
+	w.Write(data)
+}
+
 func listTable(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	t := table.Snapshot()
 	return t, nil
@@ -149,61 +178,127 @@ func removeRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerE
 	}
 	return make(map[string]string), nil
 }
-func parseRouteRequest(r *http.Request) (route.Route, *handlerError) {
-	var req struct {
-		Key                  string
-		Type                 string
-		Prefix               string
-		Substring            string
-		Regex                string
-		Address              string
-		Spool                bool
-		Pickle               bool
-		periodFlush          int
-		periodReconn         int
-		ConnBufSize          int
-		ConnIoBufSize        int
-		SpoolBufSize         int
-		SpoolMaxBytesPerFile int
-		SpoolSyncEvery       int
-		spoolSyncPeriod      int
-		SpoolSleep           int
-		UnspoolSleep         int
-	}
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+
+// destinationSettings are the settings of a new destination, besides its matcher.
+// the periods are in ms and the spool sleeps in µs. settings which are left out get the same defaults as in the config file
+type destinationSettings struct {
+	Address              string
+	Spool                bool
+	Pickle               bool
+	Internal             bool
+	Tags                 bool
+	TagsAllow            []string
+	TagsDeny             []string
+	TLS                  destination.TLSConfig
+	PeriodFlush          int
+	PeriodReconn         int
+	ConnBufSize          int
+	ConnIoBufSize        int
+	SpoolBufSize         int
+	SpoolMaxBytesPerFile int
+	SpoolSyncEvery       int
+	SpoolSyncPeriod      int
+	SpoolSleep           int
+	UnspoolSleep         int
+}
+
+func newDestinationSettings() destinationSettings {
+	return destinationSettings{
+		PeriodFlush:          1000,
+		PeriodReconn:         10000,
+		ConnBufSize:          30000,
+		ConnIoBufSize:        2000000,
+		SpoolBufSize:         10000,
+		SpoolMaxBytesPerFile: 200 * 1024 * 1024,
+		SpoolSyncEvery:       10000,
+		SpoolSyncPeriod:      1000,
+		SpoolSleep:           500,
+		UnspoolSleep:         10,
+	}
+}
+
+func (s destinationSettings) destination(routeKey, prefix, sub, regex, tag string) (*destination.Destination, *handlerError) {
+	if s.Address == "" {
+		return nil, &handlerError{errors.New("address is required"), "unable to create destination", http.StatusBadRequest}
+	}
+	if s.PeriodFlush <= 0 || s.PeriodReconn <= 0 || s.SpoolSyncPeriod <= 0 {
+		return nil, &handlerError{errors.New("periods must be > 0"), "unable to create destination", http.StatusBadRequest}
 	}
 	dest, err := destination.New(
-		req.Key,
-		"",
-		"",
-		"",
-		req.Address,
+		routeKey,
+		prefix,
+		sub,
+		regex,
+		tag,
+		s.Address,
 		table.SpoolDir,
-		req.Spool,
-		req.Pickle,
-		time.Duration(req.periodFlush)*time.Millisecond,
-		time.Duration(req.periodReconn)*time.Millisecond,
-		req.ConnBufSize,
-		req.ConnIoBufSize,
-		req.SpoolBufSize,
-		int64(req.SpoolMaxBytesPerFile),
-		int64(req.SpoolSyncEvery),
-		time.Duration(req.spoolSyncPeriod)*time.Millisecond,
-		time.Duration(req.SpoolSleep)*time.Microsecond,
-		time.Duration(req.UnspoolSleep)*time.Microsecond,
+		s.Spool,
+		s.Pickle,
+		s.Internal,
+		s.Tags,
+		s.TagsAllow,
+		s.TagsDeny,
+		s.TLS,
+		time.Duration(s.PeriodFlush)*time.Millisecond,
+		time.Duration(s.PeriodReconn)*time.Millisecond,
+		s.ConnBufSize,
+		s.ConnIoBufSize,
+		s.SpoolBufSize,
+		int64(s.SpoolMaxBytesPerFile),
+		int64(s.SpoolSyncEvery),
+		time.Duration(s.SpoolSyncPeriod)*time.Millisecond,
+		time.Duration(s.SpoolSleep)*time.Microsecond,
+		time.Duration(s.UnspoolSleep)*time.Microsecond,
 	)
 	if err != nil {
 		return nil, &handlerError{err, "unable to create destination", http.StatusBadRequest}
 	}
+	return dest, nil
+}
+
+// decodeStrict decodes the json body of r into v, rejecting unknown fields
+func decodeStrict(r *http.Request, v interface{}) *handlerError {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	}
+	return nil
+}
+
+func parseRouteRequest(r *http.Request) (route.Route, *handlerError) {
+	req := struct {
+		Key       string
+		Type      string
+		Prefix    string
+		Substring string
+		Regex     string
+		Tag       string
+		Strategy  string
+		// failover, in seconds
+		DownPeriod int
+		UpPeriod   int
+		destinationSettings
+	}{destinationSettings: newDestinationSettings()}
+	if err := decodeStrict(r, &req); err != nil {
+		return nil, err
+	}
+	dest, herr := req.destination(req.Key, "", "", "", "")
+	if herr != nil {
+		return nil, herr
+	}
 
 	var ro route.Route
 	var e error
 	switch req.Type {
 	case "sendAllMatch":
-		ro, e = route.NewSendAllMatch(req.Key, req.Prefix, req.Substring, req.Regex, []*destination.Destination{dest})
+		ro, e = route.NewSendAllMatch(req.Key, req.Prefix, req.Substring, req.Regex, req.Tag, []*destination.Destination{dest})
 	case "sendFirstMatch":
-		ro, e = route.NewSendFirstMatch(req.Key, req.Prefix, req.Substring, req.Regex, []*destination.Destination{dest})
+		ro, e = route.NewSendFirstMatch(req.Key, req.Prefix, req.Substring, req.Regex, req.Tag, []*destination.Destination{dest})
+	case "loadBalance":
+		ro, e = route.NewLoadBalance(req.Key, req.Prefix, req.Substring, req.Regex, req.Tag, req.Strategy, []*destination.Destination{dest})
+	case "failover":
+		ro, e = route.NewFailover(req.Key, req.Prefix, req.Substring, req.Regex, req.Tag, time.Duration(req.DownPeriod)*time.Second, time.Duration(req.UpPeriod)*time.Second, []*destination.Destination{dest})
 	default:
 		return nil, &handlerError{nil, "unknown route type: " + req.Type, http.StatusBadRequest}
 	}
@@ -215,20 +310,13 @@ func parseRouteRequest(r *http.Request) (route.Route, *handlerError) {
 
 func parseAggregateRequest(r *http.Request) (*aggregator.Aggregator, *handlerError) {
 	var request struct {
-		Fun       string
-		OutFmt    string
-		Cache     bool
-		Interval  uint
-		Wait      uint
-		DropRaw   bool
-		Regex     string
-		Prefix    string `json:"omitempty"`
-		Substring string `json:"omitempty"`
+		aggregator.Options
+		Type string // sent by the admin ui, ignored
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		return nil, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	if err := decodeStrict(r, &request); err != nil {
+		return nil, err
 	}
-	aggregate, err := aggregator.New(request.Fun, request.Regex, request.Prefix, request.Substring, request.OutFmt, request.Cache, request.Interval, request.Wait, request.DropRaw, table.In)
+	aggregate, err := aggregator.New(request.Options, table.In, table.LateIn)
 	if err != nil {
 		return nil, &handlerError{err, "Couldn't create aggregator", http.StatusBadRequest}
 	}
@@ -240,8 +328,8 @@ func parseRewriterRequest(r *http.Request) (rewriter.RW, *handlerError) {
 		New string
 		Max int
 	}
-	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-		return rewriter.RW{}, &handlerError{err, "Couldn't parse json", http.StatusBadRequest}
+	if err := decodeStrict(r, &request); err != nil {
+		return rewriter.RW{}, err
 	}
 	rw, err := rewriter.New(request.Old, request.New, "", request.Max)
 	if err != nil {
@@ -250,21 +338,6 @@ func parseRewriterRequest(r *http.Request) (rewriter.RW, *handlerError) {
 	return rw, nil
 }
 
-/* needs updating, but using what api?
-func updateRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
-	payload, err := parseRouteRequest(r)
-	if err != nil {
-		return nil, err
-	}
-
-	e := routes.Update(payload.Key, &payload.Addr, &payload.Patt)
-	if e != nil {
-		return nil, &handlerError{e, "Could not update route (" + e.Error() + ")", http.StatusBadRequest}
-	}
-	return routes.Map[payload.Key], nil
-}
-
-*/
 func addAggregate(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
 	aggregate, err := parseAggregateRequest(r)
 	if err != nil {
@@ -295,6 +368,133 @@ func addRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerErro
 	return map[string]string{"Message": "route added"}, nil
 }
 
+// getRouteDestination returns the route with the key and the index of the request, with a 404 if either doesn't exist
+func getRouteDestination(r *http.Request) (route.Route, int, *handlerError) {
+	key := mux.Vars(r)["key"]
+	index := mux.Vars(r)["index"]
+	ro := table.GetRoute(key)
+	if ro == nil {
+		return nil, 0, &handlerError{nil, "Could not find route " + key, http.StatusNotFound}
+	}
+	idx, err := strconv.Atoi(index)
+	if err != nil || idx < 0 {
+		return nil, 0, &handlerError{nil, "Could not find entry " + key + "/" + index, http.StatusNotFound}
+	}
+	if _, err := ro.GetDestination(idx); err != nil {
+		return nil, 0, &handlerError{nil, "Could not find entry " + key + "/" + index, http.StatusNotFound}
+	}
+	return ro, idx, nil
+}
+
+func addDestination(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	key := mux.Vars(r)["key"]
+	if table.GetRoute(key) == nil {
+		return nil, &handlerError{nil, "Could not find route " + key, http.StatusNotFound}
+	}
+	req := struct {
+		Prefix    string
+		Substring string
+		Regex     string
+		Tag       string
+		destinationSettings
+	}{destinationSettings: newDestinationSettings()}
+	if err := decodeStrict(r, &req); err != nil {
+		return nil, err
+	}
+	dest, err := req.destination(key, req.Prefix, req.Substring, req.Regex, req.Tag)
+	if err != nil {
+		return nil, err
+	}
+	if e := table.AddDestination(key, dest); e != nil {
+		return nil, &handlerError{e, "Could not add destination", http.StatusBadRequest}
+	}
+	return map[string]string{"Message": "destination added"}, nil
+}
+
+func updateDestination(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	ro, idx, herr := getRouteDestination(r)
+	if herr != nil {
+		return nil, herr
+	}
+	var req struct {
+		Address      *string
+		Prefix       *string
+		Substring    *string
+		Regex        *string
+		Tag          *string
+		Spool        *bool
+		Pickle       *bool
+		PeriodFlush  *int
+		PeriodReconn *int
+	}
+	if err := decodeStrict(r, &req); err != nil {
+		return nil, err
+	}
+	opts := make(map[string]string)
+	setOpt(opts, "addr", req.Address)
+	setOpt(opts, "prefix", req.Prefix)
+	setOpt(opts, "sub", req.Substring)
+	setOpt(opts, "regex", req.Regex)
+	setOpt(opts, "tag", req.Tag)
+	if req.Address != nil && *req.Address == "" {
+		return nil, &handlerError{errors.New("address can't be empty"), "Could not update destination", http.StatusBadRequest}
+	}
+	if req.Spool != nil {
+		opts["spool"] = strconv.FormatBool(*req.Spool)
+	}
+	if req.Pickle != nil {
+		opts["pickle"] = strconv.FormatBool(*req.Pickle)
+	}
+	if req.PeriodFlush != nil {
+		opts["flush"] = strconv.Itoa(*req.PeriodFlush)
+	}
+	if req.PeriodReconn != nil {
+		opts["reconn"] = strconv.Itoa(*req.PeriodReconn)
+	}
+	if len(opts) == 0 {
+		return nil, &handlerError{errors.New("no options given"), "Could not update destination", http.StatusBadRequest}
+	}
+	if err := table.UpdateDestination(ro.Key(), idx, opts); err != nil {
+		return nil, &handlerError{err, "Could not update destination", http.StatusBadRequest}
+	}
+	return map[string]string{"Message": "destination updated"}, nil
+}
+
+func updateRoute(w http.ResponseWriter, r *http.Request) (interface{}, *handlerError) {
+	key := mux.Vars(r)["key"]
+	if table.GetRoute(key) == nil {
+		return nil, &handlerError{nil, "Could not find route " + key, http.StatusNotFound}
+	}
+	var req struct {
+		Prefix    *string
+		Substring *string
+		Regex     *string
+		Tag       *string
+	}
+	if err := decodeStrict(r, &req); err != nil {
+		return nil, err
+	}
+	opts := make(map[string]string)
+	setOpt(opts, "prefix", req.Prefix)
+	setOpt(opts, "sub", req.Substring)
+	setOpt(opts, "regex", req.Regex)
+	setOpt(opts, "tag", req.Tag)
+	if len(opts) == 0 {
+		return nil, &handlerError{errors.New("no options given"), "Could not update route", http.StatusBadRequest}
+	}
+	if err := table.UpdateRoute(key, opts); err != nil {
+		return nil, &handlerError{err, "Could not update route", http.StatusBadRequest}
+	}
+	return map[string]string{"Message": "route updated"}, nil
+}
+
+// setOpt sets opts[name] to val, if it was given
+func setOpt(opts map[string]string, name string, val *string) {
+	if val != nil {
+		opts[name] = *val
+	}
+}
+
 func Start(addr string, c cfg.Config, t *tbl.Table, enableDebug bool) {
 	table = t
 	config = c
@@ -302,18 +502,21 @@ func Start(addr string, c cfg.Config, t *tbl.Table, enableDebug bool) {
 	router := mux.NewRouter()
 	router.Handle("/badMetrics/{timespec}.json", handler(badMetricsHandler)).Methods("GET")
 	router.Handle("/config", handler(showConfig)).Methods("GET")
+	router.HandleFunc("/config/export", exportConfig).Methods("GET")
 	router.Handle("/table", handler(listTable)).Methods("GET")
-	router.Handle("/blacklists/{index}", handler(removeBlacklist)).Methods("DELETE")
-	router.Handle("/rewriters/{index}", handler(removeRewriter)).Methods("DELETE")
-	router.Handle("/rewriters", handler(addRewrite)).Methods("POST")
-	router.Handle("/aggregators/{index}", handler(removeAggregator)).Methods("DELETE")
-	router.Handle("/aggregators", handler(addAggregate)).Methods("POST")
+	router.Handle("/blacklists/{index}", saving(removeBlacklist)).Methods("DELETE")
+	router.Handle("/rewriters/{index}", saving(removeRewriter)).Methods("DELETE")
+	router.Handle("/rewriters", saving(addRewrite)).Methods("POST")
+	router.Handle("/aggregators/{index}", saving(removeAggregator)).Methods("DELETE")
+	router.Handle("/aggregators", saving(addAggregate)).Methods("POST")
 	router.Handle("/routes", handler(listRoutes)).Methods("GET")
-	router.Handle("/routes", handler(addRoute)).Methods("POST")
+	router.Handle("/routes", saving(addRoute)).Methods("POST")
 	router.Handle("/routes/{key}", handler(getRoute)).Methods("GET")
-	//router.Handle("/routes/{key}", handler(updateRoute)).Methods("POST")
-	router.Handle("/routes/{key}", handler(removeRoute)).Methods("DELETE")
-	router.Handle("/routes/{key}/destinations/{index}", handler(removeDestination)).Methods("DELETE")
+	router.Handle("/routes/{key}", saving(updateRoute)).Methods("PATCH")
+	router.Handle("/routes/{key}", saving(removeRoute)).Methods("DELETE")
+	router.Handle("/routes/{key}/destinations", saving(addDestination)).Methods("POST")
+	router.Handle("/routes/{key}/destinations/{index}", saving(updateDestination)).Methods("PATCH")
+	router.Handle("/routes/{key}/destinations/{index}", saving(removeDestination)).Methods("DELETE")
 	if enableDebug {
 		zap.S().Info("Enabled debug endpoints on /debug/pprof")
 		router.HandleFunc("/debug/pprof/", pprof.Index)